@@ -1,11 +1,17 @@
 package config
 
 import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
 	"fmt"
 	"github.com/joho/godotenv"
 	"log"
 	"math"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,7 +21,7 @@ func LoadConfig(path string) IConfig {
 		log.Fatalf("load dotenv failed: %v", err)
 	}
 
-	return &config{
+	cfg := &config{
 		app: &app{
 			host: envMap["APP_HOST"],
 			port: func() int {
@@ -60,7 +66,105 @@ func LoadConfig(path string) IConfig {
 
 				return p
 			}(),
-			gcpBucket: envMap["APP_GCP_BUCKET"],
+			productPurgeAfter: func() time.Duration {
+				if envMap["APP_PRODUCT_PURGE_AFTER"] == "" {
+					return time.Hour * 24 * 30 // 30 days
+				}
+
+				p, err := strconv.Atoi(envMap["APP_PRODUCT_PURGE_AFTER"])
+				if err != nil {
+					log.Fatalf("load app product purge after failed %v", err)
+				}
+
+				return time.Duration(int64(p) * int64(math.Pow10(9)))
+			}(),
+			productPurgeInterval: func() time.Duration {
+				if envMap["APP_PRODUCT_PURGE_INTERVAL"] == "" {
+					return time.Hour
+				}
+
+				p, err := strconv.Atoi(envMap["APP_PRODUCT_PURGE_INTERVAL"])
+				if err != nil {
+					log.Fatalf("load app product purge interval failed %v", err)
+				}
+
+				return time.Duration(int64(p) * int64(math.Pow10(9)))
+			}(),
+			publicUrl: func() string {
+				if envMap["APP_PUBLIC_URL"] == "" {
+					return fmt.Sprintf("http://%s:%s", envMap["APP_HOST"], envMap["APP_PORT"])
+				}
+
+				return strings.TrimSuffix(envMap["APP_PUBLIC_URL"], "/")
+			}(),
+			passwordResetUrl: func() string {
+				if envMap["APP_PASSWORD_RESET_URL"] == "" {
+					return fmt.Sprintf("http://%s:%s/reset-password", envMap["APP_HOST"], envMap["APP_PORT"])
+				}
+
+				return envMap["APP_PASSWORD_RESET_URL"]
+			}(),
+			passwordResetExpires: func() time.Duration {
+				if envMap["APP_PASSWORD_RESET_EXPIRES"] == "" {
+					return time.Hour
+				}
+
+				p, err := strconv.Atoi(envMap["APP_PASSWORD_RESET_EXPIRES"])
+				if err != nil || p < 1 {
+					log.Fatalf("load app password reset expires failed, must be a positive number")
+				}
+
+				return time.Duration(int64(p) * int64(math.Pow10(9)))
+			}(),
+			twoFactorEnrolUrl: func() string {
+				if envMap["APP_TWO_FACTOR_ENROL_URL"] == "" {
+					return fmt.Sprintf("http://%s:%s/two-factor-enrol", envMap["APP_HOST"], envMap["APP_PORT"])
+				}
+
+				return envMap["APP_TWO_FACTOR_ENROL_URL"]
+			}(),
+			emailVerifyUrl: func() string {
+				if envMap["APP_EMAIL_VERIFY_URL"] == "" {
+					return fmt.Sprintf("http://%s:%s/v1/users/verify", envMap["APP_HOST"], envMap["APP_PORT"])
+				}
+
+				return envMap["APP_EMAIL_VERIFY_URL"]
+			}(),
+			emailVerifyExpires: func() time.Duration {
+				if envMap["APP_EMAIL_VERIFY_EXPIRES"] == "" {
+					return time.Hour * 24
+				}
+
+				p, err := strconv.Atoi(envMap["APP_EMAIL_VERIFY_EXPIRES"])
+				if err != nil || p < 1 {
+					log.Fatalf("load app email verify expires failed, must be a positive number")
+				}
+
+				return time.Duration(int64(p) * int64(math.Pow10(9)))
+			}(),
+			emailVerifyResendInterval: func() time.Duration {
+				if envMap["APP_EMAIL_VERIFY_RESEND_INTERVAL"] == "" {
+					return time.Minute
+				}
+
+				p, err := strconv.Atoi(envMap["APP_EMAIL_VERIFY_RESEND_INTERVAL"])
+				if err != nil || p < 0 {
+					log.Fatalf("load app email verify resend interval failed, must be a number")
+				}
+
+				return time.Duration(int64(p) * int64(math.Pow10(9)))
+			}(),
+			verifiedSignIn: loadBool(envMap, "APP_VERIFIED_SIGNIN", false),
+			verifiedOrders: loadBool(envMap, "APP_VERIFIED_ORDERS", false),
+			totpIssuer: func() string {
+				if envMap["APP_TOTP_ISSUER"] == "" {
+					return envMap["APP_NAME"]
+				}
+
+				return envMap["APP_TOTP_ISSUER"]
+			}(),
+			adminTwoFactor:            loadBool(envMap, "APP_ADMIN_TWO_FACTOR", false),
+			twoFactorChallengeExpires: time.Duration(int64(loadPositiveInt(envMap, "APP_TWO_FACTOR_CHALLENGE_EXPIRES", 300)) * int64(math.Pow10(9))),
 		},
 		db: &db{
 			host: envMap["DB_HOST"],
@@ -90,6 +194,14 @@ func LoadConfig(path string) IConfig {
 			adminKey:  envMap["JWT_ADMIN_KEY"],
 			secretKey: envMap["JWT_SECRET_KEY"],
 			apiKey:    envMap["JWT_API_KEY"],
+			tokenKey: func() string {
+				if envMap["APP_TOKEN_KEY"] != "" {
+					return envMap["APP_TOKEN_KEY"]
+				}
+
+				// Fallback for env files before APP_TOKEN_KEY
+				return envMap["JWT_SECRET_KEY"]
+			}(),
 			accessExpiresAt: func() int {
 				t, err := strconv.Atoi(envMap["JWT_ACCESS_EXPIRES"])
 				if err != nil {
@@ -106,45 +218,503 @@ func LoadConfig(path string) IConfig {
 
 				return t
 			}(),
+			algorithm: func() string {
+				switch envMap["JWT_ALGORITHM"] {
+				case "":
+					return JwtHS256
+				case JwtHS256, JwtRS256, JwtEdDSA:
+					return envMap["JWT_ALGORITHM"]
+				default:
+					log.Fatalf("load jwt algorithm failed, must be HS256, RS256 or EdDSA")
+				}
+
+				return ""
+			}(),
+		},
+		storage: &storage{
+			driver: func() string {
+				switch envMap["STORAGE_DRIVER"] {
+				case "":
+					return StorageLocal
+				case StorageLocal, StorageGCS, StorageS3, StorageMemory:
+					return envMap["STORAGE_DRIVER"]
+				default:
+					log.Fatalf("load storage driver failed, must be local, gcs, s3 or memory")
+				}
+
+				return ""
+			}(),
+			publicUrl: strings.TrimSuffix(envMap["STORAGE_PUBLIC_URL"], "/"),
+			localDir: func() string {
+				if envMap["STORAGE_LOCAL_DIR"] == "" {
+					return "./assets/images"
+				}
+
+				return strings.TrimSuffix(envMap["STORAGE_LOCAL_DIR"], "/")
+			}(),
+			gcsBucket: func() string {
+				if envMap["STORAGE_GCS_BUCKET"] == "" {
+					// Fallback for env files before storage drivers
+					return envMap["APP_GCP_BUCKET"]
+				}
+
+				return envMap["STORAGE_GCS_BUCKET"]
+			}(),
+			s3Endpoint:  envMap["STORAGE_S3_ENDPOINT"],
+			s3Region:    envMap["STORAGE_S3_REGION"],
+			s3Bucket:    envMap["STORAGE_S3_BUCKET"],
+			s3AccessKey: envMap["STORAGE_S3_ACCESS_KEY"],
+			s3SecretKey: envMap["STORAGE_S3_SECRET_KEY"],
+			s3UseSSL: func() bool {
+				if envMap["STORAGE_S3_USE_SSL"] == "" {
+					return true
+				}
+
+				b, err := strconv.ParseBool(envMap["STORAGE_S3_USE_SSL"])
+				if err != nil {
+					log.Fatalf("load storage s3 use ssl failed %v", err)
+				}
+
+				return b
+			}(),
+			privateDestinations: func() []string {
+				raw := envMap["STORAGE_PRIVATE_DESTINATIONS"]
+				if raw == "" {
+					raw = "slips,returns"
+				}
+
+				// Parts of resumable uploads are never public
+				destinations := []string{TusPartsDestination}
+				for _, d := range strings.Split(raw, ",") {
+					if d = strings.Trim(strings.TrimSpace(d), "/"); d != "" {
+						destinations = append(destinations, d)
+					}
+				}
+
+				return destinations
+			}(),
+			signingKey: func() []byte {
+				if envMap["STORAGE_SIGNING_KEY"] != "" {
+					return []byte(envMap["STORAGE_SIGNING_KEY"])
+				}
+
+				// Derive from the token key so existing env files keep working
+				base := envMap["APP_TOKEN_KEY"]
+				if base == "" {
+					base = envMap["JWT_SECRET_KEY"]
+				}
+				if base == "" {
+					log.Fatalf("load storage signing key failed, STORAGE_SIGNING_KEY or APP_TOKEN_KEY is required")
+				}
+
+				mac := hmac.New(sha256.New, []byte(base))
+				mac.Write([]byte("storage-signing-key"))
+				return mac.Sum(nil)
+			}(),
+			signedUrlExpires: func() time.Duration {
+				if envMap["STORAGE_SIGNED_URL_EXPIRES"] == "" {
+					return time.Minute * 15
+				}
+
+				p, err := strconv.Atoi(envMap["STORAGE_SIGNED_URL_EXPIRES"])
+				if err != nil || p < 1 {
+					log.Fatalf("load storage signed url expires failed, must be a positive number")
+				}
+
+				return time.Duration(int64(p) * int64(math.Pow10(9)))
+			}(),
+			orphanGrace: func() time.Duration {
+				if envMap["STORAGE_ORPHAN_GRACE"] == "" {
+					return time.Hour * 24
+				}
+
+				p, err := strconv.Atoi(envMap["STORAGE_ORPHAN_GRACE"])
+				if err != nil {
+					log.Fatalf("load storage orphan grace failed %v", err)
+				}
+
+				return time.Duration(int64(p) * int64(math.Pow10(9)))
+			}(),
+			tusMaxSize: func() int64 {
+				if envMap["STORAGE_TUS_MAX_SIZE"] == "" {
+					return 1 << 30 // 1 GiB
+				}
+
+				p, err := strconv.ParseInt(envMap["STORAGE_TUS_MAX_SIZE"], 10, 64)
+				if err != nil || p < 1 {
+					log.Fatalf("load storage tus max size failed, must be a positive number")
+				}
+
+				return p
+			}(),
+			tusExpires: func() time.Duration {
+				if envMap["STORAGE_TUS_EXPIRES"] == "" {
+					return time.Hour * 24
+				}
+
+				p, err := strconv.Atoi(envMap["STORAGE_TUS_EXPIRES"])
+				if err != nil || p < 1 {
+					log.Fatalf("load storage tus expires failed, must be a positive number")
+				}
+
+				return time.Duration(int64(p) * int64(math.Pow10(9)))
+			}(),
+			tusExtensions: func() []string {
+				raw := envMap["STORAGE_TUS_EXTENSIONS"]
+				if raw == "" {
+					raw = "png,jpg,jpeg,pdf,mp4,mov,webm"
+				}
+
+				exts := make([]string, 0)
+				for _, ext := range strings.Split(raw, ",") {
+					if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+						exts = append(exts, ext)
+					}
+				}
+
+				return exts
+			}(),
+			allowedTypes: func() map[string][]string {
+				// destination:ext,ext pairs separated by ";", * matches any destination
+				raw := envMap["STORAGE_ALLOWED_TYPES"]
+				if raw == "" {
+					raw = "*:png,jpg,jpeg,pdf,mp4,mov,webm;products:png,jpg,jpeg;slips:png,jpg,jpeg,pdf;returns:png,jpg,jpeg,pdf"
+				}
+
+				types := make(map[string][]string)
+				for _, pair := range strings.Split(raw, ";") {
+					destination, list, ok := strings.Cut(strings.TrimSpace(pair), ":")
+					if destination = strings.Trim(strings.TrimSpace(destination), "/"); !ok || destination == "" {
+						log.Fatalf("load storage allowed types failed, \"%s\" must be destination:ext,ext", pair)
+					}
+
+					exts := make([]string, 0)
+					for _, ext := range strings.Split(list, ",") {
+						if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+							exts = append(exts, ext)
+						}
+					}
+					types[destination] = exts
+				}
+
+				return types
+			}(),
+			sweepInterval: func() time.Duration {
+				if envMap["STORAGE_SWEEP_INTERVAL"] == "" {
+					return time.Hour
+				}
+
+				p, err := strconv.Atoi(envMap["STORAGE_SWEEP_INTERVAL"])
+				if err != nil || p < 1 {
+					log.Fatalf("load storage sweep interval failed, must be a positive number")
+				}
+
+				return time.Duration(int64(p) * int64(math.Pow10(9)))
+			}(),
+		},
+		scanner: &scanner{
+			driver: func() string {
+				switch envMap["SCANNER_DRIVER"] {
+				case "":
+					return ScannerNone
+				case ScannerNone, ScannerClamAV:
+					return envMap["SCANNER_DRIVER"]
+				default:
+					log.Fatalf("load scanner driver failed, must be none or clamav")
+				}
+
+				return ""
+			}(),
+			clamAVAddress: func() string {
+				if envMap["SCANNER_CLAMAV_ADDRESS"] == "" {
+					return "tcp://127.0.0.1:3310"
+				}
+
+				return envMap["SCANNER_CLAMAV_ADDRESS"]
+			}(),
+			timeout: func() time.Duration {
+				if envMap["SCANNER_TIMEOUT"] == "" {
+					return time.Second * 30
+				}
+
+				p, err := strconv.Atoi(envMap["SCANNER_TIMEOUT"])
+				if err != nil || p < 1 {
+					log.Fatalf("load scanner timeout failed, must be a positive number")
+				}
+
+				return time.Duration(int64(p) * int64(math.Pow10(9)))
+			}(),
+			maxSize: func() int64 {
+				if envMap["SCANNER_MAX_SIZE"] == "" {
+					return 25 << 20 // StreamMaxLength default of clamd
+				}
+
+				p, err := strconv.ParseInt(envMap["SCANNER_MAX_SIZE"], 10, 64)
+				if err != nil || p < 1 {
+					log.Fatalf("load scanner max size failed, must be a positive number")
+				}
+
+				return p
+			}(),
+			oversize: func() string {
+				switch envMap["SCANNER_OVERSIZE"] {
+				case "":
+					return ScannerOversizeReject
+				case ScannerOversizeReject, ScannerOversizeSkip:
+					return envMap["SCANNER_OVERSIZE"]
+				default:
+					log.Fatalf("load scanner oversize failed, must be reject or skip")
+				}
+
+				return ""
+			}(),
+		},
+		mail: &mail{
+			driver: func() string {
+				switch envMap["MAIL_DRIVER"] {
+				case "":
+					return MailStdout
+				case MailSMTP, MailFile, MailStdout:
+					return envMap["MAIL_DRIVER"]
+				default:
+					log.Fatalf("load mail driver failed, must be smtp, file or stdout")
+				}
+
+				return ""
+			}(),
+			from: func() string {
+				if envMap["MAIL_FROM"] == "" {
+					return "no-reply@localhost"
+				}
+
+				return envMap["MAIL_FROM"]
+			}(),
+			smtpHost: envMap["MAIL_SMTP_HOST"],
+			smtpPort: func() int {
+				if envMap["MAIL_SMTP_PORT"] == "" {
+					return 587
+				}
+
+				p, err := strconv.Atoi(envMap["MAIL_SMTP_PORT"])
+				if err != nil {
+					log.Fatalf("load mail smtp port failed %v", err)
+				}
+
+				return p
+			}(),
+			smtpUsername: envMap["MAIL_SMTP_USERNAME"],
+			smtpPassword: envMap["MAIL_SMTP_PASSWORD"],
+			fileDir: func() string {
+				if envMap["MAIL_FILE_DIR"] == "" {
+					return "./tmp/mails"
+				}
+
+				return strings.TrimSuffix(envMap["MAIL_FILE_DIR"], "/")
+			}(),
+		},
+		lockout: &lockout{
+			store: func() string {
+				switch envMap["LOCKOUT_STORE"] {
+				case "":
+					return LockoutPostgres
+				case LockoutPostgres, LockoutMemory:
+					return envMap["LOCKOUT_STORE"]
+				default:
+					log.Fatalf("load lockout store failed, must be postgres or memory")
+				}
+
+				return ""
+			}(),
+			threshold:   loadPositiveInt(envMap, "LOCKOUT_THRESHOLD", 5),
+			ipThreshold: loadPositiveInt(envMap, "LOCKOUT_IP_THRESHOLD", 20),
+			duration:    time.Duration(loadPositiveInt(envMap, "LOCKOUT_DURATION", 60)) * time.Second,
+			maxDuration: time.Duration(loadPositiveInt(envMap, "LOCKOUT_MAX_DURATION", 3600)) * time.Second,
+			window:      time.Duration(loadPositiveInt(envMap, "LOCKOUT_WINDOW", 3600)) * time.Second,
 		},
+		image: &image{
+			minWidth:  loadPositiveInt(envMap, "IMAGE_MIN_WIDTH", 1),
+			minHeight: loadPositiveInt(envMap, "IMAGE_MIN_HEIGHT", 1),
+			maxWidth:  loadPositiveInt(envMap, "IMAGE_MAX_WIDTH", 8000),
+			maxHeight: loadPositiveInt(envMap, "IMAGE_MAX_HEIGHT", 8000),
+			maxPixels: loadPositiveInt(envMap, "IMAGE_MAX_PIXELS", 40000000),
+			quality: func() int {
+				if envMap["IMAGE_QUALITY"] == "" {
+					return 85
+				}
+
+				q, err := strconv.Atoi(envMap["IMAGE_QUALITY"])
+				if err != nil || q < 1 || q > 100 {
+					log.Fatalf("load image quality failed, must be 1-100")
+				}
+
+				return q
+			}(),
+			webp: func() bool {
+				if envMap["IMAGE_WEBP"] == "" {
+					return true
+				}
+
+				b, err := strconv.ParseBool(envMap["IMAGE_WEBP"])
+				if err != nil {
+					log.Fatalf("load image webp failed %v", err)
+				}
+
+				return b
+			}(),
+			renditions: func() []*ImageRendition {
+				// name:size pairs, size is the longest edge in pixels
+				raw := envMap["IMAGE_RENDITIONS"]
+				if raw == "" {
+					raw = "thumbnail:200,medium:800,large:1600"
+				}
+
+				renditions := make([]*ImageRendition, 0)
+				for _, pair := range strings.Split(raw, ",") {
+					name, size, ok := strings.Cut(strings.TrimSpace(pair), ":")
+					if !ok || name == "" {
+						log.Fatalf("load image renditions failed, \"%s\" must be name:size", pair)
+					}
+
+					s, err := strconv.Atoi(size)
+					if err != nil || s < 1 {
+						log.Fatalf("load image renditions failed, size of \"%s\" must be a positive number", name)
+					}
+
+					renditions = append(renditions, &ImageRendition{Name: name, Size: s})
+				}
+
+				return renditions
+			}(),
+		},
+		oidc: &oidc{
+			providers:    loadOidcProviders(envMap),
+			stateExpires: time.Duration(loadPositiveInt(envMap, "OIDC_STATE_EXPIRES", 600)) * time.Second,
+		},
+	}
+
+	// Asymmetric keys are read from <JWT_KEYS_DIR>/<kid>.pem, every key in
+	// the directory verifies and JWT_SIGNING_KID picks the one that signs
+	if cfg.jwt.algorithm != JwtHS256 {
+		dir := envMap["JWT_KEYS_DIR"]
+		if dir == "" {
+			dir = "./keys"
+		}
+
+		if err := cfg.jwt.loadKeys(dir, envMap["JWT_SIGNING_KID"]); err != nil {
+			log.Fatalf("load jwt keys failed: %v", err)
+		}
+	} else if cfg.jwt.secretKey == "" {
+		log.Fatalf("load jwt secret key failed, JWT_SECRET_KEY is required for HS256")
+	}
+
+	// An empty key would make mailed, challenge and oidc state tokens forgeable
+	if cfg.jwt.tokenKey == "" {
+		log.Fatalf("load token key failed, APP_TOKEN_KEY is required unless JWT_SECRET_KEY is set")
 	}
+
+	return cfg
+}
+
+func loadBool(envMap map[string]string, key string, def bool) bool {
+	if envMap[key] == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(envMap[key])
+	if err != nil {
+		log.Fatalf("load %s failed %v", strings.ToLower(key), err)
+	}
+
+	return b
+}
+
+func loadPositiveInt(envMap map[string]string, key string, def int) int {
+	if envMap[key] == "" {
+		return def
+	}
+
+	p, err := strconv.Atoi(envMap[key])
+	if err != nil || p < 1 {
+		log.Fatalf("load %s failed, must be a positive number", strings.ToLower(key))
+	}
+
+	return p
 }
 
 type IConfig interface {
 	App() IAppConfig
 	Db() IDbConfig
 	Jwt() IJwtConfig
+	Storage() IStorageConfig
+	Scanner() IScannerConfig
+	Mail() IMailConfig
+	Lockout() ILockoutConfig
+	Image() IImageConfig
+	Oidc() IOidcConfig
 }
 
 type config struct {
-	app *app
-	db  *db
-	jwt *jwt
+	app     *app
+	db      *db
+	jwt     *jwt
+	storage *storage
+	scanner *scanner
+	mail    *mail
+	lockout *lockout
+	image   *image
+	oidc    *oidc
 }
 
 type IAppConfig interface {
-	Host() string // host:port
-	Port() int    // host:port
-	Url() string  // host:port
+	Host() string      // host:port
+	Port() int         // host:port
+	Url() string       // host:port
+	PublicUrl() string // scheme://host[:port] the api is reached at from outside
 	Name() string
 	Version() string
 	ReadTimeout() time.Duration
 	WriteTimeout() time.Duration
 	BodyLimit() int
 	FileLimit() int
-	GCPBucket() string
+	ProductPurgeAfter() time.Duration
+	ProductPurgeInterval() time.Duration
+	PasswordResetUrl() string // page receiving ?token=, sent by mail
+	PasswordResetExpires() time.Duration
+	EmailVerifyUrl() string // page or endpoint receiving ?token=, sent by mail
+	EmailVerifyExpires() time.Duration
+	EmailVerifyResendInterval() time.Duration
+	VerifiedSignIn() bool      // unverified accounts can not sign in
+	VerifiedOrders() bool      // unverified accounts can not place orders
+	TotpIssuer() string        // shown by authenticator apps, defaults to the app name
+	AdminTwoFactor() bool      // admins must enrol in 2fa before signing in
+	TwoFactorEnrolUrl() string // page receiving ?token=, mailed to admins who must enrol
+	TwoFactorChallengeExpires() time.Duration
 }
 
 type app struct {
-	host         string
-	port         int
-	name         string
-	version      string
-	readTimeout  time.Duration
-	writeTimeout time.Duration
-	bodyLimit    int // bytes
-	fileLimit    int // bytes
-	gcpBucket    string
+	host                 string
+	port                 int
+	name                 string
+	version              string
+	readTimeout          time.Duration
+	writeTimeout         time.Duration
+	bodyLimit            int           // bytes
+	fileLimit            int           // bytes
+	productPurgeAfter    time.Duration // sec
+	productPurgeInterval time.Duration // sec
+	publicUrl            string
+	passwordResetUrl     string
+	passwordResetExpires time.Duration // sec
+
+	emailVerifyUrl            string
+	emailVerifyExpires        time.Duration // sec
+	emailVerifyResendInterval time.Duration // sec
+	verifiedSignIn            bool
+	verifiedOrders            bool
+	totpIssuer                string
+	adminTwoFactor            bool
+	twoFactorEnrolUrl         string
+	twoFactorChallengeExpires time.Duration // sec
 }
 
 func (a *app) Host() string { return a.host }
@@ -155,6 +725,8 @@ func (a *app) Url() string {
 	return fmt.Sprintf("%s:%d", a.host, a.port)
 }
 
+func (a *app) PublicUrl() string { return a.publicUrl }
+
 func (a *app) Name() string { return a.name }
 
 func (a *app) Version() string { return a.version }
@@ -167,7 +739,31 @@ func (a *app) BodyLimit() int { return a.bodyLimit }
 
 func (a *app) FileLimit() int { return a.fileLimit }
 
-func (a *app) GCPBucket() string { return a.gcpBucket }
+func (a *app) ProductPurgeAfter() time.Duration { return a.productPurgeAfter }
+
+func (a *app) ProductPurgeInterval() time.Duration { return a.productPurgeInterval }
+
+func (a *app) PasswordResetUrl() string { return a.passwordResetUrl }
+
+func (a *app) PasswordResetExpires() time.Duration { return a.passwordResetExpires }
+
+func (a *app) EmailVerifyUrl() string { return a.emailVerifyUrl }
+
+func (a *app) EmailVerifyExpires() time.Duration { return a.emailVerifyExpires }
+
+func (a *app) EmailVerifyResendInterval() time.Duration { return a.emailVerifyResendInterval }
+
+func (a *app) VerifiedSignIn() bool { return a.verifiedSignIn }
+
+func (a *app) VerifiedOrders() bool { return a.verifiedOrders }
+
+func (a *app) TotpIssuer() string { return a.totpIssuer }
+
+func (a *app) AdminTwoFactor() bool { return a.adminTwoFactor }
+
+func (a *app) TwoFactorEnrolUrl() string { return a.twoFactorEnrolUrl }
+
+func (a *app) TwoFactorChallengeExpires() time.Duration { return a.twoFactorChallengeExpires }
 
 func (c *config) App() IAppConfig { return c.app }
 
@@ -205,22 +801,38 @@ func (c *config) Db() IDbConfig {
 	return c.db
 }
 
+const (
+	JwtHS256 = "HS256"
+	JwtRS256 = "RS256"
+	JwtEdDSA = "EdDSA"
+)
+
 type IJwtConfig interface {
 	SecretKey() []byte
 	AdminKey() []byte
 	ApiKey() []byte
+	TokenKey() []byte // signs email, two-factor challenge and oidc state tokens
 	AccessExpiresAt() int
 	RefreshExpiresAt() int
 	SetJwtAccessExpires(t int)
 	SetJwtRefreshExpires(t int)
+	Algorithm() string
+	SigningKey() (kid string, key crypto.Signer)
+	PublicKey(kid string) (crypto.PublicKey, bool)
+	PublicKeys() map[string]crypto.PublicKey
 }
 
 type jwt struct {
 	adminKey         string
 	secretKey        string
 	apiKey           string
+	tokenKey         string
 	accessExpiresAt  int // sec
 	refreshExpiresAt int // sec
+	algorithm        string
+	signingKid       string                      // RS256 and EdDSA only
+	signingKey       crypto.Signer               // RS256 and EdDSA only
+	publicKeys       map[string]crypto.PublicKey // kid -> key, RS256 and EdDSA only
 }
 
 func (j *jwt) SecretKey() []byte { return []byte(j.secretKey) }
@@ -229,6 +841,8 @@ func (j *jwt) AdminKey() []byte { return []byte(j.adminKey) }
 
 func (j *jwt) ApiKey() []byte { return []byte(j.apiKey) }
 
+func (j *jwt) TokenKey() []byte { return []byte(j.tokenKey) }
+
 func (j *jwt) AccessExpiresAt() int { return j.accessExpiresAt }
 
 func (j *jwt) RefreshExpiresAt() int { return j.refreshExpiresAt }
@@ -237,6 +851,350 @@ func (j *jwt) SetJwtAccessExpires(t int) { j.accessExpiresAt = t }
 
 func (j *jwt) SetJwtRefreshExpires(t int) { j.refreshExpiresAt = t }
 
+func (j *jwt) Algorithm() string { return j.algorithm }
+
+func (j *jwt) SigningKey() (string, crypto.Signer) { return j.signingKid, j.signingKey }
+
+func (j *jwt) PublicKey(kid string) (crypto.PublicKey, bool) {
+	key, ok := j.publicKeys[kid]
+	return key, ok
+}
+
+func (j *jwt) PublicKeys() map[string]crypto.PublicKey { return j.publicKeys }
+
 func (c *config) Jwt() IJwtConfig {
 	return c.jwt
 }
+
+const (
+	TusPartsDestination = "tus"
+
+	StorageLocal  = "local"
+	StorageGCS    = "gcs"
+	StorageS3     = "s3"
+	StorageMemory = "memory"
+)
+
+type IStorageConfig interface {
+	Driver() string    // local | gcs | s3 | memory
+	PublicUrl() string // base url of stored files, empty uses the driver default
+	LocalDir() string
+	GCSBucket() string
+	S3Endpoint() string // host[:port] of any S3-compatible service
+	S3Region() string
+	S3Bucket() string
+	S3AccessKey() string
+	S3SecretKey() string
+	S3UseSSL() bool
+	IsPrivate(destination string) bool // private files are reachable through signed urls only
+	SigningKey() []byte
+	SignedUrlExpires() time.Duration
+	OrphanGrace() time.Duration // unreferenced files younger than this are kept
+	SweepInterval() time.Duration
+	TusMaxSize() int64
+	TusExpires() time.Duration // incomplete resumable uploads are removed after
+	TusExtensions() []string
+	AllowedTypes(destination string) []string // file extensions
+}
+
+type storage struct {
+	driver      string
+	publicUrl   string
+	localDir    string
+	gcsBucket   string
+	s3Endpoint  string
+	s3Region    string
+	s3Bucket    string
+	s3AccessKey string
+	s3SecretKey string
+	s3UseSSL    bool
+
+	privateDestinations []string // folders, e.g. slips
+	signingKey          []byte
+	signedUrlExpires    time.Duration // sec
+	orphanGrace         time.Duration // sec
+	sweepInterval       time.Duration // sec
+	tusMaxSize          int64         // bytes
+	tusExpires          time.Duration // sec
+	tusExtensions       []string
+	allowedTypes        map[string][]string // destination -> extensions
+}
+
+func (s *storage) Driver() string { return s.driver }
+
+func (s *storage) PublicUrl() string { return s.publicUrl }
+
+func (s *storage) LocalDir() string { return s.localDir }
+
+func (s *storage) GCSBucket() string { return s.gcsBucket }
+
+func (s *storage) S3Endpoint() string { return s.s3Endpoint }
+
+func (s *storage) S3Region() string { return s.s3Region }
+
+func (s *storage) S3Bucket() string { return s.s3Bucket }
+
+func (s *storage) S3AccessKey() string { return s.s3AccessKey }
+
+func (s *storage) S3SecretKey() string { return s.s3SecretKey }
+
+func (s *storage) S3UseSSL() bool { return s.s3UseSSL }
+
+func (s *storage) IsPrivate(destination string) bool {
+	destination = strings.TrimPrefix(path.Clean("/"+destination), "/")
+	for _, d := range s.privateDestinations {
+		if strings.HasPrefix(destination, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *storage) SigningKey() []byte { return s.signingKey }
+
+func (s *storage) SignedUrlExpires() time.Duration { return s.signedUrlExpires }
+
+func (s *storage) OrphanGrace() time.Duration { return s.orphanGrace }
+
+func (s *storage) SweepInterval() time.Duration { return s.sweepInterval }
+
+func (s *storage) TusMaxSize() int64 { return s.tusMaxSize }
+
+func (s *storage) TusExpires() time.Duration { return s.tusExpires }
+
+func (s *storage) TusExtensions() []string { return s.tusExtensions }
+
+// AllowedTypes returns the extensions of the longest matching destination,
+// falling back to *
+func (s *storage) AllowedTypes(destination string) []string {
+	destination = strings.TrimPrefix(path.Clean("/"+destination), "/")
+
+	match := ""
+	for d := range s.allowedTypes {
+		if d != "*" && (destination == d || strings.HasPrefix(destination, d+"/")) && len(d) > len(match) {
+			match = d
+		}
+	}
+	if match == "" {
+		match = "*"
+	}
+
+	return s.allowedTypes[match]
+}
+
+func (c *config) Storage() IStorageConfig {
+	return c.storage
+}
+
+const (
+	ScannerNone   = "none"
+	ScannerClamAV = "clamav"
+
+	ScannerOversizeReject = "reject"
+	ScannerOversizeSkip   = "skip"
+)
+
+type IScannerConfig interface {
+	Driver() string         // none | clamav
+	ClamAVAddress() string  // tcp://host:port or unix:///path/to/clamd.sock
+	Timeout() time.Duration // per chunk sent to the scanner
+	MaxSize() int64         // must not exceed StreamMaxLength of clamd
+	Oversize() string       // reject | skip, files larger than MaxSize
+}
+
+type scanner struct {
+	driver        string
+	clamAVAddress string
+	timeout       time.Duration // sec
+	maxSize       int64         // bytes
+	oversize      string
+}
+
+func (s *scanner) Driver() string { return s.driver }
+
+func (s *scanner) ClamAVAddress() string { return s.clamAVAddress }
+
+func (s *scanner) Timeout() time.Duration { return s.timeout }
+
+func (s *scanner) MaxSize() int64 { return s.maxSize }
+
+func (s *scanner) Oversize() string { return s.oversize }
+
+func (c *config) Scanner() IScannerConfig {
+	return c.scanner
+}
+
+const (
+	MailSMTP   = "smtp"
+	MailFile   = "file"
+	MailStdout = "stdout"
+)
+
+type IMailConfig interface {
+	Driver() string // smtp | file | stdout
+	From() string
+	SMTPHost() string
+	SMTPPort() int
+	SMTPUsername() string
+	SMTPPassword() string
+	FileDir() string // mails are written as .eml files by the file driver
+}
+
+type mail struct {
+	driver       string
+	from         string
+	smtpHost     string
+	smtpPort     int
+	smtpUsername string
+	smtpPassword string
+	fileDir      string
+}
+
+func (m *mail) Driver() string { return m.driver }
+
+func (m *mail) From() string { return m.from }
+
+func (m *mail) SMTPHost() string { return m.smtpHost }
+
+func (m *mail) SMTPPort() int { return m.smtpPort }
+
+func (m *mail) SMTPUsername() string { return m.smtpUsername }
+
+func (m *mail) SMTPPassword() string { return m.smtpPassword }
+
+func (m *mail) FileDir() string { return m.fileDir }
+
+func (c *config) Mail() IMailConfig {
+	return c.mail
+}
+
+const (
+	LockoutPostgres = "postgres"
+	LockoutMemory   = "memory"
+)
+
+type ILockoutConfig interface {
+	Store() string           // postgres | memory, memory is per process
+	Threshold() int          // failed sign-ins of an account before it is locked
+	IpThreshold() int        // failed sign-ins from an ip before it is locked
+	Duration() time.Duration // first lockout, doubled on every further failure
+	MaxDuration() time.Duration
+	Window() time.Duration // failures older than this are forgotten
+}
+
+type lockout struct {
+	store       string
+	threshold   int
+	ipThreshold int
+	duration    time.Duration // sec
+	maxDuration time.Duration // sec
+	window      time.Duration // sec
+}
+
+func (l *lockout) Store() string { return l.store }
+
+func (l *lockout) Threshold() int { return l.threshold }
+
+func (l *lockout) IpThreshold() int { return l.ipThreshold }
+
+func (l *lockout) Duration() time.Duration { return l.duration }
+
+func (l *lockout) MaxDuration() time.Duration { return l.maxDuration }
+
+func (l *lockout) Window() time.Duration { return l.window }
+
+func (c *config) Lockout() ILockoutConfig {
+	return c.lockout
+}
+
+type IImageConfig interface {
+	MinWidth() int
+	MinHeight() int
+	MaxWidth() int
+	MaxHeight() int
+	MaxPixels() int // width * height, rejects decompression bombs
+	Quality() int
+	Webp() bool
+	Renditions() []*ImageRendition
+}
+
+type ImageRendition struct {
+	Name string
+	Size int // px, longest edge
+}
+
+type image struct {
+	minWidth   int // px
+	minHeight  int // px
+	maxWidth   int // px
+	maxHeight  int // px
+	maxPixels  int // px
+	quality    int // jpeg quality 1-100
+	webp       bool
+	renditions []*ImageRendition
+}
+
+func (i *image) MinWidth() int { return i.minWidth }
+
+func (i *image) MinHeight() int { return i.minHeight }
+
+func (i *image) MaxWidth() int { return i.maxWidth }
+
+func (i *image) MaxHeight() int { return i.maxHeight }
+
+func (i *image) MaxPixels() int { return i.maxPixels }
+
+func (i *image) Quality() int { return i.quality }
+
+func (i *image) Webp() bool { return i.webp }
+
+func (i *image) Renditions() []*ImageRendition { return i.renditions }
+
+func (c *config) Image() IImageConfig {
+	return c.image
+}
+
+type IOidcConfig interface {
+	Provider(name string) (*OidcProvider, bool)
+	Providers() []string
+	StateExpires() time.Duration // between the authorize redirect and the callback
+}
+
+// OidcProvider is an identity provider users can sign in with, the endpoints
+// are discovered from the issuer unless they are set
+type OidcProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectUrl  string // page of the frontend receiving ?code=&state=
+	Scopes       []string
+	AuthUrl      string
+	TokenUrl     string
+	JwksUrl      string
+	UserinfoUrl  string // used when the token response has no id_token
+}
+
+type oidc struct {
+	providers    map[string]*OidcProvider
+	stateExpires time.Duration // sec
+}
+
+func (o *oidc) Provider(name string) (*OidcProvider, bool) {
+	p, ok := o.providers[name]
+	return p, ok
+}
+
+func (o *oidc) Providers() []string {
+	names := make([]string, 0, len(o.providers))
+	for name := range o.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (o *oidc) StateExpires() time.Duration { return o.stateExpires }
+
+func (c *config) Oidc() IOidcConfig { return c.oidc }
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadKeys reads the key files of the jwt algorithm, a file holds a private
+// key or, once the private key is retired, only the public key. Rotating is
+// adding a new <kid>.pem, pointing JWT_SIGNING_KID at it and deleting the old
+// file after the longest lived token it signed has expired.
+func (j *jwt) loadKeys(dir, signingKid string) error {
+	if signingKid == "" {
+		return fmt.Errorf("jwt signing kid is required for %s", j.algorithm)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	j.publicKeys = make(map[string]crypto.PublicKey)
+	for _, p := range paths {
+		kid := strings.TrimSuffix(filepath.Base(p), ".pem")
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		private, public, err := parseKey(b)
+		if err != nil {
+			return fmt.Errorf("parse key %s failed: %v", kid, err)
+		}
+
+		if !j.matchesAlgorithm(public) {
+			return fmt.Errorf("key %s does not fit %s", kid, j.algorithm)
+		}
+
+		j.publicKeys[kid] = public
+		if kid == signingKid {
+			if private == nil {
+				return fmt.Errorf("signing key %s has no private key", kid)
+			}
+			j.signingKid, j.signingKey = kid, private
+		}
+	}
+
+	if j.signingKey == nil {
+		return fmt.Errorf("signing key %s not found in %s", signingKid, dir)
+	}
+
+	return nil
+}
+
+func (j *jwt) matchesAlgorithm(public crypto.PublicKey) bool {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return j.algorithm == JwtRS256
+	case ed25519.PublicKey:
+		return j.algorithm == JwtEdDSA
+	default:
+		return false
+	}
+}
+
+// parseKey accepts PKCS8 and PKCS1 private keys and PKIX public keys
+func parseKey(b []byte) (crypto.Signer, crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, nil, fmt.Errorf("pem block not found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, nil, fmt.Errorf("key type %T is not supported", key)
+		}
+		return signer, signer.Public(), nil
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, key.Public(), nil
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, key, nil
+	default:
+		return nil, nil, fmt.Errorf("pem type %s is not supported", block.Type)
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// loadOidcProviders reads OIDC_PROVIDERS, e.g. google,line, and the
+// OIDC_<NAME>_* keys of each. Providers without discovery, like facebook,
+// leave the issuer empty and set every endpoint instead.
+func loadOidcProviders(envMap map[string]string) map[string]*OidcProvider {
+	providers := make(map[string]*OidcProvider)
+	if envMap["OIDC_PROVIDERS"] == "" {
+		return providers
+	}
+
+	for _, name := range strings.Split(envMap["OIDC_PROVIDERS"], ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !oidcProviderName.MatchString(name) {
+			log.Fatalf("load oidc providers failed, \"%s\" must be letters or digits", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p := &OidcProvider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(envMap[prefix+"ISSUER"], "/"),
+			ClientID:     envMap[prefix+"CLIENT_ID"],
+			ClientSecret: envMap[prefix+"CLIENT_SECRET"],
+			RedirectUrl:  envMap[prefix+"REDIRECT_URL"],
+			Scopes:       strings.Fields(strings.ReplaceAll(envMap[prefix+"SCOPES"], ",", " ")),
+			AuthUrl:      envMap[prefix+"AUTH_URL"],
+			TokenUrl:     envMap[prefix+"TOKEN_URL"],
+			JwksUrl:      envMap[prefix+"JWKS_URL"],
+			UserinfoUrl:  envMap[prefix+"USERINFO_URL"],
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+
+		if p.ClientID == "" || p.RedirectUrl == "" {
+			log.Fatalf("load oidc provider %s failed, client id and redirect url are required", name)
+		}
+		if p.Issuer == "" && (p.AuthUrl == "" || p.TokenUrl == "" || (p.JwksUrl == "" && p.UserinfoUrl == "")) {
+			log.Fatalf("load oidc provider %s failed, issuer or auth, token and jwks or userinfo urls are required", name)
+		}
+
+		providers[name] = p
+	}
+
+	return providers
+}
@@ -0,0 +1,124 @@
+package addresses
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Address is an entry of the address book of a user, orders keep a copy
+// so editing or deleting it does not change placed orders
+type Address struct {
+	ID        string `db:"id" json:"id"`
+	UserID    string `db:"user_id" json:"-"`
+	Recipient string `db:"recipient" json:"recipient"`
+	Phone     string `db:"phone" json:"phone"`
+	Line1     string `db:"line1" json:"line1"`
+	Line2     string `db:"line2" json:"line2"`
+	District  string `db:"district" json:"district"`
+	Province  string `db:"province" json:"province"`
+	Postcode  string `db:"postcode" json:"postcode"`
+	Country   string `db:"country" json:"country"` // ISO 3166-1 alpha-2
+	IsDefault bool   `db:"is_default" json:"is_default"`
+	CreatedAt string `db:"created_at" json:"created_at,omitempty"`
+	UpdatedAt string `db:"updated_at" json:"updated_at,omitempty"`
+}
+
+// AddressReq adds or updates an address, on update the nil fields are kept
+type AddressReq struct {
+	ID        string  `json:"-" form:"-"`
+	UserID    string  `json:"-" form:"-"`
+	Recipient *string `json:"recipient" form:"recipient"`
+	Phone     *string `json:"phone" form:"phone"`
+	Line1     *string `json:"line1" form:"line1"`
+	Line2     *string `json:"line2" form:"line2"`
+	District  *string `json:"district" form:"district"`
+	Province  *string `json:"province" form:"province"`
+	Postcode  *string `json:"postcode" form:"postcode"`
+	Country   *string `json:"country" form:"country"`
+	IsDefault *bool   `json:"is_default" form:"is_default"`
+}
+
+var (
+	phonePattern    = regexp.MustCompile(`^\+?[0-9][0-9 -]{5,19}$`)
+	postcodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]{1,9}$`)
+	countryPattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// Apply copies the set fields of req onto a, trimmed and the country upper cased
+func (req *AddressReq) Apply(a *Address) {
+	for _, field := range []struct {
+		value *string
+		dest  *string
+	}{
+		{req.Recipient, &a.Recipient},
+		{req.Phone, &a.Phone},
+		{req.Line1, &a.Line1},
+		{req.Line2, &a.Line2},
+		{req.District, &a.District},
+		{req.Province, &a.Province},
+		{req.Postcode, &a.Postcode},
+		{req.Country, &a.Country},
+	} {
+		if field.value != nil {
+			*field.dest = strings.TrimSpace(*field.value)
+		}
+	}
+	a.Country = strings.ToUpper(a.Country)
+
+	if req.IsDefault != nil {
+		a.IsDefault = *req.IsDefault
+	}
+}
+
+func (a *Address) Validate() error {
+	for _, field := range []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"recipient", a.Recipient, 100},
+		{"line1", a.Line1, 200},
+		{"district", a.District, 100},
+		{"province", a.Province, 100},
+	} {
+		if field.value == "" {
+			return fmt.Errorf("%s is required", field.name)
+		}
+		if len(field.value) > field.max {
+			return fmt.Errorf("%s must be at most %d characters", field.name, field.max)
+		}
+	}
+
+	if len(a.Line2) > 200 {
+		return fmt.Errorf("line2 must be at most 200 characters")
+	}
+	if !phonePattern.MatchString(a.Phone) {
+		return fmt.Errorf("phone pattern is invalid")
+	}
+	if !postcodePattern.MatchString(a.Postcode) {
+		return fmt.Errorf("postcode pattern is invalid")
+	}
+	if !countryPattern.MatchString(a.Country) {
+		return fmt.Errorf("country must be an ISO 3166-1 alpha-2 code")
+	}
+
+	return nil
+}
+
+// Label is the one-line address orders keep in their address column
+func (a *Address) Label() string {
+	parts := make([]string, 0, 6)
+	for _, part := range []string{a.Line1, a.Line2, a.District, a.Province, a.Postcode, a.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Contact is the recipient orders keep in their contact column
+func (a *Address) Contact() string {
+	return fmt.Sprintf("%s %s", a.Recipient, a.Phone)
+}
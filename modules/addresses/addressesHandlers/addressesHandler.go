@@ -0,0 +1,103 @@
+package addressesHandlers
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/korvised/go-ecommerce/config"
+	"github.com/korvised/go-ecommerce/modules/addresses"
+	"github.com/korvised/go-ecommerce/modules/addresses/addressesRepositories"
+	"github.com/korvised/go-ecommerce/modules/addresses/addressesUsecases"
+	"github.com/korvised/go-ecommerce/modules/entities"
+	"strings"
+)
+
+type addressesHandlersErrCode string
+
+const (
+	findAddressesErr addressesHandlersErrCode = "addresses-001"
+	insertAddressErr addressesHandlersErrCode = "addresses-002"
+	updateAddressErr addressesHandlersErrCode = "addresses-003"
+	deleteAddressErr addressesHandlersErrCode = "addresses-004"
+)
+
+type IAddressesHandler interface {
+	FindAddresses(c *fiber.Ctx) error
+	InsertAddress(c *fiber.Ctx) error
+	UpdateAddress(c *fiber.Ctx) error
+	DeleteAddress(c *fiber.Ctx) error
+}
+
+type addressesHandler struct {
+	cfg              config.IConfig
+	addressesUsecase addressesUsecases.IAddressesUsecase
+}
+
+func AddressesHandler(cfg config.IConfig, addressesUsecase addressesUsecases.IAddressesUsecase) IAddressesHandler {
+	return &addressesHandler{
+		cfg:              cfg,
+		addressesUsecase: addressesUsecase,
+	}
+}
+
+func (h *addressesHandler) FindAddresses(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	data, err := h.addressesUsecase.FindAddresses(userID)
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(findAddressesErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, data).Res()
+}
+
+func (h *addressesHandler) InsertAddress(c *fiber.Ctx) error {
+	req := new(addresses.AddressReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(insertAddressErr), err.Error()).Res()
+	}
+	req.UserID = strings.TrimSpace(c.Params("user_id"))
+
+	address, err := h.addressesUsecase.InsertAddress(req)
+	if err != nil {
+		if errors.Is(err, addressesRepositories.ErrAddressLimit) {
+			return entities.NewResponse(c).Error(fiber.StatusConflict, string(insertAddressErr), err.Error()).Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(insertAddressErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusCreated, address).Res()
+}
+
+func (h *addressesHandler) UpdateAddress(c *fiber.Ctx) error {
+	req := new(addresses.AddressReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateAddressErr), err.Error()).Res()
+	}
+	req.UserID = strings.TrimSpace(c.Params("user_id"))
+	req.ID = strings.TrimSpace(c.Params("address_id"))
+
+	address, err := h.addressesUsecase.UpdateAddress(req)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateAddressErr), "address not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateAddressErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, address).Res()
+}
+
+func (h *addressesHandler) DeleteAddress(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+	addressID := strings.TrimSpace(c.Params("address_id"))
+
+	if err := h.addressesUsecase.DeleteAddress(userID, addressID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(deleteAddressErr), "address not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(deleteAddressErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
@@ -0,0 +1,269 @@
+package addressesRepositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/korvised/go-ecommerce/modules/addresses"
+	"time"
+)
+
+// MaxAddresses is the size of an address book
+const MaxAddresses = 20
+
+var ErrAddressLimit = fmt.Errorf("address book is full, at most %d addresses", MaxAddresses)
+
+type IAddressesRepository interface {
+	FindAddresses(userId string) ([]*addresses.Address, error)
+	FindOneAddress(userId, addressId string) (*addresses.Address, error)
+	FindDefaultAddress(userId string) (*addresses.Address, error)
+	InsertAddress(req *addresses.Address) (string, error)
+	UpdateAddress(req *addresses.Address) error
+	DeleteAddress(userId, addressId string) error
+}
+
+type addressesRepository struct {
+	db *sqlx.DB
+}
+
+func AddressesRepository(db *sqlx.DB) IAddressesRepository {
+	return &addressesRepository{
+		db: db,
+	}
+}
+
+const addressColumns = `id, user_id, recipient, phone, line1, line2, district, province, postcode, country, is_default, created_at, updated_at`
+
+func (r *addressesRepository) FindAddresses(userId string) ([]*addresses.Address, error) {
+	query := `
+	 SELECT ` + addressColumns + `
+	 FROM addresses
+	 WHERE user_id = $1
+	 ORDER BY is_default DESC, created_at;
+	`
+
+	data := make([]*addresses.Address, 0)
+	if err := r.db.Select(&data, query, userId); err != nil {
+		return nil, fmt.Errorf("find addresses failed: %v", err)
+	}
+
+	return data, nil
+}
+
+// FindOneAddress only finds the address in the book of the user
+func (r *addressesRepository) FindOneAddress(userId, addressId string) (*addresses.Address, error) {
+	query := `
+	 SELECT ` + addressColumns + `
+	 FROM addresses
+	 WHERE user_id = $1 AND id::VARCHAR = $2;
+	`
+
+	address := new(addresses.Address)
+	if err := r.db.Get(address, query, userId, addressId); err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+func (r *addressesRepository) FindDefaultAddress(userId string) (*addresses.Address, error) {
+	query := `
+	 SELECT ` + addressColumns + `
+	 FROM addresses
+	 WHERE user_id = $1 AND is_default;
+	`
+
+	address := new(addresses.Address)
+	if err := r.db.Get(address, query, userId); err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+// clearDefault lets the address be the only default of the user
+func clearDefault(ctx context.Context, tx *sqlx.Tx, userId, exceptId string) error {
+	query := `
+	 UPDATE addresses
+	 SET is_default = FALSE
+	 WHERE user_id = $1 AND is_default AND id::VARCHAR <> $2;
+	`
+
+	if _, err := tx.ExecContext(ctx, query, userId, exceptId); err != nil {
+		return fmt.Errorf("clear default address failed: %v", err)
+	}
+
+	return nil
+}
+
+// InsertAddress adds to the book, the first address becomes the default
+func (r *addressesRepository) InsertAddress(req *addresses.Address) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// Serialises inserts of the user so the limit and the default hold
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE;`, req.UserID); err != nil {
+		_ = tx.Rollback()
+		return "", fmt.Errorf("lock user failed: %v", err)
+	}
+
+	var count int
+	if err := tx.GetContext(ctx, &count, `SELECT COUNT(*) FROM addresses WHERE user_id = $1;`, req.UserID); err != nil {
+		_ = tx.Rollback()
+		return "", fmt.Errorf("count addresses failed: %v", err)
+	}
+	if count >= MaxAddresses {
+		_ = tx.Rollback()
+		return "", ErrAddressLimit
+	}
+
+	if req.IsDefault {
+		if err := clearDefault(ctx, tx, req.UserID, ""); err != nil {
+			_ = tx.Rollback()
+			return "", err
+		}
+	}
+
+	query := `
+	 INSERT INTO addresses (user_id, recipient, phone, line1, line2, district, province, postcode, country, is_default)
+	 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10 OR $11 = 0)
+	 RETURNING id;
+	`
+
+	var addressId string
+	if err := tx.QueryRowxContext(
+		ctx,
+		query,
+		req.UserID,
+		req.Recipient,
+		req.Phone,
+		req.Line1,
+		req.Line2,
+		req.District,
+		req.Province,
+		req.Postcode,
+		req.Country,
+		req.IsDefault,
+		count,
+	).Scan(&addressId); err != nil {
+		_ = tx.Rollback()
+		return "", fmt.Errorf("insert address failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+
+	return addressId, nil
+}
+
+// UpdateAddress replaces every field, the caller merges the request first
+func (r *addressesRepository) UpdateAddress(req *addresses.Address) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if req.IsDefault {
+		if err := clearDefault(ctx, tx, req.UserID, req.ID); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	query := `
+	 UPDATE addresses
+	 SET recipient = $3,
+	     phone = $4,
+	     line1 = $5,
+	     line2 = $6,
+	     district = $7,
+	     province = $8,
+	     postcode = $9,
+	     country = $10,
+	     is_default = $11
+	 WHERE user_id = $1 AND id::VARCHAR = $2;
+	`
+
+	result, err := tx.ExecContext(
+		ctx,
+		query,
+		req.UserID,
+		req.ID,
+		req.Recipient,
+		req.Phone,
+		req.Line1,
+		req.Line2,
+		req.District,
+		req.Province,
+		req.Postcode,
+		req.Country,
+		req.IsDefault,
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("update address failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		_ = tx.Rollback()
+		return sql.ErrNoRows
+	}
+
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+// DeleteAddress removes an address, deleting the default hands it to the
+// latest updated address left
+func (r *addressesRepository) DeleteAddress(userId, addressId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var wasDefault bool
+	query := `
+	 DELETE FROM addresses
+	 WHERE user_id = $1 AND id::VARCHAR = $2
+	 RETURNING is_default;
+	`
+	if err := tx.QueryRowxContext(ctx, query, userId, addressId).Scan(&wasDefault); err != nil {
+		_ = tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return fmt.Errorf("delete address failed: %v", err)
+	}
+
+	if wasDefault {
+		query = `
+		 UPDATE addresses
+		 SET is_default = TRUE
+		 WHERE id = (SELECT id FROM addresses WHERE user_id = $1 ORDER BY updated_at DESC LIMIT 1);
+		`
+		if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("set default address failed: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,69 @@
+package addressesUsecases
+
+import (
+	"github.com/korvised/go-ecommerce/modules/addresses"
+	"github.com/korvised/go-ecommerce/modules/addresses/addressesRepositories"
+)
+
+type IAddressesUsecase interface {
+	FindAddresses(userId string) ([]*addresses.Address, error)
+	InsertAddress(req *addresses.AddressReq) (*addresses.Address, error)
+	UpdateAddress(req *addresses.AddressReq) (*addresses.Address, error)
+	DeleteAddress(userId, addressId string) error
+}
+
+type addressesUsecase struct {
+	addressesRepository addressesRepositories.IAddressesRepository
+}
+
+func AddressesUsecase(addressesRepository addressesRepositories.IAddressesRepository) IAddressesUsecase {
+	return &addressesUsecase{
+		addressesRepository: addressesRepository,
+	}
+}
+
+func (u *addressesUsecase) FindAddresses(userId string) ([]*addresses.Address, error) {
+	return u.addressesRepository.FindAddresses(userId)
+}
+
+func (u *addressesUsecase) InsertAddress(req *addresses.AddressReq) (*addresses.Address, error) {
+	address := &addresses.Address{UserID: req.UserID}
+	req.Apply(address)
+	if err := address.Validate(); err != nil {
+		return nil, err
+	}
+
+	addressId, err := u.addressesRepository.InsertAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.addressesRepository.FindOneAddress(req.UserID, addressId)
+}
+
+// UpdateAddress validates the address as merged with the request, a partial
+// update must not leave a stored address incomplete
+func (u *addressesUsecase) UpdateAddress(req *addresses.AddressReq) (*addresses.Address, error) {
+	address, err := u.addressesRepository.FindOneAddress(req.UserID, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The default is moved by setting another address, not unset
+	wasDefault := address.IsDefault
+	req.Apply(address)
+	address.IsDefault = address.IsDefault || wasDefault
+	if err := address.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := u.addressesRepository.UpdateAddress(address); err != nil {
+		return nil, err
+	}
+
+	return u.addressesRepository.FindOneAddress(req.UserID, req.ID)
+}
+
+func (u *addressesUsecase) DeleteAddress(userId, addressId string) error {
+	return u.addressesRepository.DeleteAddress(userId, addressId)
+}
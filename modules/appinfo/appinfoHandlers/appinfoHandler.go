@@ -25,6 +25,7 @@ type IAppinfoHandler interface {
 	FindCategories(c *fiber.Ctx) error
 	AddCategories(c *fiber.Ctx) error
 	DeleteCategory(c *fiber.Ctx) error
+	FindJwks(c *fiber.Ctx) error
 }
 
 type appinfoHandler struct {
@@ -100,3 +101,10 @@ func (h *appinfoHandler) DeleteCategory(c *fiber.Ctx) error {
 
 	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
 }
+
+// FindJwks publishes the public keys tokens are verified with, it answers
+// in the plain JWKS format so other services can use stock jwt libraries
+func (h *appinfoHandler) FindJwks(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(auth.Jwks(h.cfg.Jwt()))
+}
@@ -1,7 +1,50 @@
 package entities
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
 type Image struct {
-	ID       string `db:"id" json:"id"`
-	FileName string `db:"filename" json:"filename"`
-	Url      string `db:"url" json:"url"`
+	ID         string          `db:"id" json:"id"`
+	FileName   string          `db:"filename" json:"filename"`
+	Url        string          `db:"url" json:"url"`
+	Position   int             `db:"position" json:"position"`
+	IsPrimary  bool            `db:"is_primary" json:"is_primary"`
+	AltText    string          `db:"alt_text" json:"alt_text"`
+	Renditions ImageRenditions `db:"renditions" json:"renditions"`
+}
+
+type ImageRendition struct {
+	Name     string `json:"name"`   // original | thumbnail | medium | large ...
+	Format   string `json:"format"` // jpeg | png | webp
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	FileName string `json:"filename"`
+	Url      string `json:"url"`
+}
+
+// ImageRenditions is stored as a jsonb array
+type ImageRenditions []*ImageRendition
+
+func (r ImageRenditions) Value() (driver.Value, error) {
+	if r == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(r)
+}
+
+func (r *ImageRenditions) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*r = make(ImageRenditions, 0)
+		return nil
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	default:
+		return fmt.Errorf("scan image renditions failed: unsupported type %T", src)
+	}
 }
@@ -1,19 +1,70 @@
 package files
 
-import "mime/multipart"
+import (
+	"github.com/korvised/go-ecommerce/modules/entities"
+	"mime/multipart"
+)
 
+// FileReq is a file to upload, Destination is the directory on the way in,
+// the usecase names the file after the sha256 of its content.
 type FileReq struct {
 	File        *multipart.FileHeader `form:"file"`
 	Destination string                `form:"destination"`
 	Extension   string
 	FileName    string
+	OwnerID     string
 }
 
 type FileRes struct {
-	FileName string `json:"filename"`
-	Url      string `json:"url"`
+	FileName    string                   `json:"filename"`
+	Destination string                   `json:"destination"`
+	Url         string                   `json:"url"`
+	Checksum    string                   `json:"checksum"`             // sha256 hex of the uploaded content
+	Private     bool                     `json:"private"`              // url is signed and expires
+	ExpiresAt   string                   `json:"expires_at,omitempty"` // private only
+	Renditions  entities.ImageRenditions `json:"renditions,omitempty"` // images only
 }
 
 type DeleteFileReq struct {
 	Destination string `json:"destination"`
 }
+
+// File is the registry entry of an uploaded file, RefCount is kept by
+// triggers on the tables referencing it. Identical uploads share one entry.
+type File struct {
+	ID          string                   `db:"id" json:"id"`
+	OwnerID     *string                  `db:"owner_id" json:"owner_id"`
+	StorageKey  string                   `db:"storage_key" json:"storage_key"`
+	FileName    string                   `db:"filename" json:"filename"`
+	Url         string                   `db:"url" json:"url"`
+	ContentType string                   `db:"content_type" json:"content_type"`
+	Size        int64                    `db:"size" json:"size"`
+	Checksum    string                   `db:"checksum" json:"checksum"` // sha256 hex
+	Private     bool                     `db:"private" json:"private"`
+	RefCount    int                      `db:"ref_count" json:"ref_count"`
+	Renditions  entities.ImageRenditions `db:"renditions" json:"renditions"`
+	CreatedAt   string                   `db:"created_at" json:"created_at"`
+	UpdatedAt   string                   `db:"updated_at" json:"updated_at"`
+}
+
+// Upload is a resumable upload following the tus protocol
+type Upload struct {
+	ID          string  `db:"id" json:"id"`
+	OwnerID     string  `db:"owner_id" json:"owner_id"`
+	FileName    string  `db:"filename" json:"filename"`
+	Destination string  `db:"destination" json:"destination"`
+	ContentType string  `db:"content_type" json:"content_type"`
+	Length      int64   `db:"length" json:"length"`
+	Offset      int64   `db:"upload_offset" json:"offset"`
+	Parts       int     `db:"parts" json:"-"`
+	FileID      *string `db:"file_id" json:"file_id"` // set once completed
+	WriteToken  *string `db:"write_token" json:"-"`   // set while a chunk is stored
+	Completing  bool    `db:"completing" json:"completing"`
+	Error       string  `db:"error" json:"error,omitempty"` // last completion failure
+	Rejected    bool    `db:"rejected" json:"rejected"`     // content refused, the parts are gone
+	ExpiresAt   string  `db:"expires_at" json:"expires_at"`
+	CreatedAt   string  `db:"created_at" json:"created_at"`
+	UpdatedAt   string  `db:"updated_at" json:"updated_at"`
+}
+
+func (obj *Upload) Completed() bool { return obj.FileID != nil }
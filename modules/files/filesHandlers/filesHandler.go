@@ -1,28 +1,60 @@
 package filesHandlers
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/korvised/go-ecommerce/config"
 	"github.com/korvised/go-ecommerce/modules/entities"
 	"github.com/korvised/go-ecommerce/modules/files"
+	"github.com/korvised/go-ecommerce/modules/files/filesRepositories"
+	"github.com/korvised/go-ecommerce/modules/files/filesScanners"
+	"github.com/korvised/go-ecommerce/modules/files/filesStorages"
 	"github.com/korvised/go-ecommerce/modules/files/filesUsecases"
+	"github.com/korvised/go-ecommerce/modules/middlewares/middlewaresHandlers"
+	"github.com/korvised/go-ecommerce/pkg/imaging"
 	"github.com/korvised/go-ecommerce/pkg/utils"
 	"math"
+	"mime"
+	"net/url"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type filesHandlersErrCode string
 
 const (
-	uploadFileErr filesHandlersErrCode = "files-001"
-	deleteFileErr filesHandlersErrCode = "files-002"
+	uploadFileErr   filesHandlersErrCode = "files-001"
+	deleteFileErr   filesHandlersErrCode = "files-002"
+	downloadFileErr filesHandlersErrCode = "files-003"
+	createUploadErr filesHandlersErrCode = "files-004"
+	findUploadErr   filesHandlersErrCode = "files-005"
+	appendUploadErr filesHandlersErrCode = "files-006"
+	deleteUploadErr filesHandlersErrCode = "files-007"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
 )
 
 type IFilesHandler interface {
 	UploadFile(c *fiber.Ctx) error
 	DeleteFile(c *fiber.Ctx) error
+	DownloadPrivateFile(c *fiber.Ctx) error
+	TusOptions(c *fiber.Ctx) error
+	TusResumable() fiber.Handler
+	CreateUpload(c *fiber.Ctx) error
+	HeadUpload(c *fiber.Ctx) error
+	AppendUpload(c *fiber.Ctx) error
+	DeleteUpload(c *fiber.Ctx) error
+	FindOneUpload(c *fiber.Ctx) error
 }
 
 type filesHandler struct {
@@ -47,12 +79,12 @@ func (h filesHandler) UploadFile(c *fiber.Ctx) error {
 
 	filesReq := form.File["files"]
 	destination := c.FormValue("destination")
+	userID, _ := c.Locals(middlewaresHandlers.UserID).(string)
 
-	// Files ext validation
-	extMap := map[string]string{
-		"png":  "png",
-		"jpg":  "jpg",
-		"jpeg": "jpeg",
+	// Files ext validation, the content is checked against it by the usecase
+	extMap := make(map[string]string)
+	for _, ext := range h.cfg.Storage().AllowedTypes(destination) {
+		extMap[ext] = ext
 	}
 
 	for _, file := range filesReq {
@@ -75,18 +107,25 @@ func (h filesHandler) UploadFile(c *fiber.Ctx) error {
 			).Res()
 		}
 
-		filename := utils.RandFileName(ext)
-
+		// Named after the content by the usecase
 		req = append(req, &files.FileReq{
 			File:        file,
-			FileName:    filename,
-			Destination: destination + "/" + filename,
+			Destination: destination,
 			Extension:   ext,
+			OwnerID:     userID,
 		})
 	}
 
 	res, err := h.filesUsecase.UploadToStorage(req)
 	if err != nil {
+		if errors.Is(err, imaging.ErrInvalidImage) ||
+			errors.Is(err, filesUsecases.ErrFileRejected) ||
+			errors.Is(err, filesScanners.ErrInfected) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(uploadFileErr), err.Error()).Res()
+		}
+		if errors.Is(err, filesScanners.ErrTooLargeToScan) {
+			return entities.NewResponse(c).Error(fiber.StatusRequestEntityTooLarge, string(uploadFileErr), err.Error()).Res()
+		}
 		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(uploadFileErr), err.Error()).Res()
 	}
 
@@ -105,3 +144,241 @@ func (h filesHandler) DeleteFile(c *fiber.Ctx) error {
 
 	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
 }
+
+func (h filesHandler) DownloadPrivateFile(c *fiber.Ctx) error {
+	destination, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(downloadFileErr), err.Error()).Res()
+	}
+
+	file, contentType, err := h.filesUsecase.OpenPrivateFile(destination, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		switch {
+		case errors.Is(err, filesStorages.ErrInvalidSignature), errors.Is(err, filesStorages.ErrUrlExpired):
+			return entities.NewResponse(c).Error(fiber.StatusForbidden, string(downloadFileErr), err.Error()).Res()
+		case errors.Is(err, filesStorages.ErrNotFound):
+			return entities.NewResponse(c).Error(fiber.StatusNotFound, string(downloadFileErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(downloadFileErr), err.Error()).Res()
+		}
+	}
+
+	// Signed urls must not end up in shared caches
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.SendStream(file)
+}
+
+func (h filesHandler) TusOptions(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	c.Set("Tus-Max-Size", strconv.FormatInt(h.cfg.Storage().TusMaxSize(), 10))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// TusResumable rejects clients speaking another version of the tus protocol
+func (h filesHandler) TusResumable() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("Tus-Resumable", tusVersion)
+		if c.Get("Tus-Resumable") != tusVersion {
+			c.Set("Tus-Version", tusVersion)
+			return entities.NewResponse(c).Error(
+				fiber.StatusPreconditionFailed,
+				string(createUploadErr),
+				fmt.Sprintf("tus version %s is required", tusVersion),
+			).Res()
+		}
+		return c.Next()
+	}
+}
+
+// parseUploadMetadata decodes "key base64value,key base64value"
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %s is not base64", key)
+		}
+		metadata[key] = string(decoded)
+	}
+
+	return metadata, nil
+}
+
+func (h filesHandler) CreateUpload(c *fiber.Ctx) error {
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(createUploadErr), "upload length is required").Res()
+	}
+
+	metadata, err := parseUploadMetadata(c.Get("Upload-Metadata"))
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(createUploadErr), err.Error()).Res()
+	}
+
+	destination := strings.Trim(metadata["destination"], "/")
+	if destination == "" {
+		destination = "uploads"
+	}
+
+	// Accepted by tus and by the destination
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(metadata["filename"]), "."))
+	if !slices.Contains(h.cfg.Storage().TusExtensions(), ext) ||
+		!slices.Contains(h.cfg.Storage().AllowedTypes(destination), ext) {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(createUploadErr), "files are not acceptable").Res()
+	}
+
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = mime.TypeByExtension("." + ext)
+	}
+
+	// Temporary name, the upload is renamed after its content once completed
+	filename := utils.RandFileName(ext)
+	upload, err := h.filesUsecase.CreateUpload(&files.Upload{
+		OwnerID:     c.Locals(middlewaresHandlers.UserID).(string),
+		FileName:    filename,
+		Destination: destination + "/" + filename,
+		ContentType: contentType,
+		Length:      length,
+	})
+	if err != nil {
+		if errors.Is(err, filesUsecases.ErrUploadTooLarge) {
+			return entities.NewResponse(c).Error(fiber.StatusRequestEntityTooLarge, string(createUploadErr), err.Error()).Res()
+		}
+		if errors.Is(err, filesUsecases.ErrFileRejected) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(createUploadErr), err.Error()).Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(createUploadErr), err.Error()).Res()
+	}
+
+	c.Location(fmt.Sprintf("%s/v1/files/tus/%s", c.BaseURL(), upload.ID))
+	setUploadExpires(c, upload)
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+func setUploadExpires(c *fiber.Ctx, upload *files.Upload) {
+	expiresAt, err := time.ParseInLocation("2006-01-02 15:04:05", upload.ExpiresAt, time.Local)
+	if err == nil {
+		c.Set("Upload-Expires", expiresAt.UTC().Format(time.RFC1123))
+	}
+}
+
+// findOwnUpload hides uploads of other users as not found
+func (h filesHandler) findOwnUpload(c *fiber.Ctx) (*files.Upload, error) {
+	upload, err := h.filesUsecase.FindOneUpload(strings.Trim(c.Params("upload_id"), " "))
+	if err != nil {
+		return nil, err
+	}
+
+	if upload.OwnerID != c.Locals(middlewaresHandlers.UserID).(string) {
+		return nil, sql.ErrNoRows
+	}
+
+	return upload, nil
+}
+
+func (h filesHandler) HeadUpload(c *fiber.Ctx) error {
+	upload, err := h.findOwnUpload(c)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	setUploadExpires(c, upload)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// AppendUpload receives one chunk, chunks are limited by APP_BODY_LIMIT so
+// memory stays bounded whatever the file size.
+func (h filesHandler) AppendUpload(c *fiber.Ctx) error {
+	if c.Get(fiber.HeaderContentType) != "application/offset+octet-stream" {
+		return entities.NewResponse(c).Error(
+			fiber.StatusUnsupportedMediaType,
+			string(appendUploadErr),
+			"content type must be application/offset+octet-stream",
+		).Res()
+	}
+
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(appendUploadErr), "upload offset is required").Res()
+	}
+
+	upload, err := h.findOwnUpload(c)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusNotFound, string(appendUploadErr), "upload not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(appendUploadErr), err.Error()).Res()
+	}
+
+	upload, err = h.filesUsecase.AppendUpload(upload.ID, offset, bytes.NewReader(c.Body()))
+	if err != nil {
+		switch {
+		case errors.Is(err, filesRepositories.ErrOffsetMismatch), errors.Is(err, filesRepositories.ErrUploadBusy):
+			return entities.NewResponse(c).Error(fiber.StatusConflict, string(appendUploadErr), err.Error()).Res()
+		case errors.Is(err, filesUsecases.ErrUploadTooLarge):
+			return entities.NewResponse(c).Error(fiber.StatusRequestEntityTooLarge, string(appendUploadErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(appendUploadErr), err.Error()).Res()
+		}
+	}
+
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	setUploadExpires(c, upload)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h filesHandler) DeleteUpload(c *fiber.Ctx) error {
+	upload, err := h.findOwnUpload(c)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusNotFound, string(deleteUploadErr), "upload not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(deleteUploadErr), err.Error()).Res()
+	}
+
+	if err := h.filesUsecase.TerminateUpload(upload.ID); err != nil {
+		if errors.Is(err, filesRepositories.ErrUploadBusy) {
+			return entities.NewResponse(c).Error(fiber.StatusConflict, string(deleteUploadErr), err.Error()).Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(deleteUploadErr), err.Error()).Res()
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// FindOneUpload returns the upload progress and the file once completed
+func (h filesHandler) FindOneUpload(c *fiber.Ctx) error {
+	upload, err := h.findOwnUpload(c)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusNotFound, string(findUploadErr), "upload not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(findUploadErr), err.Error()).Res()
+	}
+
+	res := fiber.Map{"upload": upload, "file": nil}
+	if upload.Completed() {
+		file, err := h.filesUsecase.FindUploadedFile(upload)
+		if err != nil {
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(findUploadErr), err.Error()).Res()
+		}
+		res["file"] = file
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
@@ -0,0 +1,476 @@
+package filesRepositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/korvised/go-ecommerce/modules/files"
+	"time"
+)
+
+var (
+	// ErrOffsetMismatch is returned when a chunk does not continue the upload
+	ErrOffsetMismatch = errors.New("upload offset does not match")
+
+	// ErrUploadBusy is returned while another request stores a chunk or the
+	// parts are being joined
+	ErrUploadBusy = errors.New("upload is busy")
+)
+
+type IFilesRepository interface {
+	InsertFile(req *files.File) error
+	ReuseFile(storageKey string) (*files.File, error)
+	FindOwnedFile(ownerID, storageKey string) (*files.File, error)
+	FindReferencedKeys(keys []string) ([]string, error)
+	DeleteFilesByKey(keys []string) error
+	DeleteOrphanFiles(before time.Time, limit int) ([]*files.File, error)
+	InsertUpload(req *files.Upload, expires time.Duration) error
+	FindOneUpload(uploadID string) (*files.Upload, error)
+	ReserveUpload(uploadID string, offset int64, lease time.Duration) (*files.Upload, error)
+	CommitUpload(upload *files.Upload, written int64) error
+	ReleaseUpload(upload *files.Upload) error
+	ClaimUploadCompletion(uploadID string, stale time.Duration) (*files.Upload, error)
+	CompleteUpload(upload *files.Upload, file *files.File) error
+	FailUpload(upload *files.Upload, reason string, rejected bool) error
+	DeleteUpload(uploadID string) error
+	FindExpiredUploads() ([]*files.Upload, error)
+}
+
+type filesRepository struct {
+	db *sqlx.DB
+}
+
+func FilesRepository(db *sqlx.DB) IFilesRepository {
+	return &filesRepository{
+		db: db,
+	}
+}
+
+func (r *filesRepository) InsertFile(req *files.File) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	return insertFile(ctx, r.db, req)
+}
+
+// insertFile registers the file, a concurrent upload of the same content
+// resolves to the entry already there
+func insertFile(ctx context.Context, db sqlx.QueryerContext, req *files.File) error {
+	query := `
+	INSERT INTO files (owner_id, storage_key, filename, url, content_type, size, checksum, private, renditions)
+	VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, $7, $8, $9)
+	ON CONFLICT (storage_key) DO UPDATE SET updated_at = now()
+	RETURNING id;`
+
+	var ownerID string
+	if req.OwnerID != nil {
+		ownerID = *req.OwnerID
+	}
+
+	if err := db.QueryRowxContext(
+		ctx,
+		query,
+		ownerID,
+		req.StorageKey,
+		req.FileName,
+		req.Url,
+		req.ContentType,
+		req.Size,
+		req.Checksum,
+		req.Private,
+		req.Renditions,
+	).Scan(&req.ID); err != nil {
+		return fmt.Errorf("insert file failed: %v", err)
+	}
+
+	return nil
+}
+
+const fileColumns = `
+	id,
+	owner_id,
+	storage_key,
+	filename,
+	url,
+	content_type,
+	size,
+	checksum,
+	private,
+	ref_count,
+	renditions,
+	to_char(created_at, 'YYYY-MM-DD HH24:MI:SS') AS created_at,
+	to_char(updated_at, 'YYYY-MM-DD HH24:MI:SS') AS updated_at`
+
+// ReuseFile returns the file stored under the key and restarts its orphan
+// grace period, so a duplicate upload is not swept before it is referenced.
+func (r *filesRepository) ReuseFile(storageKey string) (*files.File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	UPDATE files
+	SET updated_at = now()
+	WHERE storage_key = $1
+	RETURNING ` + fileColumns + `;`
+
+	file := new(files.File)
+	if err := r.db.GetContext(ctx, file, query, storageKey); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// FindOwnedFile returns the file stored under the key when the owner uploaded it
+func (r *filesRepository) FindOwnedFile(ownerID, storageKey string) (*files.File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `SELECT ` + fileColumns + ` FROM files WHERE storage_key = $1 AND owner_id = $2;`
+
+	file := new(files.File)
+	if err := r.db.GetContext(ctx, file, query, storageKey, ownerID); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// FindReferencedKeys returns the keys still referenced by a product or an order
+func (r *filesRepository) FindReferencedKeys(keys []string) ([]string, error) {
+	referenced := make([]string, 0)
+	if len(keys) == 0 {
+		return referenced, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query, args, err := sqlx.In(`SELECT storage_key FROM files WHERE storage_key IN (?) AND ref_count > 0;`, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.db.SelectContext(ctx, &referenced, r.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("find referenced files failed: %v", err)
+	}
+
+	return referenced, nil
+}
+
+// DeleteFilesByKey removes the entries nothing references anymore
+func (r *filesRepository) DeleteFilesByKey(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query, args, err := sqlx.In(`DELETE FROM files WHERE storage_key IN (?) AND ref_count = 0;`, keys)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, r.db.Rebind(query), args...); err != nil {
+		return fmt.Errorf("delete files failed: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteOrphanFiles removes a batch of unreferenced registry entries untouched
+// since before the grace period and returns them so their objects can be deleted.
+func (r *filesRepository) DeleteOrphanFiles(before time.Time, limit int) ([]*files.File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	query := `
+	DELETE
+	FROM files
+	WHERE id IN (SELECT id
+	             FROM files
+	             WHERE ref_count = 0
+	               AND updated_at < $1
+	             ORDER BY updated_at
+	             LIMIT $2 FOR UPDATE SKIP LOCKED)
+	  AND ref_count = 0
+	RETURNING ` + fileColumns + `;`
+
+	orphans := make([]*files.File, 0)
+	if err := r.db.SelectContext(ctx, &orphans, query, before, limit); err != nil {
+		return nil, fmt.Errorf("delete orphan files failed: %v", err)
+	}
+
+	return orphans, nil
+}
+
+const uploadColumns = `
+	id,
+	owner_id,
+	filename,
+	destination,
+	content_type,
+	length,
+	upload_offset,
+	parts,
+	file_id,
+	write_token,
+	completing_at IS NOT NULL AS completing,
+	error,
+	rejected,
+	to_char(expires_at, 'YYYY-MM-DD HH24:MI:SS') AS expires_at,
+	to_char(created_at, 'YYYY-MM-DD HH24:MI:SS') AS created_at,
+	to_char(updated_at, 'YYYY-MM-DD HH24:MI:SS') AS updated_at`
+
+func (r *filesRepository) InsertUpload(req *files.Upload, expires time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	INSERT INTO files_uploads (owner_id, filename, destination, content_type, length, expires_at)
+	VALUES ($1, $2, $3, $4, $5, now() + $6::INT * INTERVAL '1 second')
+	RETURNING ` + uploadColumns + `;`
+
+	if err := r.db.GetContext(
+		ctx,
+		req,
+		query,
+		req.OwnerID,
+		req.FileName,
+		req.Destination,
+		req.ContentType,
+		req.Length,
+		int64(expires.Seconds()),
+	); err != nil {
+		return fmt.Errorf("insert upload failed: %v", err)
+	}
+
+	return nil
+}
+
+func (r *filesRepository) FindOneUpload(uploadID string) (*files.Upload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `SELECT ` + uploadColumns + ` FROM files_uploads WHERE id::TEXT = $1;`
+
+	upload := new(files.Upload)
+	if err := r.db.GetContext(ctx, upload, query, uploadID); err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// ReserveUpload hands the next part of the upload to a single request. The
+// chunk is stored without holding a transaction, a reservation older than
+// lease is abandoned and can be taken over.
+func (r *filesRepository) ReserveUpload(uploadID string, offset int64, lease time.Duration) (*files.Upload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	UPDATE files_uploads
+	SET write_token = uuid_generate_v4(),
+	    writing_at  = now()
+	WHERE id::TEXT = $1
+	AND upload_offset = $2
+	AND file_id IS NULL
+	AND completing_at IS NULL
+	AND NOT rejected
+	AND (write_token IS NULL OR writing_at < now() - $3::INT * INTERVAL '1 second')
+	RETURNING ` + uploadColumns + `;`
+
+	upload := new(files.Upload)
+	err := r.db.GetContext(ctx, upload, query, uploadID, offset, int64(lease.Seconds()))
+	if err == nil {
+		return upload, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("reserve upload failed: %v", err)
+	}
+
+	// Tell a missing upload apart from one that can not take the chunk
+	upload, err = r.FindOneUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Completed() || upload.Rejected || upload.Offset != offset {
+		return upload, ErrOffsetMismatch
+	}
+	return upload, ErrUploadBusy
+}
+
+// CommitUpload moves the offset past the stored part, only while the
+// reservation is still held. The last part marks the upload completing.
+func (r *filesRepository) CommitUpload(upload *files.Upload, written int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	UPDATE files_uploads
+	SET upload_offset = upload_offset + $1,
+	    parts         = parts + 1,
+	    write_token   = NULL,
+	    writing_at    = NULL,
+	    completing_at = CASE WHEN upload_offset + $1 = length THEN now() END
+	WHERE id = $2
+	AND write_token = $3
+	AND upload_offset = $4
+	RETURNING ` + uploadColumns + `;`
+
+	if err := r.db.GetContext(ctx, upload, query, written, upload.ID, upload.WriteToken, upload.Offset); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUploadBusy
+		}
+		return fmt.Errorf("commit upload failed: %v", err)
+	}
+
+	return nil
+}
+
+// ReleaseUpload gives up a reservation after the chunk could not be stored
+func (r *filesRepository) ReleaseUpload(upload *files.Upload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	UPDATE files_uploads
+	SET write_token = NULL,
+	    writing_at  = NULL
+	WHERE id = $1
+	AND write_token = $2;`
+
+	if _, err := r.db.ExecContext(ctx, query, upload.ID, upload.WriteToken); err != nil {
+		return fmt.Errorf("release upload failed: %v", err)
+	}
+
+	return nil
+}
+
+// ClaimUploadCompletion marks a fully received upload completing, unless
+// it already is. A completion older than stale is assumed to have died.
+func (r *filesRepository) ClaimUploadCompletion(uploadID string, stale time.Duration) (*files.Upload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	UPDATE files_uploads
+	SET completing_at = now(),
+	    error         = ''
+	WHERE id::TEXT = $1
+	AND upload_offset = length
+	AND file_id IS NULL
+	AND write_token IS NULL
+	AND NOT rejected
+	AND (completing_at IS NULL OR completing_at < now() - $2::INT * INTERVAL '1 second')
+	RETURNING ` + uploadColumns + `;`
+
+	upload := new(files.Upload)
+	if err := r.db.GetContext(ctx, upload, query, uploadID, int64(stale.Seconds())); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUploadBusy
+		}
+		return nil, fmt.Errorf("claim upload completion failed: %v", err)
+	}
+
+	return upload, nil
+}
+
+// CompleteUpload links the upload to its file, the file is registered unless
+// it is an existing one. The upload takes the name of the file.
+func (r *filesRepository) CompleteUpload(upload *files.Upload, file *files.File) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if file.ID == "" {
+		if err := insertFile(ctx, tx, file); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	query := `
+	UPDATE files_uploads
+	SET file_id       = $1,
+	    filename      = $2,
+	    destination   = $3,
+	    completing_at = NULL,
+	    error         = ''
+	WHERE id = $4;`
+
+	if _, err := tx.ExecContext(ctx, query, file.ID, file.FileName, file.StorageKey, upload.ID); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("complete upload failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	upload.FileID = &file.ID
+	upload.FileName = file.FileName
+	upload.Destination = file.StorageKey
+	upload.Completing = false
+	upload.Error = ""
+
+	return nil
+}
+
+// FailUpload ends the completion with reason, a rejected upload can not be
+// completed again.
+func (r *filesRepository) FailUpload(upload *files.Upload, reason string, rejected bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	UPDATE files_uploads
+	SET completing_at = NULL,
+	    error         = $1,
+	    rejected      = $2
+	WHERE id = $3;`
+
+	if _, err := r.db.ExecContext(ctx, query, reason, rejected, upload.ID); err != nil {
+		return fmt.Errorf("fail upload failed: %v", err)
+	}
+	upload.Completing = false
+	upload.Error = reason
+	upload.Rejected = rejected
+
+	return nil
+}
+
+func (r *filesRepository) DeleteUpload(uploadID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM files_uploads WHERE id::TEXT = $1;`, uploadID); err != nil {
+		return fmt.Errorf("delete upload failed: %v", err)
+	}
+
+	return nil
+}
+
+func (r *filesRepository) FindExpiredUploads() ([]*files.Upload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	SELECT ` + uploadColumns + `
+	FROM files_uploads
+	WHERE expires_at < now();`
+
+	uploads := make([]*files.Upload, 0)
+	if err := r.db.SelectContext(ctx, &uploads, query); err != nil {
+		return nil, fmt.Errorf("find expired uploads failed: %v", err)
+	}
+
+	return uploads, nil
+}
@@ -0,0 +1,153 @@
+package filesScanners
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/korvised/go-ecommerce/config"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// clamAVChunkSize must stay below StreamMaxLength of clamd
+const clamAVChunkSize = 64 * 1024
+
+type clamAVScanner struct {
+	network  string
+	address  string
+	timeout  time.Duration
+	maxSize  int64
+	oversize string
+}
+
+// ClamAVScanner streams files to clamd with the INSTREAM command
+func ClamAVScanner(cfg config.IConfig) (IFilesScanner, error) {
+	u, err := url.Parse(cfg.Scanner().ClamAVAddress())
+	if err != nil {
+		return nil, fmt.Errorf("parse clamav address failed: %v", err)
+	}
+
+	s := &clamAVScanner{
+		network:  u.Scheme,
+		timeout:  cfg.Scanner().Timeout(),
+		maxSize:  cfg.Scanner().MaxSize(),
+		oversize: cfg.Scanner().Oversize(),
+	}
+	switch u.Scheme {
+	case "tcp":
+		s.address = u.Host
+	case "unix":
+		s.address = u.Path
+	default:
+		return nil, fmt.Errorf("clamav address must start with tcp:// or unix://")
+	}
+
+	return s, nil
+}
+
+func (s *clamAVScanner) Scan(ctx context.Context, data io.Reader) error {
+	dialCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	conn, err := new(net.Dialer).DialContext(dialCtx, s.network, s.address)
+	if err != nil {
+		return fmt.Errorf("connect clamav failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Unblock a pending write or read once the caller gives up
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	// Null terminated command, then length prefixed chunks ended by an empty one
+	if err := s.write(ctx, conn, []byte("zINSTREAM\x00")); err != nil {
+		return fmt.Errorf("send clamav command failed: %v", err)
+	}
+
+	var sent int64
+	buf := make([]byte, 4+clamAVChunkSize)
+	for {
+		n, err := io.ReadFull(data, buf[4:])
+		if n > 0 {
+			if sent += int64(n); sent > s.maxSize {
+				return s.oversized(data)
+			}
+
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if err := s.write(ctx, conn, buf[:4+n]); err != nil {
+				// clamd closes the stream once the size limit is reached
+				return s.reply(conn, data, fmt.Errorf("send file to clamav failed: %v", err))
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read file failed: %v", err)
+		}
+	}
+
+	if err := s.write(ctx, conn, []byte{0, 0, 0, 0}); err != nil {
+		return s.reply(conn, data, fmt.Errorf("send file to clamav failed: %v", err))
+	}
+
+	return s.reply(conn, data, nil)
+}
+
+// write gives every chunk its own timeout, a large file is not bound by a
+// single deadline while it is read from storage and sent
+func (s *clamAVScanner) write(ctx context.Context, conn net.Conn, b []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_ = conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	_, err := conn.Write(b)
+	return err
+}
+
+// oversized applies SCANNER_OVERSIZE to a file over the size limit. A skipped
+// file is still read to the end, callers hash the content while it is scanned.
+func (s *clamAVScanner) oversized(data io.Reader) error {
+	if s.oversize != config.ScannerOversizeSkip {
+		return fmt.Errorf("%w: limit is %d bytes", ErrTooLargeToScan, s.maxSize)
+	}
+
+	if _, err := io.Copy(io.Discard, data); err != nil {
+		return fmt.Errorf("read file failed: %v", err)
+	}
+	return nil
+}
+
+// reply reads the verdict, e.g. "stream: OK" or "stream: Eicar-Signature FOUND"
+func (s *clamAVScanner) reply(conn net.Conn, data io.Reader, sendErr error) error {
+	_ = conn.SetReadDeadline(time.Now().Add(s.timeout))
+
+	line, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && line == "" {
+		if sendErr != nil {
+			return sendErr
+		}
+		return fmt.Errorf("read clamav reply failed: %v", err)
+	}
+
+	line = strings.TrimSpace(strings.TrimSuffix(line, "\x00"))
+	switch {
+	case strings.HasSuffix(line, "FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(line, "stream: "), " FOUND")
+		return fmt.Errorf("%w: %s", ErrInfected, signature)
+	case strings.HasSuffix(line, "OK"):
+		return nil
+	case strings.Contains(line, "size limit exceeded"):
+		// StreamMaxLength of clamd is lower than SCANNER_MAX_SIZE
+		return s.oversized(data)
+	default:
+		return fmt.Errorf("clamav scan failed: %s", line)
+	}
+}
+
+func (s *clamAVScanner) Driver() string { return config.ScannerClamAV }
@@ -0,0 +1,37 @@
+package filesScanners
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/korvised/go-ecommerce/config"
+	"io"
+)
+
+var (
+	// ErrInfected is returned by Scan when the content carries malware
+	ErrInfected = errors.New("file is infected")
+
+	// ErrTooLargeToScan is returned by Scan for files over the scanner
+	// limit, unless SCANNER_OVERSIZE lets them through unscanned
+	ErrTooLargeToScan = errors.New("file is too large to scan")
+)
+
+// IFilesScanner checks uploads for malware before they are stored, Scan
+// reads data to the end and returns ErrInfected with the signature found.
+type IFilesScanner interface {
+	Scan(ctx context.Context, data io.Reader) error
+	Driver() string
+}
+
+// FilesScanner creates the scanner selected by SCANNER_DRIVER
+func FilesScanner(cfg config.IConfig) (IFilesScanner, error) {
+	switch cfg.Scanner().Driver() {
+	case config.ScannerNone:
+		return NoopScanner(), nil
+	case config.ScannerClamAV:
+		return ClamAVScanner(cfg)
+	default:
+		return nil, fmt.Errorf("scanner driver %s is not supported", cfg.Scanner().Driver())
+	}
+}
@@ -0,0 +1,58 @@
+package filesScanners
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/korvised/go-ecommerce/config"
+	"io"
+)
+
+// EicarSignature is the standard anti-virus test file, harmless by design
+const EicarSignature = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+type noopScanner struct{}
+
+// NoopScanner accepts every file, it is used when no scanner is configured
+func NoopScanner() IFilesScanner {
+	return &noopScanner{}
+}
+
+func (s *noopScanner) Scan(ctx context.Context, data io.Reader) error {
+	if _, err := io.Copy(io.Discard, data); err != nil {
+		return fmt.Errorf("read file failed: %v", err)
+	}
+	return nil
+}
+
+func (s *noopScanner) Driver() string { return config.ScannerNone }
+
+type signatureScanner struct {
+	signatures map[string][]byte
+}
+
+// SignatureScanner reports files containing one of the signatures, keyed by
+// name, as infected. Use it in tests, e.g. with EicarSignature.
+func SignatureScanner(signatures map[string]string) IFilesScanner {
+	s := &signatureScanner{signatures: make(map[string][]byte)}
+	for name, signature := range signatures {
+		s.signatures[name] = []byte(signature)
+	}
+	return s
+}
+
+func (s *signatureScanner) Scan(ctx context.Context, data io.Reader) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return fmt.Errorf("read file failed: %v", err)
+	}
+
+	for name, signature := range s.signatures {
+		if bytes.Contains(b, signature) {
+			return fmt.Errorf("%w: %s", ErrInfected, name)
+		}
+	}
+	return nil
+}
+
+func (s *signatureScanner) Driver() string { return "signature" }
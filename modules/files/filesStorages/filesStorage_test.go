@@ -0,0 +1,176 @@
+package filesStorages
+
+import (
+	"context"
+	"errors"
+	"github.com/korvised/go-ecommerce/config"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAppConfig struct {
+	config.IAppConfig
+}
+
+func (c *testAppConfig) PublicUrl() string { return "https://api.example.com" }
+
+type testStorageConfig struct {
+	config.IStorageConfig
+	key []byte
+}
+
+func (c *testStorageConfig) PublicUrl() string  { return "" }
+func (c *testStorageConfig) SigningKey() []byte { return c.key }
+
+type testConfig struct {
+	config.IConfig
+	storage *testStorageConfig
+}
+
+func (c *testConfig) App() config.IAppConfig         { return &testAppConfig{} }
+func (c *testConfig) Storage() config.IStorageConfig { return c.storage }
+
+func newTestConfig() *testConfig {
+	return &testConfig{storage: &testStorageConfig{key: []byte("signing-key-1")}}
+}
+
+type testVerifySignature struct {
+	name        string
+	destination string
+	expires     string
+	signature   string
+	cfg         config.IStorageConfig
+	expect      error
+}
+
+func TestVerifySignature(t *testing.T) {
+	cfg := newTestConfig()
+	storage := MemoryStorage(cfg)
+
+	signed, err := storage.SignedUrl(context.Background(), "orders/invoice.pdf", time.Minute)
+	if err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+	if !strings.HasPrefix(signed, "https://api.example.com/v1/files/private/orders/invoice.pdf?") {
+		t.Errorf("expect: %s, got: %s", "a url of the public api", signed)
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+	expires := u.Query().Get("expires")
+	sig := u.Query().Get("signature")
+
+	past := time.Now().Add(-time.Minute).Unix()
+	pastExpires := strconv.FormatInt(past, 10)
+
+	tests := []testVerifySignature{
+		{
+			name:        "valid",
+			destination: "orders/invoice.pdf",
+			expires:     expires,
+			signature:   sig,
+			cfg:         cfg.storage,
+			expect:      nil,
+		},
+		{
+			name:        "other destination",
+			destination: "orders/other.pdf",
+			expires:     expires,
+			signature:   sig,
+			cfg:         cfg.storage,
+			expect:      ErrInvalidSignature,
+		},
+		{
+			name:        "extended expiry",
+			destination: "orders/invoice.pdf",
+			expires:     strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10),
+			signature:   sig,
+			cfg:         cfg.storage,
+			expect:      ErrInvalidSignature,
+		},
+		{
+			name:        "tampered signature",
+			destination: "orders/invoice.pdf",
+			expires:     expires,
+			signature:   strings.Repeat("0", len(sig)),
+			cfg:         cfg.storage,
+			expect:      ErrInvalidSignature,
+		},
+		{
+			name:        "malformed expires",
+			destination: "orders/invoice.pdf",
+			expires:     "tomorrow",
+			signature:   sig,
+			cfg:         cfg.storage,
+			expect:      ErrInvalidSignature,
+		},
+		{
+			name:        "another key",
+			destination: "orders/invoice.pdf",
+			expires:     expires,
+			signature:   sig,
+			cfg:         &testStorageConfig{key: []byte("signing-key-2")},
+			expect:      ErrInvalidSignature,
+		},
+		{
+			name:        "expired",
+			destination: "orders/invoice.pdf",
+			expires:     pastExpires,
+			signature:   signature(cfg.storage.key, "orders/invoice.pdf", past),
+			cfg:         cfg.storage,
+			expect:      ErrUrlExpired,
+		},
+	}
+
+	for _, test := range tests {
+		if err := VerifySignature(test.cfg, test.destination, test.expires, test.signature); !errors.Is(err, test.expect) {
+			t.Errorf("%s: expect: %v, got: %v", test.name, test.expect, err)
+		}
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	storage := MemoryStorage(newTestConfig())
+
+	if err := storage.Upload(ctx, "products/a.png", "image/png", strings.NewReader("png")); err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+
+	file, ok := storage.Get("products/a.png")
+	if !ok || file.ContentType != "image/png" || string(file.Data) != "png" {
+		t.Errorf("expect: %s, got: %+v", "the uploaded file", file)
+	}
+	if got := storage.Url("products/a.png"); got != "memory://files/products/a.png" {
+		t.Errorf("expect: %s, got: %s", "memory://files/products/a.png", got)
+	}
+
+	r, err := storage.Open(ctx, "products/a.png")
+	if err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+	b, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(b) != "png" {
+		t.Errorf("expect: %s, got: %s", "png", b)
+	}
+
+	if err := storage.Delete(ctx, "products/a.png"); err != nil {
+		t.Errorf("expect: %v, got: %v", nil, err)
+	}
+	if _, err := storage.Open(ctx, "products/a.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect: %v, got: %v", ErrNotFound, err)
+	}
+	if err := storage.Delete(ctx, "products/a.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect: %v, got: %v", ErrNotFound, err)
+	}
+	if storage.Len() != 0 {
+		t.Errorf("expect: %v, got: %v", 0, storage.Len())
+	}
+}
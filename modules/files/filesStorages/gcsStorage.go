@@ -0,0 +1,117 @@
+package filesStorages
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/korvised/go-ecommerce/config"
+	"io"
+	"time"
+)
+
+type gcsStorage struct {
+	cfg    config.IConfig
+	client *storage.Client
+}
+
+// GCSStorage uses the application default credentials
+func GCSStorage(cfg config.IConfig) (IFilesStorage, error) {
+	if cfg.Storage().GCSBucket() == "" {
+		return nil, fmt.Errorf("storage gcs bucket is required")
+	}
+
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+
+	return &gcsStorage{
+		cfg:    cfg,
+		client: client,
+	}, nil
+}
+
+func (s *gcsStorage) Upload(ctx context.Context, destination, contentType string, data io.Reader) error {
+	o := s.client.Bucket(s.cfg.Storage().GCSBucket()).Object(destination)
+
+	// Upload an object with storage.Writer.
+	wc := o.NewWriter(ctx)
+	wc.ContentType = contentType
+
+	if _, err := io.Copy(wc, data); err != nil {
+		_ = wc.Close()
+		return fmt.Errorf("io.Copy: %v", err)
+	}
+	// Data can continue to be added to the file until the writer is closed.
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("Writer.Close: %v", err)
+	}
+
+	if s.cfg.Storage().IsPrivate(destination) {
+		return nil
+	}
+
+	if err := o.ACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
+		return fmt.Errorf("ACLHandle.Set: %v", err)
+	}
+
+	return nil
+}
+
+func (s *gcsStorage) Open(ctx context.Context, destination string) (io.ReadCloser, error) {
+	r, err := s.client.Bucket(s.cfg.Storage().GCSBucket()).Object(destination).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("Object(%q).NewReader: %v", destination, err)
+	}
+
+	return r, nil
+}
+
+func (s *gcsStorage) Delete(ctx context.Context, destination string) error {
+	o := s.client.Bucket(s.cfg.Storage().GCSBucket()).Object(destination)
+
+	// Set a generation-match precondition to avoid deleting a newer upload
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("object.Attrs: %v", err)
+	}
+	o = o.If(storage.Conditions{GenerationMatch: attrs.Generation})
+
+	if err := o.Delete(ctx); err != nil {
+		return fmt.Errorf("Object(%q).Delete: %v", destination, err)
+	}
+
+	return nil
+}
+
+func (s *gcsStorage) Url(destination string) string {
+	return publicUrl(
+		s.cfg.Storage(),
+		fmt.Sprintf("https://storage.googleapis.com/%s", s.cfg.Storage().GCSBucket()),
+		destination,
+	)
+}
+
+// SignedUrl needs credentials able to sign, a service account key or the
+// iam.serviceAccounts.signBlob permission
+func (s *gcsStorage) SignedUrl(ctx context.Context, destination string, expires time.Duration) (string, error) {
+	u, err := s.client.Bucket(s.cfg.Storage().GCSBucket()).SignedURL(destination, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  "GET",
+		Expires: time.Now().Add(expires),
+	})
+	if err != nil {
+		return "", fmt.Errorf("sign url %s failed: %v", destination, err)
+	}
+
+	return u, nil
+}
+
+func (s *gcsStorage) Driver() string { return config.StorageGCS }
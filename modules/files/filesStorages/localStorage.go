@@ -0,0 +1,100 @@
+package filesStorages
+
+import (
+	"context"
+	"fmt"
+	"github.com/korvised/go-ecommerce/config"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type localStorage struct {
+	cfg config.IConfig
+}
+
+// LocalStorage writes files under STORAGE_LOCAL_DIR which the server serves at /public
+func LocalStorage(cfg config.IConfig) IFilesStorage {
+	return &localStorage{
+		cfg: cfg,
+	}
+}
+
+func (s *localStorage) path(destination string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(destination))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("destination %s is invalid", destination)
+	}
+	return filepath.Join(s.cfg.Storage().LocalDir(), clean), nil
+}
+
+func (s *localStorage) Upload(ctx context.Context, destination, contentType string, data io.Reader) error {
+	dest, err := s.path(destination)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return fmt.Errorf("mkdir \"%s\" failed: %v", filepath.Dir(dest), err)
+	}
+
+	file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("write file failed: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, data); err != nil {
+		return fmt.Errorf("write file failed: %v", err)
+	}
+
+	return nil
+}
+
+func (s *localStorage) Open(ctx context.Context, destination string) (io.ReadCloser, error) {
+	dest, err := s.path(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(dest)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("open file: %s failed: %v", destination, err)
+	}
+
+	return file, nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, destination string) error {
+	dest, err := s.path(destination)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(dest); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("remove file: %s failed: %v", destination, err)
+	}
+
+	return nil
+}
+
+func (s *localStorage) Url(destination string) string {
+	return publicUrl(
+		s.cfg.Storage(),
+		s.cfg.App().PublicUrl()+"/public",
+		destination,
+	)
+}
+
+func (s *localStorage) SignedUrl(ctx context.Context, destination string, expires time.Duration) (string, error) {
+	return hmacSignedUrl(s.cfg, destination, expires), nil
+}
+
+func (s *localStorage) Driver() string { return config.StorageLocal }
@@ -0,0 +1,96 @@
+package filesStorages
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/korvised/go-ecommerce/config"
+	"io"
+	"sync"
+	"time"
+)
+
+type memoryStorage struct {
+	cfg   config.IConfig
+	mu    sync.RWMutex
+	files map[string]*MemoryFile
+}
+
+type MemoryFile struct {
+	ContentType string
+	Data        []byte
+}
+
+// IMemoryStorage keeps files in memory, use it in tests
+type IMemoryStorage interface {
+	IFilesStorage
+	Get(destination string) (*MemoryFile, bool)
+	Len() int
+}
+
+func MemoryStorage(cfg config.IConfig) IMemoryStorage {
+	return &memoryStorage{
+		cfg:   cfg,
+		files: make(map[string]*MemoryFile),
+	}
+}
+
+func (s *memoryStorage) Upload(ctx context.Context, destination, contentType string, data io.Reader) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return fmt.Errorf("read file failed: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[destination] = &MemoryFile{
+		ContentType: contentType,
+		Data:        b,
+	}
+	return nil
+}
+
+func (s *memoryStorage) Open(ctx context.Context, destination string) (io.ReadCloser, error) {
+	file, ok := s.Get(destination)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(file.Data)), nil
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, destination string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[destination]; !ok {
+		return ErrNotFound
+	}
+	delete(s.files, destination)
+	return nil
+}
+
+func (s *memoryStorage) Url(destination string) string {
+	return publicUrl(s.cfg.Storage(), "memory://files", destination)
+}
+
+func (s *memoryStorage) SignedUrl(ctx context.Context, destination string, expires time.Duration) (string, error) {
+	return hmacSignedUrl(s.cfg, destination, expires), nil
+}
+
+func (s *memoryStorage) Driver() string { return config.StorageMemory }
+
+func (s *memoryStorage) Get(destination string) (*MemoryFile, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	file, ok := s.files[destination]
+	return file, ok
+}
+
+func (s *memoryStorage) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.files)
+}
@@ -0,0 +1,117 @@
+package filesStorages
+
+import (
+	"context"
+	"fmt"
+	"github.com/korvised/go-ecommerce/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"time"
+)
+
+// s3PartSize bounds the buffer of streams with unknown size, without it
+// minio-go sizes its parts for a 5 TiB object and buffers about 560 MB
+const s3PartSize = 16 << 20
+
+type s3Storage struct {
+	cfg    config.IConfig
+	client *minio.Client
+}
+
+// S3Storage works with AWS S3 and compatible services (MinIO, R2, Spaces, ...).
+// Objects are uploaded without acl, the bucket policy should allow public
+// read on public destinations only.
+func S3Storage(cfg config.IConfig) (IFilesStorage, error) {
+	if cfg.Storage().S3Endpoint() == "" || cfg.Storage().S3Bucket() == "" {
+		return nil, fmt.Errorf("storage s3 endpoint and bucket are required")
+	}
+
+	client, err := minio.New(cfg.Storage().S3Endpoint(), &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.Storage().S3AccessKey(), cfg.Storage().S3SecretKey(), ""),
+		Secure: cfg.Storage().S3UseSSL(),
+		Region: cfg.Storage().S3Region(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("new s3 client failed: %v", err)
+	}
+
+	return &s3Storage{
+		cfg:    cfg,
+		client: client,
+	}, nil
+}
+
+func (s *s3Storage) Upload(ctx context.Context, destination, contentType string, data io.Reader) error {
+	// In-memory readers are sent in one request, streams in bounded parts
+	size := int64(-1)
+	if sized, ok := data.(interface{ Len() int }); ok {
+		size = int64(sized.Len())
+	}
+
+	if _, err := s.client.PutObject(ctx, s.cfg.Storage().S3Bucket(), destination, data, size, minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    s3PartSize,
+	}); err != nil {
+		return fmt.Errorf("put object %s failed: %v", destination, err)
+	}
+
+	return nil
+}
+
+func (s *s3Storage) Open(ctx context.Context, destination string) (io.ReadCloser, error) {
+	if _, err := s.client.StatObject(ctx, s.cfg.Storage().S3Bucket(), destination, minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("stat object %s failed: %v", destination, err)
+	}
+
+	obj, err := s.client.GetObject(ctx, s.cfg.Storage().S3Bucket(), destination, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get object %s failed: %v", destination, err)
+	}
+
+	return obj, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, destination string) error {
+	// RemoveObject succeeds for missing keys, check first so callers get ErrNotFound
+	if _, err := s.client.StatObject(ctx, s.cfg.Storage().S3Bucket(), destination, minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return ErrNotFound
+		}
+		return fmt.Errorf("stat object %s failed: %v", destination, err)
+	}
+
+	if err := s.client.RemoveObject(ctx, s.cfg.Storage().S3Bucket(), destination, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("remove object %s failed: %v", destination, err)
+	}
+
+	return nil
+}
+
+func (s *s3Storage) Url(destination string) string {
+	scheme := "https"
+	if !s.cfg.Storage().S3UseSSL() {
+		scheme = "http"
+	}
+
+	// Path style works for every S3-compatible service
+	return publicUrl(
+		s.cfg.Storage(),
+		fmt.Sprintf("%s://%s/%s", scheme, s.cfg.Storage().S3Endpoint(), s.cfg.Storage().S3Bucket()),
+		destination,
+	)
+}
+
+func (s *s3Storage) SignedUrl(ctx context.Context, destination string, expires time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.cfg.Storage().S3Bucket(), destination, expires, nil)
+	if err != nil {
+		return "", fmt.Errorf("sign url %s failed: %v", destination, err)
+	}
+
+	return u.String(), nil
+}
+
+func (s *s3Storage) Driver() string { return config.StorageS3 }
@@ -0,0 +1,60 @@
+package filesUsecases
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrFileRejected is returned when the content of a file does not match its extension
+var ErrFileRejected = errors.New("file is rejected")
+
+// contentTypes are the types sniffed from the first bytes of each extension
+var contentTypes = map[string][]string{
+	"png":  {"image/png"},
+	"jpg":  {"image/jpeg"},
+	"jpeg": {"image/jpeg"},
+	"gif":  {"image/gif"},
+	"webp": {"image/webp"},
+	"pdf":  {"application/pdf"},
+	"mp4":  {"video/mp4"},
+	"webm": {"video/webm"},
+	"csv":  {"text/plain"},
+	"txt":  {"text/plain"},
+}
+
+// checkContent sniffs the magic bytes of head, extensions without a known
+// signature are rejected as their content can not be verified.
+func checkContent(ext string, head []byte) error {
+	ext = strings.ToLower(ext)
+
+	// Quicktime is not sniffed by net/http, its first box is at offset 4
+	if ext == "mov" {
+		if len(head) >= 12 {
+			switch string(head[4:8]) {
+			case "moov", "mdat", "wide", "free", "skip", "pnot":
+				return nil
+			case "ftyp":
+				if string(head[8:12]) == "qt  " {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%w: content is not %s", ErrFileRejected, ext)
+	}
+
+	types, ok := contentTypes[ext]
+	if !ok {
+		return fmt.Errorf("%w: type %s can not be verified", ErrFileRejected, ext)
+	}
+
+	detected, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	for _, t := range types {
+		if detected == t {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: content is %s, not %s", ErrFileRejected, detected, ext)
+}
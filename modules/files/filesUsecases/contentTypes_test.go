@@ -0,0 +1,45 @@
+package filesUsecases
+
+import (
+	"errors"
+	"testing"
+)
+
+type testCheckContent struct {
+	name  string
+	ext   string
+	head  []byte
+	isErr bool
+}
+
+func TestCheckContent(t *testing.T) {
+	tests := []testCheckContent{
+		{name: "png", ext: "png", head: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")},
+		{name: "upper case extension", ext: "PNG", head: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")},
+		{name: "jpeg as jpg", ext: "jpg", head: []byte("\xff\xd8\xff\xe0\x00\x10JFIF")},
+		{name: "pdf", ext: "pdf", head: []byte("%PDF-1.7\n")},
+		{name: "text", ext: "txt", head: []byte("hello world")},
+		{name: "quicktime", ext: "mov", head: []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00")},
+		{name: "quicktime moov", ext: "mov", head: []byte("\x00\x00\x00\x08moov\x00\x00\x00\x00")},
+		{name: "pdf named png", ext: "png", head: []byte("%PDF-1.7\n"), isErr: true},
+		{name: "html named txt", ext: "txt", head: []byte("<html><script>alert(1)</script>"), isErr: true},
+		{name: "mp4 named mov", ext: "mov", head: []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"), isErr: true},
+		{name: "short mov", ext: "mov", head: []byte("\x00\x00"), isErr: true},
+		{name: "unknown extension", ext: "exe", head: []byte("MZ\x90\x00"), isErr: true},
+		{name: "empty", ext: "png", head: nil, isErr: true},
+	}
+
+	for _, test := range tests {
+		err := checkContent(test.ext, test.head)
+		if test.isErr {
+			if !errors.Is(err, ErrFileRejected) {
+				t.Errorf("%s: expect: %v, got: %v", test.name, ErrFileRejected, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: expect: %v, got: %v", test.name, nil, err)
+		}
+	}
+}
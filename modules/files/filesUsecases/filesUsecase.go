@@ -1,205 +1,371 @@
 package filesUsecases
 
 import (
+	"bufio"
 	"bytes"
-	"cloud.google.com/go/storage"
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/korvised/go-ecommerce/config"
+	"github.com/korvised/go-ecommerce/modules/entities"
 	"github.com/korvised/go-ecommerce/modules/files"
+	"github.com/korvised/go-ecommerce/modules/files/filesRepositories"
+	"github.com/korvised/go-ecommerce/modules/files/filesScanners"
+	"github.com/korvised/go-ecommerce/modules/files/filesStorages"
+	"github.com/korvised/go-ecommerce/pkg/imaging"
 	"io"
 	"io/ioutil"
-	"os"
+	"log"
+	"mime"
+	"path"
 	"strings"
 	"time"
 )
 
 type IFilesUsecase interface {
-	UploadToGCP(req []*files.FileReq) ([]*files.FileRes, error)
 	UploadToStorage(req []*files.FileReq) ([]*files.FileRes, error)
-	DeleteFileOnGCP(req []*files.DeleteFileReq) error
 	DeleteFileOnStorage(req []*files.DeleteFileReq) error
+	FileUrl(destination string) (string, error)
+	FindOwnedFile(ownerID, destination string) (*files.File, error)
+	OpenPrivateFile(destination, expires, signature string) (io.ReadCloser, string, error)
+	SweepOrphanFiles(before time.Time) (int, error)
+	CreateUpload(req *files.Upload) (*files.Upload, error)
+	FindOneUpload(uploadID string) (*files.Upload, error)
+	AppendUpload(uploadID string, offset int64, data io.Reader) (*files.Upload, error)
+	TerminateUpload(uploadID string) error
+	FindUploadedFile(upload *files.Upload) (*files.FileRes, error)
+	SweepExpiredUploads() (int, error)
 }
 
+// ErrUploadTooLarge is returned when an upload or chunk exceeds its length limit
+var ErrUploadTooLarge = errors.New("upload is too large")
+
+// completeUploadTimeout bounds joining the parts of an upload
+const completeUploadTimeout = time.Minute * 30
+
 type filesUsecase struct {
-	cfg config.IConfig
+	cfg             config.IConfig
+	storage         filesStorages.IFilesStorage
+	scanner         filesScanners.IFilesScanner
+	filesRepository filesRepositories.IFilesRepository
 }
 
-type filesPub struct {
-	bucket      string
+// fileObject is a single object written to storage, an image upload
+// produces one per rendition
+type fileObject struct {
 	destination string
-	file        *files.FileRes
+	contentType string
+	data        []byte
 }
 
-func FilesUsecase(cfg config.IConfig) IFilesUsecase {
-	return &filesUsecase{
-		cfg: cfg,
-	}
+type deleteJob struct {
+	destination string
+	optional    bool // renditions may not exist for older uploads
 }
 
-func (f *filesPub) makePublic(ctx context.Context, client *storage.Client) error {
-	acl := client.Bucket(f.bucket).Object(f.destination).ACL()
-	if err := acl.Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
-		return fmt.Errorf("ACLHandle.Set: %v", err)
+func FilesUsecase(
+	cfg config.IConfig,
+	storage filesStorages.IFilesStorage,
+	scanner filesScanners.IFilesScanner,
+	filesRepository filesRepositories.IFilesRepository,
+) IFilesUsecase {
+	return &filesUsecase{
+		cfg:             cfg,
+		storage:         storage,
+		scanner:         scanner,
+		filesRepository: filesRepository,
 	}
-	fmt.Printf("Blob %v is now publicly accessible.\n", f.destination)
-	return nil
 }
 
-func (u *filesUsecase) uploadWorkers(ctx context.Context, client *storage.Client, jobs <-chan *files.FileReq, results chan<- *files.FileRes, errs chan<- error) {
+func (u *filesUsecase) uploadWorkers(ctx context.Context, jobs <-chan *files.FileReq, results chan<- *files.FileRes, errs chan<- error) {
 	for job := range jobs {
-		container, err := job.File.Open()
+		objects, res, err := u.prepare(ctx, job)
 		if err != nil {
 			errs <- err
 			return
 		}
-		b, err := ioutil.ReadAll(container)
-		if err != nil {
-			errs <- err
-			return
-		}
-
-		buf := bytes.NewBuffer(b)
-
-		// Upload an object with storage.Writer.
-		wc := client.Bucket(u.cfg.App().GCPBucket()).Object(job.Destination).NewWriter(ctx)
 
-		if _, err = io.Copy(wc, buf); err != nil {
-			errs <- fmt.Errorf("io.Copy: %v", err)
-			return
+		// Same content as a stored file, nothing to upload
+		if objects == nil {
+			errs <- nil
+			results <- res
+			continue
 		}
-		// Data can continue to be added to the file until the writer is closed.
-		if err := wc.Close(); err != nil {
-			errs <- fmt.Errorf("Writer.Close: %v", err)
-			return
-		}
-		fmt.Printf("%v uploaded to %v.\n", job.FileName, job.Extension)
 
-		newFile := &filesPub{
-			file: &files.FileRes{
-				FileName: job.FileName,
-				Url: fmt.Sprintf(
-					"https://storage.googleapis.com/%s/%s",
-					u.cfg.App().GCPBucket(),
-					job.Destination,
-				),
-			},
-			bucket:      u.cfg.App().GCPBucket(),
-			destination: job.Destination,
+		for _, obj := range objects {
+			if err := u.storage.Upload(ctx, obj.destination, obj.contentType, bytes.NewReader(obj.data)); err != nil {
+				errs <- err
+				return
+			}
 		}
 
-		if err = newFile.makePublic(ctx, client); err != nil {
+		if err := u.register(job, res, objects); err != nil {
 			errs <- err
 			return
 		}
 
 		errs <- nil
-		results <- newFile.file
+		results <- res
 	}
 }
 
-func (u *filesUsecase) uploadToStorageWorker(jobs <-chan *files.FileReq, results chan<- *files.FileRes, errs chan<- error) {
-	for job := range jobs {
-		cotainer, err := job.File.Open()
-		if err != nil {
-			errs <- err
-			return
+// contentDestination names a file after the sha256 of its content, so identical
+// uploads to a directory resolve to one object, e.g. products/<sha256>.jpg
+func contentDestination(directory, checksum, ext string) string {
+	filename := checksum
+	if ext != "" {
+		filename += "." + ext
+	}
+	return path.Join(strings.Trim(directory, "/"), filename)
+}
+
+// prepare reads the upload into the objects to store. Images are stripped of
+// their metadata and expanded into renditions, other files are kept as is.
+// No objects are returned when the same content is already stored.
+func (u *filesUsecase) prepare(ctx context.Context, job *files.FileReq) ([]*fileObject, *files.FileRes, error) {
+	container, err := job.File.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer container.Close()
+
+	b, err := ioutil.ReadAll(container)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkContent(job.Extension, b); err != nil {
+		return nil, nil, err
+	}
+	if err := u.scanner.Scan(ctx, bytes.NewReader(b)); err != nil {
+		return nil, nil, err
+	}
+
+	checksum := sha256.Sum256(b)
+	job.Destination = contentDestination(job.Destination, hex.EncodeToString(checksum[:]), job.Extension)
+	job.FileName = path.Base(job.Destination)
+
+	res := &files.FileRes{
+		FileName:    job.FileName,
+		Destination: job.Destination,
+		Checksum:    hex.EncodeToString(checksum[:]),
+		Private:     u.cfg.Storage().IsPrivate(job.Destination),
+	}
+	if res.Url, err = u.fileUrl(ctx, job.Destination); err != nil {
+		return nil, nil, err
+	}
+	if res.Private {
+		res.ExpiresAt = time.Now().Add(u.cfg.Storage().SignedUrlExpires()).Format("2006-01-02 15:04:05")
+	}
+
+	file, err := u.filesRepository.ReuseFile(job.Destination)
+	if err == nil {
+		if res.Renditions, err = u.renditionUrls(ctx, job.Destination, file.Renditions); err != nil {
+			return nil, nil, err
+		}
+		return nil, res, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, err
+	}
+
+	if !imaging.IsImage(job.Extension) {
+		return []*fileObject{{destination: job.Destination, data: b}}, res, nil
+	}
+
+	variants, err := imaging.Process(b, u.cfg.Image())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objects := make([]*fileObject, 0, len(variants))
+	res.Renditions = make(entities.ImageRenditions, 0, len(variants))
+	for _, v := range variants {
+		destination := job.Destination
+		if v.Name != imaging.Original || v.Format == imaging.FormatWebP {
+			destination = renditionDestination(job.Destination, v.Name, v.Ext())
 		}
-		b, err := ioutil.ReadAll(cotainer)
+
+		objects = append(objects, &fileObject{
+			destination: destination,
+			contentType: v.ContentType(),
+			data:        v.Data,
+		})
+
+		url, err := u.fileUrl(ctx, destination)
 		if err != nil {
-			errs <- err
-			return
+			return nil, nil, err
 		}
 
-		// Upload an object to storage
-		dest := fmt.Sprintf("./assets/images/%s", job.Destination)
-		if err := os.WriteFile(dest, b, 0777); err != nil {
-			if err := os.MkdirAll("./assets/images/"+strings.Replace(job.Destination, job.FileName, "", 1), 0777); err != nil {
-				errs <- fmt.Errorf("mkdir \"./assets/images/%s\" failed: %v", err, job.Destination)
-				return
-			}
-			if err := os.WriteFile(dest, b, 0777); err != nil {
-				errs <- fmt.Errorf("write file failed: %v", err)
-				return
-			}
+		res.Renditions = append(res.Renditions, &entities.ImageRendition{
+			Name:     v.Name,
+			Format:   v.Format,
+			Width:    v.Width,
+			Height:   v.Height,
+			FileName: path.Base(destination),
+			Url:      url,
+		})
+	}
+
+	return objects, res, nil
+}
+
+// register records the upload, renditions belong to the entry of their original
+func (u *filesUsecase) register(job *files.FileReq, res *files.FileRes, objects []*fileObject) error {
+	file := &files.File{
+		StorageKey: job.Destination,
+		FileName:   job.FileName,
+		Url:        res.Url,
+		Checksum:   res.Checksum,
+		Private:    res.Private,
+		Renditions: make(entities.ImageRenditions, 0, len(res.Renditions)),
+	}
+	if job.OwnerID != "" {
+		file.OwnerID = &job.OwnerID
+	}
+
+	for _, obj := range objects {
+		if obj.destination != job.Destination {
+			continue
 		}
 
-		newFile := &filesPub{
-			file: &files.FileRes{
-				FileName: job.FileName,
-				Url:      fmt.Sprintf("http://%s:%d/public/%s", u.cfg.App().Host(), u.cfg.App().Port(), job.Destination),
-			},
-			destination: job.Destination,
+		file.Size = int64(len(obj.data))
+		file.ContentType = obj.contentType
+	}
+
+	// Signed urls expire, private files are referenced by storage key
+	if file.Private {
+		file.Url = job.Destination
+	}
+
+	// Urls are rebuilt when a duplicate upload returns them
+	for _, r := range res.Renditions {
+		rendition := *r
+		rendition.Url = ""
+		file.Renditions = append(file.Renditions, &rendition)
+	}
+
+	if err := u.filesRepository.InsertFile(file); err != nil {
+		// Do not leave objects nobody knows about
+		if err := u.deleteObjects(u.deleteJobs([]*files.DeleteFileReq{{Destination: job.Destination}})); err != nil {
+			log.Printf("delete unregistered file %s failed: %v", job.Destination, err)
 		}
+		return err
+	}
 
-		errs <- nil
-		results <- newFile.file
+	return nil
+}
+
+// fileUrl returns the public url, or a signed url for private destinations
+func (u *filesUsecase) fileUrl(ctx context.Context, destination string) (string, error) {
+	if !u.cfg.Storage().IsPrivate(destination) {
+		return u.storage.Url(destination), nil
 	}
+	return u.storage.SignedUrl(ctx, destination, u.cfg.Storage().SignedUrlExpires())
 }
 
-func (u *filesUsecase) deleteFileWorkers(ctx context.Context, client *storage.Client, jobs <-chan *files.DeleteFileReq, errs chan<- error) {
-	for job := range jobs {
-		o := client.Bucket(u.cfg.App().GCPBucket()).Object(job.Destination)
+// renditionUrls fills the urls of renditions stored next to destination
+func (u *filesUsecase) renditionUrls(ctx context.Context, destination string, renditions entities.ImageRenditions) (entities.ImageRenditions, error) {
+	res := make(entities.ImageRenditions, 0, len(renditions))
+	for _, r := range renditions {
+		rendition := *r
 
-		// Optional: set a generation-match precondition to avoid potential race
-		// conditions and data corruptions. The request to delete the file is aborted
-		// if the object's generation number does not match your precondition.
-		attrs, err := o.Attrs(ctx)
-		if err != nil {
-			errs <- fmt.Errorf("object.Attrs: %v", err)
-			return
+		var err error
+		if rendition.Url, err = u.fileUrl(ctx, path.Join(path.Dir(destination), r.FileName)); err != nil {
+			return nil, err
 		}
-		o = o.If(storage.Conditions{GenerationMatch: attrs.Generation})
+		res = append(res, &rendition)
+	}
 
-		if err := o.Delete(ctx); err != nil {
-			errs <- fmt.Errorf("Object(%q).Delete: %v", job.Destination, err)
-			return
+	return res, nil
+}
+
+// renditionDestination names a rendition after its original,
+// e.g. products/abc.jpg -> products/abc_thumbnail.webp
+func renditionDestination(destination, name, ext string) string {
+	base := strings.TrimSuffix(destination, path.Ext(destination))
+	if name == imaging.Original {
+		return fmt.Sprintf("%s.%s", base, ext)
+	}
+	return fmt.Sprintf("%s_%s.%s", base, name, ext)
+}
+
+// deleteJobs expands every image into the renditions generated on upload
+func (u *filesUsecase) deleteJobs(req []*files.DeleteFileReq) []*deleteJob {
+	jobs := make([]*deleteJob, 0, len(req))
+	for _, r := range req {
+		jobs = append(jobs, &deleteJob{destination: r.Destination})
+
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(r.Destination), "."))
+		if !imaging.IsImage(ext) {
+			continue
+		}
+		if ext == "jpeg" {
+			ext = "jpg"
 		}
-		fmt.Printf("Blob %v deleted.\n", job.Destination)
 
-		errs <- nil
+		exts := []string{ext}
+		if u.cfg.Image().Webp() {
+			jobs = append(jobs, &deleteJob{
+				destination: renditionDestination(r.Destination, imaging.Original, imaging.FormatWebP),
+				optional:    true,
+			})
+			exts = append(exts, imaging.FormatWebP)
+		}
+
+		for _, rendition := range u.cfg.Image().Renditions() {
+			for _, e := range exts {
+				jobs = append(jobs, &deleteJob{
+					destination: renditionDestination(r.Destination, rendition.Name, e),
+					optional:    true,
+				})
+			}
+		}
 	}
+
+	return jobs
 }
 
-func (u *filesUsecase) deleteFromStorageFileWorkers(jobs <-chan *files.DeleteFileReq, errs chan<- error) {
+func (u *filesUsecase) deleteFileWorkers(ctx context.Context, jobs <-chan *deleteJob, errs chan<- error) {
 	for job := range jobs {
-		if err := os.Remove("./assets/images/" + job.Destination); err != nil {
-			errs <- fmt.Errorf("remove file: %s failed: %v", job.Destination, err)
+		if err := u.storage.Delete(ctx, job.destination); err != nil {
+			if job.optional && errors.Is(err, filesStorages.ErrNotFound) {
+				errs <- nil
+				continue
+			}
+			errs <- err
 			return
 		}
+
 		errs <- nil
 	}
 }
 
-func (u filesUsecase) UploadToGCP(req []*files.FileReq) ([]*files.FileRes, error) {
+func (u *filesUsecase) UploadToStorage(req []*files.FileReq) ([]*files.FileRes, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
 	defer cancel()
 
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("storage.NewClient: %v", err)
-	}
-	defer client.Close()
-
 	jobsCh := make(chan *files.FileReq, len(req))
 	resultsCh := make(chan *files.FileRes, len(req))
 	errsCh := make(chan error, len(req))
 
 	res := make([]*files.FileRes, 0)
 
-	// Set req values to jobsCh
 	for _, r := range req {
 		jobsCh <- r
 	}
+	close(jobsCh)
 
 	numWorkers := 5
 	for i := 0; i < numWorkers; i++ {
-		// Worker
-		go u.uploadWorkers(ctx, client, jobsCh, resultsCh, errsCh)
+		go u.uploadWorkers(ctx, jobsCh, resultsCh, errsCh)
 	}
 
-	for i := 0; i < len(req); i++ {
-		err = <-errsCh
+	for a := 0; a < len(req); a++ {
+		err := <-errsCh
 		if err != nil {
 			return nil, err
 		}
@@ -207,86 +373,470 @@ func (u filesUsecase) UploadToGCP(req []*files.FileReq) ([]*files.FileRes, error
 		result := <-resultsCh
 		res = append(res, result)
 	}
-
 	return res, nil
 }
 
-func (u *filesUsecase) UploadToStorage(req []*files.FileReq) ([]*files.FileRes, error) {
-	jobsCh := make(chan *files.FileReq, len(req))
-	resultsCh := make(chan *files.FileRes, len(req))
-	errsCh := make(chan error, len(req))
+// DeleteFileOnStorage deletes the files nothing references anymore, a file
+// shared by identical uploads is kept until its last reference is gone and
+// the orphan sweeper collects it.
+func (u *filesUsecase) DeleteFileOnStorage(req []*files.DeleteFileReq) error {
+	keys := make([]string, 0, len(req))
+	for _, r := range req {
+		keys = append(keys, r.Destination)
+	}
 
-	res := make([]*files.FileRes, 0)
+	referenced, err := u.filesRepository.FindReferencedKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+	for _, key := range referenced {
+		keep[key] = true
+	}
 
+	unreferenced := make([]*files.DeleteFileReq, 0, len(req))
+	keys = keys[:0]
 	for _, r := range req {
-		jobsCh <- r
+		if keep[r.Destination] {
+			continue
+		}
+		unreferenced = append(unreferenced, r)
+		keys = append(keys, r.Destination)
+	}
+
+	if err := u.deleteObjects(u.deleteJobs(unreferenced)); err != nil {
+		return err
+	}
+
+	return u.filesRepository.DeleteFilesByKey(keys)
+}
+
+func (u *filesUsecase) deleteObjects(jobs []*deleteJob) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	jobsCh := make(chan *deleteJob, len(jobs))
+	errsCh := make(chan error, len(jobs))
+
+	for _, job := range jobs {
+		jobsCh <- job
 	}
 	close(jobsCh)
 
 	numWorkers := 5
 	for i := 0; i < numWorkers; i++ {
-		go u.uploadToStorageWorker(jobsCh, resultsCh, errsCh)
+		go u.deleteFileWorkers(ctx, jobsCh, errsCh)
 	}
 
-	for a := 0; a < len(req); a++ {
-		err := <-errsCh
+	for a := 0; a < len(jobs); a++ {
+		if err := <-errsCh; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SweepOrphanFiles deletes files no product or order references anymore,
+// the registry entries go first so a file is never half deleted twice.
+func (u *filesUsecase) SweepOrphanFiles(before time.Time) (int, error) {
+	count := 0
+	for {
+		orphans, err := u.filesRepository.DeleteOrphanFiles(before, 100)
 		if err != nil {
-			return nil, err
+			return count, err
 		}
 
-		result := <-resultsCh
-		res = append(res, result)
+		req := make([]*files.DeleteFileReq, 0, len(orphans))
+		for _, orphan := range orphans {
+			req = append(req, &files.DeleteFileReq{Destination: orphan.StorageKey})
+		}
+
+		// Objects may already be gone, e.g. deleted with their product
+		jobs := u.deleteJobs(req)
+		for _, job := range jobs {
+			job.optional = true
+		}
+		if err := u.deleteObjects(jobs); err != nil {
+			log.Printf("delete orphan files failed: %v", err)
+		}
+
+		count += len(orphans)
+		if len(orphans) < 100 {
+			return count, nil
+		}
 	}
-	return res, nil
 }
 
-func (u *filesUsecase) DeleteFileOnGCP(req []*files.DeleteFileReq) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+func (u *filesUsecase) FileUrl(destination string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
-	client, err := storage.NewClient(ctx)
+	return u.fileUrl(ctx, destination)
+}
+
+// FindOwnedFile returns the registered file at destination, files uploaded
+// by other users are not found
+func (u *filesUsecase) FindOwnedFile(ownerID, destination string) (*files.File, error) {
+	return u.filesRepository.FindOwnedFile(ownerID, destination)
+}
+
+// OpenPrivateFile verifies an hmac signed url and opens the file with its content type
+func (u *filesUsecase) OpenPrivateFile(destination, expires, signature string) (io.ReadCloser, string, error) {
+	if !u.cfg.Storage().IsPrivate(destination) {
+		return nil, "", filesStorages.ErrNotFound
+	}
+
+	if err := filesStorages.VerifySignature(u.cfg.Storage(), destination, expires, signature); err != nil {
+		return nil, "", err
+	}
+
+	file, err := u.storage.Open(context.Background(), destination)
 	if err != nil {
-		return fmt.Errorf("storage.NewClient: %v", err)
+		return nil, "", err
 	}
-	defer client.Close()
 
-	jobsCh := make(chan *files.DeleteFileReq, len(req))
-	errsCh := make(chan error, len(req))
+	contentType := mime.TypeByExtension(path.Ext(destination))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
 
-	for _, r := range req {
-		jobsCh <- r
+	return file, contentType, nil
+}
+
+// partDestination is where chunk number part of a resumable upload is stored
+func partDestination(uploadID string, part int) string {
+	return fmt.Sprintf("%s/%s/%06d", config.TusPartsDestination, uploadID, part)
+}
+
+// partsReader streams the parts of an upload one after another, only one
+// part is open at a time
+type partsReader struct {
+	ctx     context.Context
+	storage filesStorages.IFilesStorage
+	upload  *files.Upload
+	part    int
+	current io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.part >= r.upload.Parts {
+				return 0, io.EOF
+			}
+
+			part, err := r.storage.Open(r.ctx, partDestination(r.upload.ID, r.part))
+			if err != nil {
+				return 0, err
+			}
+			r.current = part
+			r.part++
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			_ = r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
 	}
-	close(jobsCh)
+}
 
-	numWorkers := 5
-	for i := 0; i < numWorkers; i++ {
-		go u.deleteFileWorkers(ctx, client, jobsCh, errsCh)
+func (r *partsReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
 	}
+	return nil
+}
 
-	for a := 0; a < len(req); a++ {
-		err := <-errsCh
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (u *filesUsecase) CreateUpload(req *files.Upload) (*files.Upload, error) {
+	if req.Length > u.cfg.Storage().TusMaxSize() {
+		return nil, fmt.Errorf("%w: length must not exceed %d bytes", ErrUploadTooLarge, u.cfg.Storage().TusMaxSize())
+	}
+
+	if err := u.filesRepository.InsertUpload(req, u.cfg.Storage().TusExpires()); err != nil {
+		return nil, err
+	}
+
+	// Empty files are complete right away, there are no parts to join
+	if req.Length == 0 {
+		upload, err := u.filesRepository.ClaimUploadCompletion(req.ID, completeUploadTimeout+time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		if err := u.completeUpload(upload); err != nil {
+			return nil, err
+		}
+		return upload, nil
+	}
+
+	return req, nil
+}
+
+func (u *filesUsecase) FindOneUpload(uploadID string) (*files.Upload, error) {
+	return u.filesRepository.FindOneUpload(uploadID)
+}
+
+// AppendUpload stores the chunk as the next part of the upload, the parts are
+// joined into the final file in the background once the whole length has been
+// received. No lock is held while the chunk is stored, the offset is reserved
+// first and only moves once the part is in storage.
+func (u *filesUsecase) AppendUpload(uploadID string, offset int64, data io.Reader) (*files.Upload, error) {
+	timeout := u.cfg.App().WriteTimeout() + time.Second*60
+
+	// The reservation outlives the timeout, so an abandoned one is only taken
+	// over once its request has given up
+	upload, err := u.filesRepository.ReserveUpload(uploadID, offset, timeout+time.Minute)
+	if err != nil {
+		return upload, err
+	}
+
+	// A retry after a failed completion sends an empty chunk at the end
+	if upload.Offset == upload.Length {
+		if err := u.filesRepository.ReleaseUpload(upload); err != nil {
+			return nil, err
+		}
+		return u.startCompletion(upload.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	n, err := u.storePart(ctx, upload, data)
+	if err != nil {
+		if err := u.filesRepository.ReleaseUpload(upload); err != nil {
+			log.Printf("release upload %s failed: %v", upload.ID, err)
+		}
+		return nil, err
+	}
+
+	if err := u.filesRepository.CommitUpload(upload, n); err != nil {
+		return nil, err
+	}
+
+	if upload.Completing {
+		go u.completeInBackground(upload)
+	}
+
+	return upload, nil
+}
+
+// storePart writes the chunk as the next part of the upload
+func (u *filesUsecase) storePart(ctx context.Context, upload *files.Upload, data io.Reader) (int64, error) {
+	remaining := upload.Length - upload.Offset
+	chunk := &countingReader{r: io.LimitReader(data, remaining)}
+
+	destination := partDestination(upload.ID, upload.Parts)
+	if err := u.storage.Upload(ctx, destination, "application/octet-stream", chunk); err != nil {
+		return 0, err
+	}
+
+	// Anything left means the chunk is longer than the upload
+	if n, _ := data.Read(make([]byte, 1)); n > 0 {
+		_ = u.storage.Delete(ctx, destination)
+		return 0, fmt.Errorf("%w: chunk exceeds upload length of %d bytes", ErrUploadTooLarge, upload.Length)
+	}
+
+	return chunk.n, nil
+}
+
+// startCompletion joins the parts in the background unless another request
+// already does
+func (u *filesUsecase) startCompletion(uploadID string) (*files.Upload, error) {
+	upload, err := u.filesRepository.ClaimUploadCompletion(uploadID, completeUploadTimeout+time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	go u.completeInBackground(upload)
+	return upload, nil
+}
+
+func (u *filesUsecase) completeInBackground(upload *files.Upload) {
+	if err := u.completeUpload(upload); err != nil {
+		log.Printf("complete upload %s failed: %v", upload.ID, err)
+	}
+}
+
+// completeUpload joins the parts into the final file. The file is named after
+// its content, which is only known once the parts are hashed, so the name
+// given on creation is replaced and identical content is stored only once.
+// The parts are read once to check, scan and hash them, and once more to be
+// joined unless the content is already stored. A failure is kept on the
+// upload so the client can see it and retry.
+func (u *filesUsecase) completeUpload(upload *files.Upload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), completeUploadTimeout)
+	defer cancel()
+
+	file, err := u.joinUpload(ctx, upload)
+	if err == nil {
+		err = u.filesRepository.CompleteUpload(upload, file)
+	}
+	if err != nil {
+		// Nothing worth resuming after a rejection, the parts are dropped
+		rejected := errors.Is(err, ErrFileRejected) ||
+			errors.Is(err, filesScanners.ErrInfected) ||
+			errors.Is(err, filesScanners.ErrTooLargeToScan)
+		if err := u.filesRepository.FailUpload(upload, err.Error(), rejected); err != nil {
+			log.Printf("fail upload %s failed: %v", upload.ID, err)
+		}
+		if rejected {
+			u.deleteUploadParts(upload)
+		}
 		return err
 	}
+
+	u.deleteUploadParts(upload)
 	return nil
 }
 
-func (u *filesUsecase) DeleteFileOnStorage(req []*files.DeleteFileReq) error {
-	jobsCh := make(chan *files.DeleteFileReq, len(req))
-	errsCh := make(chan error, len(req))
+func (u *filesUsecase) joinUpload(ctx context.Context, upload *files.Upload) (*files.File, error) {
+	ext := strings.TrimPrefix(path.Ext(upload.Destination), ".")
 
-	for _, r := range req {
-		jobsCh <- r
+	// The head is checked and the scanner reads the parts while they are hashed
+	parts := &partsReader{ctx: ctx, storage: u.storage, upload: upload}
+	buffered := bufio.NewReaderSize(parts, 512)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		_ = parts.Close()
+		return nil, fmt.Errorf("read upload parts failed: %v", err)
 	}
-	close(jobsCh)
 
-	numWorkers := 5
-	for i := 0; i < numWorkers; i++ {
-		go u.deleteFromStorageFileWorkers(jobsCh, errsCh)
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(buffered, hash)}
+	err = checkContent(ext, head)
+	if err == nil {
+		err = u.scanner.Scan(ctx, counter)
 	}
+	_ = parts.Close()
+	if err != nil {
+		return nil, err
+	}
+	size := counter.n
 
-	for a := 0; a < len(req); a++ {
-		err := <-errsCh
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	destination := contentDestination(path.Dir(upload.Destination), checksum, ext)
+
+	file, err := u.filesRepository.ReuseFile(destination)
+	if err == nil {
+		return file, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	parts = &partsReader{ctx: ctx, storage: u.storage, upload: upload}
+	defer parts.Close()
+
+	if err := u.storage.Upload(ctx, destination, upload.ContentType, parts); err != nil {
+		return nil, fmt.Errorf("join upload parts failed: %v", err)
+	}
+
+	file = &files.File{
+		OwnerID:     &upload.OwnerID,
+		StorageKey:  destination,
+		FileName:    path.Base(destination),
+		Url:         u.storage.Url(destination),
+		ContentType: upload.ContentType,
+		Size:        size,
+		Checksum:    checksum,
+		Private:     u.cfg.Storage().IsPrivate(destination),
+		Renditions:  make(entities.ImageRenditions, 0),
+	}
+	if file.Private {
+		file.Url = destination
+	}
+
+	return file, nil
+}
+
+func (u *filesUsecase) deleteUploadParts(upload *files.Upload) {
+	jobs := make([]*deleteJob, 0, upload.Parts)
+	for i := 0; i < upload.Parts; i++ {
+		jobs = append(jobs, &deleteJob{destination: partDestination(upload.ID, i), optional: true})
+	}
+
+	if err := u.deleteObjects(jobs); err != nil {
+		log.Printf("delete parts of upload %s failed: %v", upload.ID, err)
+	}
+}
+
+func (u *filesUsecase) TerminateUpload(uploadID string) error {
+	upload, err := u.filesRepository.FindOneUpload(uploadID)
+	if err != nil {
 		return err
 	}
+
+	// The parts are still being read
+	if upload.Completing {
+		return filesRepositories.ErrUploadBusy
+	}
+
+	if err := u.filesRepository.DeleteUpload(upload.ID); err != nil {
+		return err
+	}
+
+	if !upload.Completed() {
+		u.deleteUploadParts(upload)
+	}
 	return nil
 }
+
+func (u *filesUsecase) FindUploadedFile(upload *files.Upload) (*files.FileRes, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	res := &files.FileRes{
+		FileName:    upload.FileName,
+		Destination: upload.Destination,
+		Checksum:    strings.TrimSuffix(upload.FileName, path.Ext(upload.FileName)),
+		Private:     u.cfg.Storage().IsPrivate(upload.Destination),
+	}
+
+	var err error
+	if res.Url, err = u.fileUrl(ctx, upload.Destination); err != nil {
+		return nil, err
+	}
+	if res.Private {
+		res.ExpiresAt = time.Now().Add(u.cfg.Storage().SignedUrlExpires()).Format("2006-01-02 15:04:05")
+	}
+
+	return res, nil
+}
+
+// SweepExpiredUploads removes resumable uploads past their expiry, with the
+// parts of the ones never completed
+func (u *filesUsecase) SweepExpiredUploads() (int, error) {
+	uploads, err := u.filesRepository.FindExpiredUploads()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, upload := range uploads {
+		if err := u.filesRepository.DeleteUpload(upload.ID); err != nil {
+			return 0, err
+		}
+
+		if !upload.Completed() {
+			u.deleteUploadParts(upload)
+		}
+	}
+
+	return len(uploads), nil
+}
@@ -0,0 +1,225 @@
+package filesUsecases
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/korvised/go-ecommerce/config"
+	"github.com/korvised/go-ecommerce/modules/files"
+	"github.com/korvised/go-ecommerce/modules/files/filesRepositories"
+	"github.com/korvised/go-ecommerce/modules/files/filesScanners"
+	"github.com/korvised/go-ecommerce/modules/files/filesStorages"
+	"mime/multipart"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testStorageConfig struct {
+	config.IStorageConfig
+}
+
+func (c *testStorageConfig) PublicUrl() string                        { return "" }
+func (c *testStorageConfig) IsPrivate(destination string) bool        { return false }
+func (c *testStorageConfig) SignedUrlExpires() time.Duration          { return time.Minute }
+func (c *testStorageConfig) TusMaxSize() int64                        { return 1 << 20 }
+func (c *testStorageConfig) TusExpires() time.Duration                { return time.Hour }
+func (c *testStorageConfig) AllowedTypes(destination string) []string { return nil }
+
+type testConfig struct {
+	config.IConfig
+}
+
+func (c *testConfig) Storage() config.IStorageConfig { return &testStorageConfig{} }
+
+// testFilesRepository keeps files by storage key and the outcome of the
+// last completed or failed upload
+type testFilesRepository struct {
+	filesRepositories.IFilesRepository
+	mu        sync.Mutex
+	files     map[string]*files.File
+	completed *files.File
+	failed    string
+	rejected  bool
+}
+
+func (r *testFilesRepository) InsertFile(req *files.File) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.files[req.StorageKey] = req
+	return nil
+}
+
+func (r *testFilesRepository) ReuseFile(storageKey string) (*files.File, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if file, ok := r.files[storageKey]; ok {
+		return file, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *testFilesRepository) CompleteUpload(upload *files.Upload, file *files.File) error {
+	r.completed = file
+	return nil
+}
+
+func (r *testFilesRepository) FailUpload(upload *files.Upload, reason string, rejected bool) error {
+	r.failed = reason
+	r.rejected = rejected
+	return nil
+}
+
+func newTestUsecase() (*filesUsecase, filesStorages.IMemoryStorage, *testFilesRepository) {
+	cfg := &testConfig{}
+	storage := filesStorages.MemoryStorage(cfg)
+	repo := &testFilesRepository{files: make(map[string]*files.File)}
+	scanner := filesScanners.SignatureScanner(map[string]string{"eicar": filesScanners.EicarSignature})
+
+	return &filesUsecase{
+		cfg:             cfg,
+		storage:         storage,
+		scanner:         scanner,
+		filesRepository: repo,
+	}, storage, repo
+}
+
+// newTestFileHeader builds the header of a multipart file field, like the
+// handler receives it
+func newTestFileHeader(t *testing.T, filename string, content []byte) *multipart.FileHeader {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("create form file failed: %v", err)
+	}
+	_, _ = part.Write(content)
+	_ = writer.Close()
+
+	form, err := multipart.NewReader(body, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("read form failed: %v", err)
+	}
+	return form.File["file"][0]
+}
+
+type testUploadToStorage struct {
+	name    string
+	ext     string
+	content []byte
+	expect  error
+}
+
+func TestUploadToStorage(t *testing.T) {
+	tests := []testUploadToStorage{
+		{name: "clean", ext: "txt", content: []byte("hello world"), expect: nil},
+		{name: "infected", ext: "txt", content: []byte(filesScanners.EicarSignature), expect: filesScanners.ErrInfected},
+		{name: "mismatched", ext: "pdf", content: []byte("hello world"), expect: ErrFileRejected},
+	}
+
+	for _, test := range tests {
+		usecase, storage, repo := newTestUsecase()
+
+		res, err := usecase.UploadToStorage([]*files.FileReq{{
+			File:        newTestFileHeader(t, "a."+test.ext, test.content),
+			Destination: "docs",
+			Extension:   test.ext,
+		}})
+		if !errors.Is(err, test.expect) {
+			t.Errorf("%s: expect: %v, got: %v", test.name, test.expect, err)
+			continue
+		}
+
+		if test.expect != nil {
+			if storage.Len() != 0 || len(repo.files) != 0 {
+				t.Errorf("%s: expect: %s, got: %d stored", test.name, "nothing stored", storage.Len())
+			}
+			continue
+		}
+
+		file, ok := storage.Get(res[0].Destination)
+		if !ok || !bytes.Equal(file.Data, test.content) {
+			t.Errorf("%s: expect: %s, got: %+v", test.name, "the content stored", file)
+		}
+		if _, ok := repo.files[res[0].Destination]; !ok {
+			t.Errorf("%s: expect: %s, got: %v", test.name, "the file registered", repo.files)
+		}
+	}
+}
+
+// storeTestParts splits content into the parts of a received upload
+func storeTestParts(t *testing.T, storage filesStorages.IFilesStorage, upload *files.Upload, content []byte, size int) {
+	for i := 0; i < len(content); i += size {
+		end := min(i+size, len(content))
+		if err := storage.Upload(context.Background(), partDestination(upload.ID, upload.Parts), "application/octet-stream", bytes.NewReader(content[i:end])); err != nil {
+			t.Fatalf("store part failed: %v", err)
+		}
+		upload.Parts++
+	}
+	upload.Length = int64(len(content))
+	upload.Offset = upload.Length
+}
+
+type testCompleteUpload struct {
+	name     string
+	filename string
+	content  []byte
+	expect   error
+	rejected bool
+}
+
+func TestCompleteUpload(t *testing.T) {
+	pdf := append([]byte("%PDF-1.7\n"), bytes.Repeat([]byte("x"), 2000)...)
+	infected := append(append([]byte("%PDF-1.7\n"), bytes.Repeat([]byte("x"), 1000)...), filesScanners.EicarSignature...)
+
+	tests := []testCompleteUpload{
+		{name: "clean", filename: "report.pdf", content: pdf, expect: nil},
+		{name: "infected in a later part", filename: "report.pdf", content: infected, expect: filesScanners.ErrInfected, rejected: true},
+		{name: "mismatched", filename: "report.png", content: pdf, expect: ErrFileRejected, rejected: true},
+	}
+
+	for _, test := range tests {
+		usecase, storage, repo := newTestUsecase()
+
+		upload := &files.Upload{
+			ID:          "upload-1",
+			OwnerID:     "user-1",
+			FileName:    test.filename,
+			Destination: "docs/" + test.filename,
+			ContentType: "application/pdf",
+		}
+		storeTestParts(t, storage, upload, test.content, 512)
+
+		err := usecase.completeUpload(upload)
+		if !errors.Is(err, test.expect) {
+			t.Errorf("%s: expect: %v, got: %v", test.name, test.expect, err)
+			continue
+		}
+
+		if test.expect != nil {
+			if repo.failed == "" || repo.rejected != test.rejected {
+				t.Errorf("%s: expect: %s, got: %q rejected %v", test.name, "the failure kept", repo.failed, repo.rejected)
+			}
+			if storage.Len() != 0 {
+				t.Errorf("%s: expect: %s, got: %d stored", test.name, "the parts dropped", storage.Len())
+			}
+			continue
+		}
+
+		if repo.completed == nil || repo.completed.Size != int64(len(test.content)) {
+			t.Fatalf("%s: expect: %s, got: %+v", test.name, "a completed file", repo.completed)
+		}
+		file, ok := storage.Get(repo.completed.StorageKey)
+		if !ok || !bytes.Equal(file.Data, test.content) {
+			t.Errorf("%s: expect: %s, got: %v", test.name, "the parts joined", ok)
+		}
+
+		// Only the joined file is left
+		if storage.Len() != 1 {
+			t.Errorf("%s: expect: %d, got: %d", test.name, 1, storage.Len())
+		}
+	}
+}
@@ -3,9 +3,24 @@ package middlewares
 const (
 	RoleUser  = 1
 	RoleAdmin = 2
+	RoleStaff = 3
 )
 
-type Role struct {
-	ID    int    `db:"id"`
-	Title string `db:"title"`
+// Permissions seeded by the roles_permissions migration, roles are granted
+// any set of them by the roles endpoints
+const (
+	PermProductsReadAny = "products:read:any"
+	PermProductsWrite   = "products:write"
+	PermCategoriesWrite = "categories:write"
+	PermOrdersReadAny   = "orders:read:any"
+	PermOrdersWriteAny  = "orders:write:any"
+	PermFilesWrite      = "files:write"
+	PermApiKeysWrite    = "apikeys:write"
+	PermUsersAdmin      = "users:admin"
+	PermRolesWrite      = "roles:write"
+)
+
+type RolePermission struct {
+	RoleID     int    `db:"role_id"`
+	Permission string `db:"permission"`
 }
@@ -8,21 +8,23 @@ import (
 	"github.com/korvised/go-ecommerce/modules/entities"
 	"github.com/korvised/go-ecommerce/modules/middlewares/middlewaresUsecases"
 	"github.com/korvised/go-ecommerce/pkg/auth"
-	"github.com/korvised/go-ecommerce/pkg/utils"
 	"strings"
 )
 
 type middlewaresHandlerErrCode string
 
 const (
-	UserID                                   = "UserID"
-	UserRoleID                               = "UserRoleID"
-	ApiKey                                   = "X-Api-Key"
-	routerCheckErr middlewaresHandlerErrCode = "middleware-001"
-	jwtAuthErr     middlewaresHandlerErrCode = "middleware-002"
-	paramsCheckErr middlewaresHandlerErrCode = "middleware-003"
-	authorizeErr   middlewaresHandlerErrCode = "middleware-004"
-	apiKeyErr      middlewaresHandlerErrCode = "middleware-005"
+	UserID                                    = "UserID"
+	UserRoleID                                = "UserRoleID"
+	UserPermissions                           = "UserPermissions"
+	OauthID                                   = "OauthID"
+	ApiKey                                    = "X-Api-Key"
+	routerCheckErr  middlewaresHandlerErrCode = "middleware-001"
+	jwtAuthErr      middlewaresHandlerErrCode = "middleware-002"
+	paramsCheckErr  middlewaresHandlerErrCode = "middleware-003"
+	authorizeErr    middlewaresHandlerErrCode = "middleware-004"
+	apiKeyErr       middlewaresHandlerErrCode = "middleware-005"
+	verifiedErr     middlewaresHandlerErrCode = "middleware-006"
 )
 
 const unauthorizedMsg = "unauthorized, no permission to access this route"
@@ -34,9 +36,12 @@ type IMiddlewaresHandler interface {
 	RouterCheck() fiber.Handler
 	Logger() fiber.Handler
 	JwtAuth() fiber.Handler
+	OptionalJwtAuth() fiber.Handler
 	ParamsCheck() fiber.Handler
-	Authorize(expectRoleIDs ...int) fiber.Handler
+	Authorize(permissions ...string) fiber.Handler
+	ReloadPermissions() error
 	ApiKeyAuth() fiber.Handler
+	RequireVerified(enabled bool) fiber.Handler
 }
 
 type middlewaresHandler struct {
@@ -58,7 +63,7 @@ func (h *middlewaresHandler) Cor() fiber.Handler {
 		AllowMethods:     "GET,POST,HEAD,PUT,DELETE,PATCH",
 		AllowHeaders:     "",
 		AllowCredentials: false,
-		ExposeHeaders:    "",
+		ExposeHeaders:    "Location,Upload-Offset,Upload-Length,Upload-Expires,Tus-Resumable,Tus-Version,Tus-Extension,Tus-Max-Size",
 		MaxAge:           0,
 	})
 }
@@ -96,18 +101,40 @@ func (h middlewaresHandler) JwtAuth() fiber.Handler {
 		}
 
 		claims := result.Claims
-		if !h.middlewaresUsecase.FindAccessToken(claims.ID, token) {
+		oauthID, ok := h.middlewaresUsecase.FindAccessToken(claims.ID, token)
+		if !ok {
 			return entities.NewResponse(c).Error(fiber.StatusUnauthorized, string(jwtAuthErr), unauthorizedMsg).Res()
 		}
 
+		permissions, err := h.middlewaresUsecase.FindPermissions(claims.RoleID)
+		if err != nil {
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(jwtAuthErr), err.Error()).Res()
+		}
+
 		// Set userID
 		c.Locals(UserID, claims.ID)
 		c.Locals(UserRoleID, claims.RoleID)
+		c.Locals(UserPermissions, permissions)
+		c.Locals(OauthID, oauthID)
 
 		return c.Next()
 	}
 }
 
+// OptionalJwtAuth sets the user locals when a valid token is sent, requests
+// without an Authorization header are passed through as anonymous.
+func (h middlewaresHandler) OptionalJwtAuth() fiber.Handler {
+	jwtAuth := h.JwtAuth()
+
+	return func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" {
+			return c.Next()
+		}
+
+		return jwtAuth(c)
+	}
+}
+
 func (h *middlewaresHandler) ParamsCheck() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals(UserID)
@@ -120,39 +147,32 @@ func (h *middlewaresHandler) ParamsCheck() fiber.Handler {
 	}
 }
 
-func (h *middlewaresHandler) Authorize(expectRoleIDs ...int) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		userRoleID, ok := c.Locals(UserRoleID).(int)
-		if !ok {
-			return entities.NewResponse(c).Error(fiber.StatusUnauthorized, string(authorizeErr), unauthorizedMsg).Res()
-		}
-
-		roles, err := h.middlewaresUsecase.FindRoles()
-		if err != nil {
-			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(authorizeErr), err.Error()).Res()
-		}
-
-		sum := 0
-		for _, v := range expectRoleIDs {
-			sum += v
-		}
-
-		expectedValueBinary := utils.BinaryConverter(sum, len(roles))
-		userValueBinary := utils.BinaryConverter(userRoleID, len(roles))
-
-		// user ->     0 1 0
-		// expected -> 1 1 0
+// HasPermission reports whether the role of the signed-in user is granted
+// the permission, it is false before JwtAuth has run
+func HasPermission(c *fiber.Ctx, permission string) bool {
+	permissions, _ := c.Locals(UserPermissions).(map[string]bool)
+	return permissions[permission]
+}
 
-		for i := range userValueBinary {
-			if userValueBinary[i]&expectedValueBinary[i] == 1 {
-				return c.Next()
+// Authorize runs after JwtAuth and requires every listed permission
+func (h *middlewaresHandler) Authorize(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		for _, permission := range permissions {
+			if !HasPermission(c, permission) {
+				return entities.NewResponse(c).Error(fiber.StatusUnauthorized, string(authorizeErr), unauthorizedMsg).Res()
 			}
 		}
 
-		return entities.NewResponse(c).Error(fiber.StatusUnauthorized, string(authorizeErr), unauthorizedMsg).Res()
+		return c.Next()
 	}
 }
 
+// ReloadPermissions drops the cached role permissions, it is called after
+// the roles endpoints change them
+func (h *middlewaresHandler) ReloadPermissions() error {
+	return h.middlewaresUsecase.ReloadPermissions()
+}
+
 func (h *middlewaresHandler) ApiKeyAuth() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		apiKey := c.Get(ApiKey)
@@ -167,3 +187,20 @@ func (h *middlewaresHandler) ApiKeyAuth() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// RequireVerified rejects users who have not verified their email, it runs
+// after JwtAuth and does nothing unless enabled in config
+func (h *middlewaresHandler) RequireVerified(enabled bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !enabled {
+			return c.Next()
+		}
+
+		userID, _ := c.Locals(UserID).(string)
+		if !h.middlewaresUsecase.FindUserVerified(userID) {
+			return entities.NewResponse(c).Error(fiber.StatusForbidden, string(verifiedErr), "email is not verified").Res()
+		}
+
+		return c.Next()
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/korvised/go-ecommerce/modules/middlewares"
+	"log"
 )
 
 type IMiddlewaresRepository interface {
-	FindAccessToken(userI, accessToken string) bool
-	FindRole() ([]*middlewares.Role, error)
+	FindAccessToken(userId, accessToken string) (string, bool)
+	FindRolePermissions() ([]*middlewares.RolePermission, error)
+	FindUserVerified(userId string) bool
 }
 
 type middlewaresRepository struct {
@@ -21,32 +23,60 @@ func MiddlewaresRepository(db *sqlx.DB) IMiddlewaresRepository {
 	}
 }
 
-func (r *middlewaresRepository) FindAccessToken(userId, accessToken string) bool {
+// FindAccessToken returns the oauth session holding the access token, its
+// last_used_at is touched at most once a minute to keep writes off every request.
+// Tokens of disabled users are not found.
+func (r *middlewaresRepository) FindAccessToken(userId, accessToken string) (string, bool) {
 	query := `
-	 SELECT (CASE WHEN COUNT(*) = 1 THEN TRUE ELSE FALSE END)
-	 FROM oauth
-	 WHERE user_id = $1 AND access_token = $2;
+	 SELECT o.id, o.last_used_at < now() - INTERVAL '1 minute' AS stale
+	 FROM oauth o
+	 JOIN users u ON u.id = o.user_id
+	 WHERE o.user_id = $1 AND o.access_token = $2 AND u.disabled_at IS NULL;
 	`
 
-	var check bool
-	if err := r.db.Get(&check, query, userId, accessToken); err != nil {
-		return false
+	session := new(struct {
+		ID    string `db:"id"`
+		Stale bool   `db:"stale"`
+	})
+	if err := r.db.Get(session, query, userId, accessToken); err != nil {
+		return "", false
+	}
+
+	if session.Stale {
+		if _, err := r.db.Exec(`UPDATE oauth SET last_used_at = now() WHERE id = $1;`, session.ID); err != nil {
+			log.Printf("touch oauth %s failed: %v", session.ID, err)
+		}
 	}
 
-	return true
+	return session.ID, true
 }
 
-func (r *middlewaresRepository) FindRole() ([]*middlewares.Role, error) {
+func (r *middlewaresRepository) FindRolePermissions() ([]*middlewares.RolePermission, error) {
 	query := `
-	 SELECT id, title
-	 FROM roles
-	 ORDER BY id DESC;
+	 SELECT rp.role_id, p.name AS permission
+	 FROM roles_permissions rp
+	 JOIN permissions p ON p.id = rp.permission_id;
 	`
 
-	roles := make([]*middlewares.Role, 0)
-	if err := r.db.Select(&roles, query); err != nil {
-		return nil, fmt.Errorf("roles are empty")
+	permissions := make([]*middlewares.RolePermission, 0)
+	if err := r.db.Select(&permissions, query); err != nil {
+		return nil, fmt.Errorf("find role permissions failed: %v", err)
+	}
+
+	return permissions, nil
+}
+
+func (r *middlewaresRepository) FindUserVerified(userId string) bool {
+	query := `
+	 SELECT verified
+	 FROM users
+	 WHERE id = $1;
+	`
+
+	var verified bool
+	if err := r.db.Get(&verified, query, userId); err != nil {
+		return false
 	}
 
-	return roles, nil
+	return verified
 }
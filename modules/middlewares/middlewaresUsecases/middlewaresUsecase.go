@@ -1,17 +1,27 @@
 package middlewaresUsecases
 
 import (
-	"github.com/korvised/go-ecommerce/modules/middlewares"
 	"github.com/korvised/go-ecommerce/modules/middlewares/middlewaresRepositories"
+	"sync"
+	"time"
 )
 
+// permissionsTTL bounds how long a role change on another instance goes unseen
+const permissionsTTL = time.Minute
+
 type IMiddlewareUsecase interface {
-	FindAccessToken(userId, accessToken string) bool
-	FindRoles() ([]*middlewares.Role, error)
+	FindAccessToken(userId, accessToken string) (string, bool)
+	FindPermissions(roleId int) (map[string]bool, error)
+	ReloadPermissions() error
+	FindUserVerified(userId string) bool
 }
 
 type middlewareUsecase struct {
 	middlewareRepository middlewaresRepositories.IMiddlewaresRepository
+
+	mu          sync.RWMutex
+	permissions map[int]map[string]bool // role id -> granted permissions
+	loadedAt    time.Time
 }
 
 func MiddlewareUsecase(middlewareRepository middlewaresRepositories.IMiddlewaresRepository) IMiddlewareUsecase {
@@ -20,10 +30,52 @@ func MiddlewareUsecase(middlewareRepository middlewaresRepositories.IMiddlewares
 	}
 }
 
-func (u *middlewareUsecase) FindAccessToken(userId, accessToken string) bool {
+func (u *middlewareUsecase) FindAccessToken(userId, accessToken string) (string, bool) {
 	return u.middlewareRepository.FindAccessToken(userId, accessToken)
 }
 
-func (u *middlewareUsecase) FindRoles() ([]*middlewares.Role, error) {
-	return u.middlewareRepository.FindRole()
+// FindPermissions returns the permissions of a role from the cached map, the
+// map is loaded again once it is older than permissionsTTL
+func (u *middlewareUsecase) FindPermissions(roleId int) (map[string]bool, error) {
+	u.mu.RLock()
+	permissions, fresh := u.permissions, time.Since(u.loadedAt) < permissionsTTL
+	u.mu.RUnlock()
+
+	if permissions == nil || !fresh {
+		if err := u.ReloadPermissions(); err != nil {
+			return nil, err
+		}
+
+		u.mu.RLock()
+		permissions = u.permissions
+		u.mu.RUnlock()
+	}
+
+	return permissions[roleId], nil
+}
+
+func (u *middlewareUsecase) ReloadPermissions() error {
+	rows, err := u.middlewareRepository.FindRolePermissions()
+	if err != nil {
+		return err
+	}
+
+	permissions := make(map[int]map[string]bool)
+	for _, row := range rows {
+		if permissions[row.RoleID] == nil {
+			permissions[row.RoleID] = make(map[string]bool)
+		}
+		permissions[row.RoleID][row.Permission] = true
+	}
+
+	u.mu.Lock()
+	u.permissions = permissions
+	u.loadedAt = time.Now()
+	u.mu.Unlock()
+
+	return nil
+}
+
+func (u *middlewareUsecase) FindUserVerified(userId string) bool {
+	return u.middlewareRepository.FindUserVerified(userId)
 }
@@ -1,6 +1,7 @@
 package orders
 
 import (
+	"github.com/korvised/go-ecommerce/modules/addresses"
 	"github.com/korvised/go-ecommerce/modules/entities"
 	"github.com/korvised/go-ecommerce/modules/products"
 )
@@ -15,23 +16,26 @@ type OrderFilter struct {
 }
 
 type Order struct {
-	ID           string           `db:"id" json:"id"`
-	UserID       string           `db:"user_id" json:"user_id"`
-	TransferSlip *TransferSlip    `db:"transfer_slip" json:"transfer_slip"`
-	Products     []*ProductsOrder `json:"products"`
-	Address      string           `db:"address" json:"address"`
-	Contact      string           `db:"contact" json:"contact"`
-	Status       string           `db:"status" json:"status"`
-	TotalPaid    float64          `db:"total_paid" json:"total_paid"`
-	CreatedAt    string           `db:"created_at" json:"created_at"`
-	UpdatedAt    string           `db:"updated_at" json:"updated_at"`
+	ID              string             `db:"id" json:"id"`
+	UserID          string             `db:"user_id" json:"user_id"`
+	TransferSlip    *TransferSlip      `db:"transfer_slip" json:"transfer_slip"`
+	Products        []*ProductsOrder   `json:"products"`
+	AddressID       string             `json:"address_id,omitempty"` // address book entry to ship to
+	Address         string             `db:"address" json:"address"`
+	Contact         string             `db:"contact" json:"contact"`
+	ShippingAddress *addresses.Address `db:"shipping_address" json:"shipping_address"` // copy of the entry at checkout
+	Status          string             `db:"status" json:"status"`
+	TotalPaid       float64            `db:"total_paid" json:"total_paid"`
+	CreatedAt       string             `db:"created_at" json:"created_at"`
+	UpdatedAt       string             `db:"updated_at" json:"updated_at"`
 }
 
 type TransferSlip struct {
-	ID        string `json:"id"`
-	FileName  string `json:"fileName"`
-	Url       string `json:"url"`
-	CreatedAt string `json:"created_at"`
+	ID          string `json:"id"`
+	FileName    string `json:"fileName"`
+	Destination string `json:"destination,omitempty"` // storage key of a file the user uploaded to slips, the rest is filled from the files registry
+	Url         string `json:"url"`
+	CreatedAt   string `json:"created_at"`
 }
 
 type ProductsOrder struct {
@@ -44,4 +48,6 @@ type UpdateOrderReq struct {
 	ID           string        `form:"id" json:"id"`
 	TransferSlip *TransferSlip `form:"transfer_slip" json:"transfer_slip"`
 	Status       string        `form:"status" json:"status"`
+	UserID       string        `form:"-" json:"-"` // the caller
+	WriteAny     bool          `form:"-" json:"-"` // orders of other users can be changed
 }
@@ -2,8 +2,8 @@ package ordersHandlers
 
 import (
 	"database/sql"
+	"errors"
 	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
 	"github.com/korvised/go-ecommerce/config"
 	"github.com/korvised/go-ecommerce/modules/entities"
 	"github.com/korvised/go-ecommerce/modules/middlewares"
@@ -44,8 +44,9 @@ func OrdersHandler(cfg config.IConfig, ordersUsecase ordersUsecases.IOrdersUseca
 
 func (h *ordersHandler) FindOneOrder(c *fiber.Ctx) error {
 	orderID := strings.Trim(c.Params("order_id"), " ")
+	userID := c.Locals(middlewaresHandlers.UserID).(string)
 
-	order, err := h.ordersUsecase.FindOneOrder(orderID)
+	order, err := h.ordersUsecase.FindOneOrder(orderID, userID, middlewaresHandlers.HasPermission(c, middlewares.PermOrdersReadAny))
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -167,6 +168,9 @@ func (h *ordersHandler) InsertOrder(c *fiber.Ctx) error {
 
 	order, err := h.ordersUsecase.InsertOrder(req)
 	if err != nil {
+		if errors.Is(err, ordersUsecases.ErrTransferSlipInvalid) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(insertOrderErr), err.Error()).Res()
+		}
 		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(insertOrderErr), err.Error()).Res()
 	}
 
@@ -175,7 +179,6 @@ func (h *ordersHandler) InsertOrder(c *fiber.Ctx) error {
 
 func (h *ordersHandler) UpdateOrder(c *fiber.Ctx) error {
 	orderID := strings.Trim(c.Params("order_id"), " ")
-	roleID := c.Locals(middlewaresHandlers.UserRoleID).(int)
 
 	req := new(orders.UpdateOrderReq)
 
@@ -185,6 +188,8 @@ func (h *ordersHandler) UpdateOrder(c *fiber.Ctx) error {
 
 	req.ID = orderID
 	req.Status = strings.ToLower(req.Status)
+	req.UserID = c.Locals(middlewaresHandlers.UserID).(string)
+	req.WriteAny = middlewaresHandlers.HasPermission(c, middlewares.PermOrdersWriteAny)
 
 	statusMap := map[string]string{
 		"waiting":   "waiting",
@@ -193,8 +198,8 @@ func (h *ordersHandler) UpdateOrder(c *fiber.Ctx) error {
 		"canceled":  "canceled",
 	}
 
-	// Check role user can update only "canceled" status
-	if roleID == middlewares.RoleUser && req.Status != statusMap["canceled"] {
+	// Without orders:write:any a user can only cancel
+	if !req.WriteAny && req.Status != statusMap["canceled"] {
 		return entities.NewResponse(c).Error(
 			fiber.StatusBadRequest,
 			string(updateOrderErr),
@@ -202,20 +207,28 @@ func (h *ordersHandler) UpdateOrder(c *fiber.Ctx) error {
 		).Res()
 	}
 
-	if req.TransferSlip != nil {
-		if req.TransferSlip.ID == "" {
-			req.TransferSlip.ID = uuid.NewString()
-		}
-
-		// YYYY-MM-DD HH:MM:SS
-		// 2006-01-02 15:04:05
-		loc, _ := time.LoadLocation("Asia/Vientiane")
-		req.TransferSlip.CreatedAt = time.Now().In(loc).Format("2006-01-02 15:04:05")
-	}
-
 	order, err := h.ordersUsecase.UpdateOrder(req)
 	if err != nil {
-		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(updateOrderErr), err.Error()).Res()
+		switch err {
+		case sql.ErrNoRows:
+			return entities.NewResponse(c).Error(
+				fiber.StatusBadRequest,
+				string(updateOrderErr),
+				"order not found",
+			).Res()
+		case ordersUsecases.ErrTransferSlipInvalid:
+			return entities.NewResponse(c).Error(
+				fiber.StatusBadRequest,
+				string(updateOrderErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.StatusInternalServerError,
+				string(updateOrderErr),
+				err.Error(),
+			).Res()
+		}
 	}
 
 	return entities.NewResponse(c).Success(fiber.StatusOK, order).Res()
@@ -65,8 +65,9 @@ func (b *findOrderBuilder) initQuery() {
 						WHERE spo.order_id = o.id) AS pt) AS products,
 				 o.address,
 				 o.contact,
+				 o.shipping_address,
 				 o.status,
-				 (SELECT SUM(COALESCE((po.product ->> 'price')::FLOAT * (po.qty)::FLOAT, 0))
+				 (SELECT SUM(COALESCE((COALESCE(po.product ->> 'effective_price', po.product ->> 'price'))::FLOAT * (po.qty)::FLOAT, 0))
 				  FROM products_orders po
 				  WHERE po.order_id = o.id)               AS total_paid,
 				 o.created_at,
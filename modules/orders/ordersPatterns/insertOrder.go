@@ -40,8 +40,8 @@ func (b *insertOrderBuilder) insertOrder() error {
 	defer cancel()
 
 	query := `
-	INSERT INTO orders (user_id, address, contact, transfer_slip, status)
-	VALUES ($1, $2, $3, $4, $5)
+	INSERT INTO orders (user_id, address, contact, shipping_address, transfer_slip, status)
+	VALUES ($1, $2, $3, $4, $5, $6)
 	RETURNING id;`
 
 	if err := b.tx.QueryRowContext(
@@ -50,6 +50,7 @@ func (b *insertOrderBuilder) insertOrder() error {
 		b.req.UserID,
 		b.req.Address,
 		b.req.Contact,
+		b.req.ShippingAddress,
 		b.req.TransferSlip,
 		b.req.Status,
 	).Scan(&b.req.ID); err != nil {
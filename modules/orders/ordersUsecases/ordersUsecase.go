@@ -1,42 +1,168 @@
 package ordersUsecases
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
+	"github.com/korvised/go-ecommerce/modules/addresses/addressesRepositories"
 	"github.com/korvised/go-ecommerce/modules/entities"
+	"github.com/korvised/go-ecommerce/modules/files/filesUsecases"
 	"github.com/korvised/go-ecommerce/modules/orders"
 	"github.com/korvised/go-ecommerce/modules/orders/ordersRepositories"
 	"github.com/korvised/go-ecommerce/modules/products/productsRepositories"
+	"log"
 	"math"
+	"path"
+	"strings"
+	"time"
 )
 
 type IOrdersUsecase interface {
-	FindOneOrder(orderID string) (*orders.Order, error)
+	FindOneOrder(orderID, userID string, readAny bool) (*orders.Order, error)
 	FindManyOrders(req *orders.OrderFilter) *entities.PaginateRes
 	InsertOrder(req *orders.Order) (*orders.Order, error)
 	UpdateOrder(req *orders.UpdateOrderReq) (*orders.Order, error)
 }
 
+// ErrTransferSlipInvalid is returned when the slip is not a file the user uploaded to slips
+var ErrTransferSlipInvalid = errors.New("transfer slip must be a file uploaded to " + transferSlipDirectory)
+
+// transferSlipDirectory is the files destination slips are uploaded to
+const transferSlipDirectory = "slips"
+
 type ordersUsecase struct {
-	ordersRepository   ordersRepositories.IOrdersRepository
-	productsRepository productsRepositories.IProductsRepository
+	ordersRepository    ordersRepositories.IOrdersRepository
+	productsRepository  productsRepositories.IProductsRepository
+	addressesRepository addressesRepositories.IAddressesRepository
+	filesUsecase        filesUsecases.IFilesUsecase
 }
 
 func OrdersUsecase(
 	ordersRepository ordersRepositories.IOrdersRepository,
 	productsRepository productsRepositories.IProductsRepository,
+	addressesRepository addressesRepositories.IAddressesRepository,
+	filesUsecase filesUsecases.IFilesUsecase,
 ) IOrdersUsecase {
 	return &ordersUsecase{
-		ordersRepository:   ordersRepository,
-		productsRepository: productsRepository,
+		ordersRepository:    ordersRepository,
+		productsRepository:  productsRepository,
+		addressesRepository: addressesRepository,
+		filesUsecase:        filesUsecase,
+	}
+}
+
+// shippingAddress copies the address of the order from the address book,
+// the chosen entry or else the default one. Orders with a typed address
+// and no address_id are kept as they are
+func (u *ordersUsecase) shippingAddress(req *orders.Order) error {
+	req.ShippingAddress = nil
+	if req.AddressID == "" && strings.TrimSpace(req.Address) != "" {
+		return nil
+	}
+
+	var err error
+	if req.AddressID != "" {
+		req.ShippingAddress, err = u.addressesRepository.FindOneAddress(req.UserID, req.AddressID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("address not found")
+		}
+	} else {
+		req.ShippingAddress, err = u.addressesRepository.FindDefaultAddress(req.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("address is required")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// The copy keeps what the parcel is labelled with, not the book entry
+	req.ShippingAddress.IsDefault = false
+	req.ShippingAddress.CreatedAt = ""
+	req.ShippingAddress.UpdatedAt = ""
+
+	req.Address = req.ShippingAddress.Label()
+	req.Contact = req.ShippingAddress.Contact()
+	return nil
+}
+
+// transferSlip builds the slip from the files registry entry the client
+// points at with its destination, only the destination of the request is
+// read. Files of other users or outside slips are rejected so an order
+// can not pin or sign any stored file.
+func (u *ordersUsecase) transferSlip(userID string, req *orders.TransferSlip) (*orders.TransferSlip, error) {
+	destination := path.Clean(strings.TrimSpace(req.Destination))
+	if path.Dir(destination) != transferSlipDirectory {
+		return nil, ErrTransferSlipInvalid
+	}
+
+	file, err := u.filesUsecase.FindOwnedFile(userID, destination)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransferSlipInvalid
+		}
+		return nil, err
+	}
+
+	// YYYY-MM-DD HH:MM:SS
+	// 2006-01-02 15:04:05
+	loc, _ := time.LoadLocation("Asia/Vientiane")
+
+	return &orders.TransferSlip{
+		ID:          uuid.NewString(),
+		FileName:    file.FileName,
+		Destination: file.StorageKey,
+		Url:         file.Url,
+		CreatedAt:   time.Now().In(loc).Format("2006-01-02 15:04:05"),
+	}, nil
+}
+
+// signTransferSlip replaces the stored url of a slip with a fresh one,
+// slips live in private storage so their urls expire
+func (u *ordersUsecase) signTransferSlip(order *orders.Order) {
+	if order.TransferSlip == nil || order.TransferSlip.Destination == "" {
+		return
+	}
+
+	url, err := u.filesUsecase.FileUrl(order.TransferSlip.Destination)
+	if err != nil {
+		log.Printf("sign transfer slip of order %s failed: %v", order.ID, err)
+		return
+	}
+	order.TransferSlip.Url = url
+}
+
+// findOrder returns an order of the user, orders of other users are not
+// found unless anyUser is set
+func (u *ordersUsecase) findOrder(orderID, userID string, anyUser bool) (*orders.Order, error) {
+	order, err := u.ordersRepository.FindOneOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !anyUser && order.UserID != userID {
+		return nil, sql.ErrNoRows
 	}
+	return order, nil
 }
 
-func (u *ordersUsecase) FindOneOrder(orderID string) (*orders.Order, error) {
-	return u.ordersRepository.FindOneOrder(orderID)
+func (u *ordersUsecase) FindOneOrder(orderID, userID string, readAny bool) (*orders.Order, error) {
+	// Checked before the slip is signed, its url grants the download
+	order, err := u.findOrder(orderID, userID, readAny)
+	if err != nil {
+		return nil, err
+	}
+
+	u.signTransferSlip(order)
+	return order, nil
 }
 
 func (u *ordersUsecase) FindManyOrders(req *orders.OrderFilter) *entities.PaginateRes {
 	data, count := u.ordersRepository.FindManyOrders(req)
+	for _, order := range data {
+		u.signTransferSlip(order)
+	}
 
 	return &entities.PaginateRes{
 		Page:      req.Page,
@@ -48,6 +174,18 @@ func (u *ordersUsecase) FindManyOrders(req *orders.OrderFilter) *entities.Pagina
 }
 
 func (u *ordersUsecase) InsertOrder(req *orders.Order) (*orders.Order, error) {
+	if err := u.shippingAddress(req); err != nil {
+		return nil, err
+	}
+
+	if req.TransferSlip != nil {
+		slip, err := u.transferSlip(req.UserID, req.TransferSlip)
+		if err != nil {
+			return nil, err
+		}
+		req.TransferSlip = slip
+	}
+
 	// Check if product is exits
 	for i, pro := range req.Products {
 		if pro.Product == nil {
@@ -59,8 +197,12 @@ func (u *ordersUsecase) InsertOrder(req *orders.Order) (*orders.Order, error) {
 			return nil, err
 		}
 
-		// Summary price
-		req.TotalPaid += pro.Product.Price * float64(pro.Qty)
+		if !product.IsLive {
+			return nil, fmt.Errorf("product %s is not available", product.ID)
+		}
+
+		// Summary price, sale price is applied while the sale is running
+		req.TotalPaid += product.EffectivePrice * float64(pro.Qty)
 		req.Products[i].Product = product
 	}
 
@@ -69,7 +211,7 @@ func (u *ordersUsecase) InsertOrder(req *orders.Order) (*orders.Order, error) {
 		return nil, err
 	}
 
-	order, err := u.ordersRepository.FindOneOrder(orderID)
+	order, err := u.FindOneOrder(orderID, req.UserID, false)
 	if err != nil {
 		return nil, err
 	}
@@ -77,12 +219,26 @@ func (u *ordersUsecase) InsertOrder(req *orders.Order) (*orders.Order, error) {
 	return order, nil
 }
 
+// UpdateOrder changes an order of the user, orders of other users are not
+// found unless the request has WriteAny
 func (u *ordersUsecase) UpdateOrder(req *orders.UpdateOrderReq) (*orders.Order, error) {
+	if _, err := u.findOrder(req.ID, req.UserID, req.WriteAny); err != nil {
+		return nil, err
+	}
+
+	if req.TransferSlip != nil {
+		slip, err := u.transferSlip(req.UserID, req.TransferSlip)
+		if err != nil {
+			return nil, err
+		}
+		req.TransferSlip = slip
+	}
+
 	if err := u.ordersRepository.UpdateOrder(req); err != nil {
 		return nil, err
 	}
 
-	order, err := u.FindOneOrder(req.ID)
+	order, err := u.FindOneOrder(req.ID, req.UserID, req.WriteAny)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,149 @@
+package ordersUsecases
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/korvised/go-ecommerce/modules/files"
+	"github.com/korvised/go-ecommerce/modules/files/filesUsecases"
+	"github.com/korvised/go-ecommerce/modules/orders"
+	"github.com/korvised/go-ecommerce/modules/orders/ordersRepositories"
+	"testing"
+)
+
+type testOrdersRepository struct {
+	ordersRepositories.IOrdersRepository
+	orders  map[string]*orders.Order
+	updated *orders.UpdateOrderReq
+}
+
+func (r *testOrdersRepository) FindOneOrder(orderID string) (*orders.Order, error) {
+	order, ok := r.orders[orderID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	copied := *order
+	if order.TransferSlip != nil {
+		slip := *order.TransferSlip
+		copied.TransferSlip = &slip
+	}
+	return &copied, nil
+}
+
+func (r *testOrdersRepository) UpdateOrder(req *orders.UpdateOrderReq) error {
+	r.updated = req
+	return nil
+}
+
+// testFilesUsecase signs every destination and knows the files of user-1
+type testFilesUsecase struct {
+	filesUsecases.IFilesUsecase
+	signed []string
+}
+
+func (u *testFilesUsecase) FileUrl(destination string) (string, error) {
+	u.signed = append(u.signed, destination)
+	return "signed:" + destination, nil
+}
+
+func (u *testFilesUsecase) FindOwnedFile(ownerID, destination string) (*files.File, error) {
+	owned := map[string]bool{"slips/a.png": true, "products/a.png": true}
+	if ownerID != "user-1" || !owned[destination] {
+		return nil, sql.ErrNoRows
+	}
+	return &files.File{StorageKey: destination, FileName: "a.png", Url: destination}, nil
+}
+
+func newTestUsecase() (*ordersUsecase, *testOrdersRepository, *testFilesUsecase) {
+	repo := &testOrdersRepository{orders: map[string]*orders.Order{
+		"O000001": {ID: "O000001", UserID: "user-1", TransferSlip: &orders.TransferSlip{Destination: "slips/a.png"}},
+	}}
+	filesUsecase := &testFilesUsecase{}
+
+	return &ordersUsecase{ordersRepository: repo, filesUsecase: filesUsecase}, repo, filesUsecase
+}
+
+type testFindOneOrder struct {
+	name    string
+	userID  string
+	readAny bool
+	expect  error
+}
+
+func TestFindOneOrder(t *testing.T) {
+	tests := []testFindOneOrder{
+		{name: "owner", userID: "user-1", expect: nil},
+		{name: "other user", userID: "user-2", expect: sql.ErrNoRows},
+		{name: "other user with read any", userID: "user-2", readAny: true, expect: nil},
+	}
+
+	for _, test := range tests {
+		usecase, _, filesUsecase := newTestUsecase()
+
+		order, err := usecase.FindOneOrder("O000001", test.userID, test.readAny)
+		if !errors.Is(err, test.expect) {
+			t.Errorf("%s: expect: %v, got: %v", test.name, test.expect, err)
+			continue
+		}
+
+		if test.expect != nil {
+			if len(filesUsecase.signed) != 0 {
+				t.Errorf("%s: expect: %s, got: %v", test.name, "nothing signed", filesUsecase.signed)
+			}
+			continue
+		}
+		if order.TransferSlip.Url != "signed:slips/a.png" {
+			t.Errorf("%s: expect: %s, got: %s", test.name, "signed:slips/a.png", order.TransferSlip.Url)
+		}
+	}
+}
+
+type testUpdateOrderSlip struct {
+	name        string
+	userID      string
+	writeAny    bool
+	destination string
+	expect      error
+}
+
+func TestUpdateOrderTransferSlip(t *testing.T) {
+	tests := []testUpdateOrderSlip{
+		{name: "own slip", userID: "user-1", destination: "slips/a.png", expect: nil},
+		{name: "unclean path", userID: "user-1", destination: " slips/../slips/a.png", expect: nil},
+		{name: "own file outside slips", userID: "user-1", destination: "products/a.png", expect: ErrTransferSlipInvalid},
+		{name: "unknown file", userID: "user-1", destination: "slips/b.png", expect: ErrTransferSlipInvalid},
+		{name: "order of another user", userID: "user-2", destination: "slips/a.png", expect: sql.ErrNoRows},
+		{name: "slip of another user", userID: "user-2", writeAny: true, destination: "slips/a.png", expect: ErrTransferSlipInvalid},
+	}
+
+	for _, test := range tests {
+		usecase, repo, _ := newTestUsecase()
+
+		_, err := usecase.UpdateOrder(&orders.UpdateOrderReq{
+			ID:       "O000001",
+			UserID:   test.userID,
+			WriteAny: test.writeAny,
+			TransferSlip: &orders.TransferSlip{
+				FileName:    "forged.png",
+				Destination: test.destination,
+				Url:         "https://attacker.example.com/a.png",
+			},
+		})
+		if !errors.Is(err, test.expect) {
+			t.Errorf("%s: expect: %v, got: %v", test.name, test.expect, err)
+			continue
+		}
+
+		if test.expect != nil {
+			if repo.updated != nil {
+				t.Errorf("%s: expect: %s, got: %+v", test.name, "no update", repo.updated)
+			}
+			continue
+		}
+
+		slip := repo.updated.TransferSlip
+		if slip.Destination != "slips/a.png" || slip.Url != "slips/a.png" || slip.FileName != "a.png" || slip.ID == "" {
+			t.Errorf("%s: expect: %s, got: %+v", test.name, "the slip of the registry", slip)
+		}
+	}
+}
@@ -1,24 +1,291 @@
 package products
 
 import (
+	"errors"
+	"fmt"
 	"github.com/korvised/go-ecommerce/modules/appinfo"
 	"github.com/korvised/go-ecommerce/modules/entities"
+	"net/url"
+	"path"
+	"strings"
+	"time"
 )
 
 type Product struct {
-	ID          string            `json:"id"`
-	Title       string            `json:"title"`
-	Description string            `json:"description"`
-	Price       float64           `json:"price"`
-	Category    *appinfo.Category `json:"category"`
-	Images      []*entities.Image `json:"images"`
-	CreatedAt   string            `json:"created_at"`
-	UpdatedAt   string            `json:"updated_at"`
+	ID             string            `json:"id"`
+	Title          string            `json:"title"`
+	Description    string            `json:"description"`
+	Price          float64           `json:"price"`
+	SalePrice      *float64          `json:"sale_price"`     // 0 on update removes the sale
+	SaleStartsAt   *string           `json:"sale_starts_at"` // YYYY-MM-DD HH:MM:SS
+	SaleEndsAt     *string           `json:"sale_ends_at"`   // YYYY-MM-DD HH:MM:SS
+	EffectivePrice float64           `json:"effective_price"`
+	OnSale         bool              `json:"on_sale"`
+	Stock          *int              `json:"stock"` // nil on update keeps the stock, 0 sells out
+	Status         string            `json:"status"`
+	PublishAt      *string           `json:"publish_at"`   // YYYY-MM-DD HH:MM:SS
+	UnpublishAt    *string           `json:"unpublish_at"` // YYYY-MM-DD HH:MM:SS
+	IsLive         bool              `json:"is_live"`
+	Category       *appinfo.Category `json:"category"`
+	Images         []*entities.Image `json:"images"`
+	CreatedAt      string            `json:"created_at"`
+	UpdatedAt      string            `json:"updated_at"`
+	DeletedAt      *string           `json:"deleted_at,omitempty"`
 }
 
+// ErrSalePriceNotLower is returned when a sale would not lower the price
+var ErrSalePriceNotLower = errors.New("sale_price must be less than price")
+
 type ProductFilter struct {
-	ID     string `query:"id"`
-	Search string `query:"search"`
+	ID             string `query:"id"`
+	Search         string `query:"search"`
+	Status         string `query:"status"`          // admin only, draft | published | archived | scheduled | live
+	IncludeDeleted bool   `query:"include_deleted"` // admin only
+	LiveOnly       bool   `query:"-"`
 	*entities.PaginationReq
 	*entities.SortReq
 }
+
+const (
+	StatusDraft     = "draft"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+
+	// Filter only statuses
+	StatusScheduled = "scheduled"
+	StatusLive      = "live"
+
+	PublishTimeLayout = "2006-01-02 15:04:05"
+)
+
+type PriceHistory struct {
+	ID           string   `db:"id" json:"id"`
+	ProductID    string   `db:"product_id" json:"product_id"`
+	Price        float64  `db:"price" json:"price"`
+	SalePrice    *float64 `db:"sale_price" json:"sale_price"`
+	SaleStartsAt *string  `db:"sale_starts_at" json:"sale_starts_at"`
+	SaleEndsAt   *string  `db:"sale_ends_at" json:"sale_ends_at"`
+	CreatedAt    string   `db:"created_at" json:"created_at"`
+}
+
+type UpdateImageReq struct {
+	ID        string  `json:"-"`
+	AltText   *string `json:"alt_text" form:"alt_text"`
+	IsPrimary bool    `json:"is_primary" form:"is_primary"` // true sets the image as cover
+}
+
+type ReorderImagesReq struct {
+	ImageIDs []string `json:"image_ids" form:"image_ids"` // every image of the product in the new order
+}
+
+const (
+	ImportFormatCSV  = "csv"
+	ImportFormatJSON = "json"
+
+	ImportStatusPending    = "pending"
+	ImportStatusProcessing = "processing"
+	ImportStatusCompleted  = "completed"
+	ImportStatusFailed     = "failed"
+
+	ImportRowSuccess = "success"
+	ImportRowFailed  = "failed"
+)
+
+type ProductImport struct {
+	ID          string                    `db:"id" json:"id"`
+	UserID      string                    `db:"user_id" json:"user_id"`
+	FileName    string                    `db:"filename" json:"filename"`
+	Format      string                    `db:"format" json:"format"`
+	Status      string                    `db:"status" json:"status"`
+	TotalRows   int                       `db:"total_rows" json:"total_rows"`
+	SuccessRows int                       `db:"success_rows" json:"success_rows"`
+	FailedRows  int                       `db:"failed_rows" json:"failed_rows"`
+	Error       string                    `db:"error" json:"error,omitempty"` // why the import stopped early
+	Report      []*ProductImportRowReport `db:"-" json:"report,omitempty"`
+	CreatedAt   string                    `db:"created_at" json:"created_at"`
+	UpdatedAt   string                    `db:"updated_at" json:"updated_at"`
+}
+
+type ProductImportRow struct {
+	Row         int      `json:"-"`
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Price       float64  `json:"price"`
+	CategoryID  int      `json:"category_id"`
+	Images      []string `json:"images"`
+	Stock       *int     `json:"stock"` // nil keeps the stock of an existing product
+	Status      string   `json:"status"`
+	ParseErr    string   `json:"-"`
+}
+
+type ProductImportRowReport struct {
+	Row       int    `json:"row"`
+	ProductID string `json:"product_id"`
+	Title     string `json:"title"`
+	Status    string `json:"status"`
+	Error     string `json:"error"`
+}
+
+func (obj *ProductImportRow) Validate() error {
+	if obj.ParseErr != "" {
+		return fmt.Errorf("%s", obj.ParseErr)
+	}
+
+	if strings.TrimSpace(obj.Title) == "" {
+		return fmt.Errorf("title is required")
+	}
+
+	if obj.Price < 0 {
+		return fmt.Errorf("price must not be negative")
+	}
+
+	if obj.Stock != nil && *obj.Stock < 0 {
+		return fmt.Errorf("stock must not be negative")
+	}
+
+	if obj.Status != "" && !IsProductStatus(obj.Status) {
+		return fmt.Errorf("status must be draft, published or archived")
+	}
+
+	// Category is required for new products only
+	if obj.ID == "" && obj.CategoryID < 1 {
+		return fmt.Errorf("category_id is required")
+	}
+
+	for _, img := range obj.Images {
+		u, err := url.ParseRequestURI(img)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("image url \"%s\" is invalid", img)
+		}
+	}
+
+	return nil
+}
+
+func (obj *ProductImportRow) ToProduct() *Product {
+	product := &Product{
+		ID:          strings.TrimSpace(obj.ID),
+		Title:       strings.TrimSpace(obj.Title),
+		Description: obj.Description,
+		Price:       obj.Price,
+		Stock:       obj.Stock,
+		Status:      strings.ToLower(obj.Status),
+		Images:      make([]*entities.Image, 0),
+	}
+
+	if obj.CategoryID > 0 {
+		product.Category = &appinfo.Category{ID: obj.CategoryID}
+	}
+
+	for _, img := range obj.Images {
+		u, _ := url.Parse(img)
+		product.Images = append(product.Images, &entities.Image{
+			FileName: path.Base(u.Path),
+			Url:      img,
+		})
+	}
+
+	return product
+}
+
+func IsProductStatus(status string) bool {
+	switch strings.ToLower(status) {
+	case StatusDraft, StatusPublished, StatusArchived:
+		return true
+	default:
+		return false
+	}
+}
+
+// ValidatePublication checks status and schedule fields, empty values are
+// treated as not set.
+func (obj *Product) ValidatePublication() error {
+	obj.Status = strings.ToLower(strings.TrimSpace(obj.Status))
+	if obj.Status != "" && !IsProductStatus(obj.Status) {
+		return fmt.Errorf("status must be draft, published or archived")
+	}
+
+	var publishAt, unpublishAt time.Time
+	var err error
+
+	if obj.PublishAt != nil && *obj.PublishAt != "" {
+		if publishAt, err = time.Parse(PublishTimeLayout, *obj.PublishAt); err != nil {
+			return fmt.Errorf("publish_at must be in format YYYY-MM-DD HH:MM:SS")
+		}
+	}
+
+	if obj.UnpublishAt != nil && *obj.UnpublishAt != "" {
+		if unpublishAt, err = time.Parse(PublishTimeLayout, *obj.UnpublishAt); err != nil {
+			return fmt.Errorf("unpublish_at must be in format YYYY-MM-DD HH:MM:SS")
+		}
+	}
+
+	if !publishAt.IsZero() && !unpublishAt.IsZero() && !unpublishAt.After(publishAt) {
+		return fmt.Errorf("unpublish_at must be after publish_at")
+	}
+
+	return nil
+}
+
+// ValidateSale checks the sale price and its schedule, empty values are
+// treated as not set.
+func (obj *Product) ValidateSale() error {
+	if obj.Price < 0 {
+		return fmt.Errorf("price must not be negative")
+	}
+
+	if obj.SalePrice != nil {
+		if *obj.SalePrice < 0 {
+			return fmt.Errorf("sale_price must not be negative")
+		}
+
+		// A partial update is checked again against the stored price
+		if obj.Price > 0 && *obj.SalePrice >= obj.Price {
+			return ErrSalePriceNotLower
+		}
+	}
+
+	var startsAt, endsAt time.Time
+	var err error
+
+	if obj.SaleStartsAt != nil && *obj.SaleStartsAt != "" {
+		if startsAt, err = time.Parse(PublishTimeLayout, *obj.SaleStartsAt); err != nil {
+			return fmt.Errorf("sale_starts_at must be in format YYYY-MM-DD HH:MM:SS")
+		}
+	}
+
+	if obj.SaleEndsAt != nil && *obj.SaleEndsAt != "" {
+		if endsAt, err = time.Parse(PublishTimeLayout, *obj.SaleEndsAt); err != nil {
+			return fmt.Errorf("sale_ends_at must be in format YYYY-MM-DD HH:MM:SS")
+		}
+	}
+
+	if !startsAt.IsZero() && !endsAt.IsZero() && !endsAt.After(startsAt) {
+		return fmt.Errorf("sale_ends_at must be after sale_starts_at")
+	}
+
+	return nil
+}
+
+// ArrangeImages sets the position of each image from its index and makes
+// sure exactly one image is the primary, the first one by default.
+func (obj *Product) ArrangeImages() {
+	primary := -1
+	for i, img := range obj.Images {
+		img.Position = i
+		if img.IsPrimary && primary == -1 {
+			primary = i
+		}
+		img.IsPrimary = false
+	}
+
+	if len(obj.Images) == 0 {
+		return
+	}
+
+	if primary == -1 {
+		primary = 0
+	}
+	obj.Images[primary].IsPrimary = true
+}
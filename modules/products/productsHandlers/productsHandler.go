@@ -1,17 +1,22 @@
 package productsHandlers
 
 import (
+	"bytes"
 	"database/sql"
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/korvised/go-ecommerce/config"
 	"github.com/korvised/go-ecommerce/modules/appinfo"
 	"github.com/korvised/go-ecommerce/modules/entities"
-	"github.com/korvised/go-ecommerce/modules/files"
 	"github.com/korvised/go-ecommerce/modules/files/filesUsecases"
+	"github.com/korvised/go-ecommerce/modules/middlewares"
+	"github.com/korvised/go-ecommerce/modules/middlewares/middlewaresHandlers"
 	"github.com/korvised/go-ecommerce/modules/products"
 	"github.com/korvised/go-ecommerce/modules/products/productsUsecases"
-	"log"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -23,6 +28,14 @@ const (
 	addProductErr      productsHandlersErrCode = "products-003"
 	updateProductErr   productsHandlersErrCode = "products-004"
 	deleteProductErr   productsHandlersErrCode = "products-005"
+	importProductsErr  productsHandlersErrCode = "products-006"
+	findImportErr      productsHandlersErrCode = "products-007"
+	restoreProductErr  productsHandlersErrCode = "products-008"
+	priceHistoryErr    productsHandlersErrCode = "products-009"
+	addImageErr        productsHandlersErrCode = "products-010"
+	updateImageErr     productsHandlersErrCode = "products-011"
+	removeImageErr     productsHandlersErrCode = "products-012"
+	reorderImagesErr   productsHandlersErrCode = "products-013"
 )
 
 type IProductsHandler interface {
@@ -31,6 +44,15 @@ type IProductsHandler interface {
 	AddProduct(c *fiber.Ctx) error
 	UpdateProduct(c *fiber.Ctx) error
 	DeleteProduct(c *fiber.Ctx) error
+	RestoreProduct(c *fiber.Ctx) error
+	FindPriceHistory(c *fiber.Ctx) error
+	AddImage(c *fiber.Ctx) error
+	UpdateImage(c *fiber.Ctx) error
+	RemoveImage(c *fiber.Ctx) error
+	ReorderImages(c *fiber.Ctx) error
+	ImportProducts(c *fiber.Ctx) error
+	FindOneProductImport(c *fiber.Ctx) error
+	DownloadProductImportReport(c *fiber.Ctx) error
 }
 
 type productsHandler struct {
@@ -51,10 +73,18 @@ func ProductsHandler(
 	}
 }
 
+// canReadAny reports whether drafts and deleted products are visible to the caller
+func canReadAny(c *fiber.Ctx) bool {
+	return middlewaresHandlers.HasPermission(c, middlewares.PermProductsReadAny)
+}
+
 func (h *productsHandler) FindOneProduct(c *fiber.Ctx) error {
 	productID := strings.Trim(c.Params("product_id"), "")
 
 	product, err := h.productsUsecase.FindOneProduct(productID)
+	if err == nil && !product.IsLive && !canReadAny(c) {
+		err = sql.ErrNoRows
+	}
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -86,6 +116,14 @@ func (h *productsHandler) FindManyProducts(c *fiber.Ctx) error {
 		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(findManyProductErr), err.Error()).Res()
 	}
 
+	// Only admin can see deleted and unpublished products
+	req.Status = strings.ToLower(req.Status)
+	if !canReadAny(c) {
+		req.IncludeDeleted = false
+		req.Status = ""
+		req.LiveOnly = true
+	}
+
 	if req.Page < 1 {
 		req.Page = 1
 	}
@@ -115,6 +153,14 @@ func (h *productsHandler) AddProduct(c *fiber.Ctx) error {
 		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(addProductErr), err.Error()).Res()
 	}
 
+	if err := req.ValidatePublication(); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(addProductErr), err.Error()).Res()
+	}
+
+	if err := req.ValidateSale(); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(addProductErr), err.Error()).Res()
+	}
+
 	product, err := h.productsUsecase.AddProduct(req)
 	if err != nil {
 		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(addProductErr), err.Error()).Res()
@@ -135,8 +181,19 @@ func (h *productsHandler) UpdateProduct(c *fiber.Ctx) error {
 	}
 	req.ID = productID
 
+	if err := req.ValidatePublication(); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateProductErr), err.Error()).Res()
+	}
+
+	if err := req.ValidateSale(); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateProductErr), err.Error()).Res()
+	}
+
 	product, err := h.productsUsecase.UpdateProduct(req)
 	if err != nil {
+		if errors.Is(err, products.ErrSalePriceNotLower) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateProductErr), err.Error()).Res()
+		}
 		return entities.NewResponse(c).Error(
 			fiber.StatusInternalServerError,
 			string(updateProductErr),
@@ -150,45 +207,235 @@ func (h *productsHandler) UpdateProduct(c *fiber.Ctx) error {
 func (h *productsHandler) DeleteProduct(c *fiber.Ctx) error {
 	productID := strings.Trim(c.Params("product_id"), " ")
 
-	product, err := h.productsUsecase.FindOneProduct(productID)
-	if err != nil {
+	// Soft delete, images are removed when the product is purged
+	if err := h.productsUsecase.DeleteProduct(productID); err != nil {
 		switch err {
 		case sql.ErrNoRows:
 			return entities.NewResponse(c).Error(
 				fiber.StatusBadRequest,
-				string(findOneProductErr),
+				string(deleteProductErr),
 				"product not found",
 			).Res()
 		default:
 			return entities.NewResponse(c).Error(
 				fiber.StatusInternalServerError,
-				string(findOneProductErr),
+				string(deleteProductErr),
+				err.Error(),
+			).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *productsHandler) RestoreProduct(c *fiber.Ctx) error {
+	productID := strings.Trim(c.Params("product_id"), " ")
+
+	product, err := h.productsUsecase.RestoreProduct(productID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return entities.NewResponse(c).Error(
+				fiber.StatusBadRequest,
+				string(restoreProductErr),
+				"deleted product not found",
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.StatusInternalServerError,
+				string(restoreProductErr),
 				err.Error(),
 			).Res()
 		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, product).Res()
+}
+
+func (h *productsHandler) FindPriceHistory(c *fiber.Ctx) error {
+	productID := strings.Trim(c.Params("product_id"), " ")
+
+	history, err := h.productsUsecase.FindPriceHistory(productID)
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(priceHistoryErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, history).Res()
+}
+
+func (h *productsHandler) AddImage(c *fiber.Ctx) error {
+	productID := strings.Trim(c.Params("product_id"), " ")
 
+	req := new(entities.Image)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(addImageErr), err.Error()).Res()
 	}
 
-	// Delete images
-	deleteFileReq := make([]*files.DeleteFileReq, 0)
-	for _, img := range product.Images {
-		deleteFileReq = append(deleteFileReq, &files.DeleteFileReq{
-			Destination: fmt.Sprintf("products/%s", img.FileName),
-		})
+	if req.Url == "" {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(addImageErr), "url is required").Res()
 	}
 
-	if err = h.filesUsecase.DeleteFileOnStorage(deleteFileReq); err != nil {
-		log.Printf("delete image failed: %v", err)
+	if req.FileName == "" {
+		req.FileName = filepath.Base(req.Url)
 	}
 
-	// Delete product
-	if err := h.productsUsecase.DeleteProduct(productID); err != nil {
+	image, err := h.productsUsecase.AddImage(productID, req)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(addImageErr), "product not found").Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(addImageErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusCreated, image).Res()
+}
+
+func (h *productsHandler) UpdateImage(c *fiber.Ctx) error {
+	productID := strings.Trim(c.Params("product_id"), " ")
+
+	req := new(products.UpdateImageReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateImageErr), err.Error()).Res()
+	}
+	req.ID = strings.Trim(c.Params("image_id"), " ")
+
+	images, err := h.productsUsecase.UpdateImage(productID, req)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateImageErr), "image not found").Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(updateImageErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, images).Res()
+}
+
+func (h *productsHandler) RemoveImage(c *fiber.Ctx) error {
+	productID := strings.Trim(c.Params("product_id"), " ")
+	imageID := strings.Trim(c.Params("image_id"), " ")
+
+	images, err := h.productsUsecase.RemoveImage(productID, imageID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(removeImageErr), "image not found").Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(removeImageErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, images).Res()
+}
+
+func (h *productsHandler) ReorderImages(c *fiber.Ctx) error {
+	productID := strings.Trim(c.Params("product_id"), " ")
+
+	req := new(products.ReorderImagesReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(reorderImagesErr), err.Error()).Res()
+	}
+
+	if len(req.ImageIDs) == 0 {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(reorderImagesErr), "image_ids are empty").Res()
+	}
+
+	images, err := h.productsUsecase.ReorderImages(productID, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(reorderImagesErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, images).Res()
+}
+
+func (h *productsHandler) ImportProducts(c *fiber.Ctx) error {
+	userID := c.Locals(middlewaresHandlers.UserID).(string)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(importProductsErr), "file is required").Res()
+	}
+
+	// Format from form value, fallback to file extension
+	format := strings.ToLower(c.FormValue("format"))
+	if format == "" {
+		format = strings.ToLower(strings.TrimPrefix(filepath.Ext(file.Filename), "."))
+	}
+
+	if format != products.ImportFormatCSV && format != products.ImportFormatJSON {
 		return entities.NewResponse(c).Error(
-			fiber.StatusInternalServerError,
-			string(deleteProductErr),
-			err.Error(),
+			fiber.StatusBadRequest,
+			string(importProductsErr),
+			"import format must be csv or json",
 		).Res()
 	}
 
-	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+	container, err := file.Open()
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(importProductsErr), err.Error()).Res()
+	}
+	defer container.Close()
+
+	req := &products.ProductImport{
+		UserID:   userID,
+		FileName: file.Filename,
+		Format:   format,
+	}
+
+	result, err := h.productsUsecase.ImportProducts(req, container)
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(importProductsErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusAccepted, result).Res()
+}
+
+func (h *productsHandler) FindOneProductImport(c *fiber.Ctx) error {
+	importID := strings.Trim(c.Params("import_id"), " ")
+
+	result, err := h.productsUsecase.FindOneProductImport(importID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(findImportErr), "import not found").Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(findImportErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+func (h *productsHandler) DownloadProductImportReport(c *fiber.Ctx) error {
+	importID := strings.Trim(c.Params("import_id"), " ")
+
+	result, err := h.productsUsecase.FindOneProductImport(importID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(findImportErr), "import not found").Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(findImportErr), err.Error()).Res()
+		}
+	}
+
+	if strings.ToLower(c.Query("format")) == products.ImportFormatJSON {
+		c.Attachment(fmt.Sprintf("import_%s_report.json", result.ID))
+		return c.Status(fiber.StatusOK).JSON(result.Report)
+	}
+
+	buf := new(bytes.Buffer)
+	writer := csv.NewWriter(buf)
+	_ = writer.Write([]string{"row", "product_id", "title", "status", "error"})
+	for _, r := range result.Report {
+		_ = writer.Write([]string{strconv.Itoa(r.Row), r.ProductID, r.Title, r.Status, r.Error})
+	}
+	writer.Flush()
+
+	c.Attachment(fmt.Sprintf("import_%s_report.csv", result.ID))
+	c.Set(fiber.HeaderContentType, "text/csv")
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
 }
@@ -14,6 +14,20 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+// Published and inside the publish_at/unpublish_at window
+const liveCondition = `(
+				"p"."status" = 'published' AND
+				("p"."publish_at" IS NULL OR "p"."publish_at" <= now()) AND
+				("p"."unpublish_at" IS NULL OR "p"."unpublish_at" > now())
+			)`
+
+// Has a sale price and inside the sale_starts_at/sale_ends_at window
+const onSaleCondition = `(
+				"p"."sale_price" IS NOT NULL AND
+				("p"."sale_starts_at" IS NULL OR "p"."sale_starts_at" <= now()) AND
+				("p"."sale_ends_at" IS NULL OR "p"."sale_ends_at" > now())
+			)`
+
 type IFindProductBuilder interface {
 	openJsonQuery()
 	initQuery()
@@ -56,6 +70,16 @@ func (b *findProductBuilder) initQuery() {
 			"p"."title",
 			"p"."description",
 			"p"."price",
+			"p"."sale_price",
+			"p"."sale_starts_at",
+			"p"."sale_ends_at",
+			` + onSaleCondition + ` AS "on_sale",
+			(CASE WHEN ` + onSaleCondition + ` THEN "p"."sale_price" ELSE "p"."price" END) AS "effective_price",
+			"p"."stock",
+			"p"."status",
+			"p"."publish_at",
+			"p"."unpublish_at",
+			` + liveCondition + ` AS "is_live",
 			(
 				SELECT
 					to_jsonb("ct")
@@ -70,14 +94,19 @@ func (b *findProductBuilder) initQuery() {
 			) AS "category",
 			"p"."created_at",
 			"p"."updated_at",
+			"p"."deleted_at",
 			(
 				SELECT
-					COALESCE(array_to_json(array_agg("it")), '[]'::json)
+					COALESCE(array_to_json(array_agg("it" ORDER BY "it"."position")), '[]'::json)
 				FROM (
 					SELECT
 						"i"."id",
 						"i"."filename",
-						"i"."url"
+						"i"."url",
+						"i"."position",
+						"i"."is_primary",
+						"i"."alt_text",
+						"i"."renditions"
 					FROM "images" "i"
 					WHERE "i"."product_id" = "p"."id"
 				) AS "it"
@@ -104,6 +133,15 @@ func (b *findProductBuilder) whereQuery() {
 		AND "p"."id" = ?`)
 	}
 
+	// Status check
+	switch b.req.Status {
+	case products.StatusDraft, products.StatusPublished, products.StatusArchived:
+		b.values = append(b.values, b.req.Status)
+
+		queryWhereStack = append(queryWhereStack, `
+		AND "p"."status" = ?`)
+	}
+
 	// Search check
 	if b.req.Search != "" {
 		b.values = append(
@@ -127,6 +165,21 @@ func (b *findProductBuilder) whereQuery() {
 	// Last stack record
 	b.lastStackIndex = len(b.values)
 
+	// Publication check
+	if b.req.LiveOnly || b.req.Status == products.StatusLive {
+		queryWhere += `
+		AND ` + liveCondition
+	} else if b.req.Status == products.StatusScheduled {
+		queryWhere += `
+		AND "p"."status" = 'published' AND "p"."publish_at" > now()`
+	}
+
+	// Soft deleted check
+	if !b.req.IncludeDeleted {
+		queryWhere += `
+		AND "p"."deleted_at" IS NULL`
+	}
+
 	// Summary query
 	b.query += queryWhere
 }
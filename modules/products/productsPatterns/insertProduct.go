@@ -13,6 +13,7 @@ type IInsertProductBuilder interface {
 	insertProduct() error
 	insertCategory() error
 	insertAttachment() error
+	insertPriceHistory() error
 	commit() error
 	getProductId() string
 }
@@ -51,9 +52,27 @@ func (b *insertProductBuilder) insertProduct() error {
 	INSERT INTO "products" (
 		"title",
 		"description",
-		"price"
+		"price",
+		"sale_price",
+		"sale_starts_at",
+		"sale_ends_at",
+		"stock",
+		"status",
+		"publish_at",
+		"unpublish_at"
+	)
+	VALUES (
+		$1,
+		$2,
+		$3,
+		NULLIF($4::FLOAT, 0),
+		NULLIF($5, '')::TIMESTAMP,
+		NULLIF($6, '')::TIMESTAMP,
+		COALESCE($7::INT, 0),
+		$8,
+		NULLIF($9, '')::TIMESTAMP,
+		NULLIF($10, '')::TIMESTAMP
 	)
-	VALUES ($1, $2, $3)
 		RETURNING "id";`
 
 	if err := b.tx.QueryRowContext(
@@ -62,6 +81,13 @@ func (b *insertProductBuilder) insertProduct() error {
 		b.req.Title,
 		b.req.Description,
 		b.req.Price,
+		b.req.SalePrice,
+		b.req.SaleStartsAt,
+		b.req.SaleEndsAt,
+		b.req.Stock,
+		b.req.Status,
+		b.req.PublishAt,
+		b.req.UnpublishAt,
 	).Scan(&b.req.ID); err != nil {
 		b.tx.Rollback()
 		return fmt.Errorf("insert product failed: %v", err)
@@ -95,6 +121,11 @@ func (b *insertProductBuilder) insertCategory() error {
 }
 
 func (b *insertProductBuilder) insertAttachment() error {
+	if len(b.req.Images) == 0 {
+		return nil
+	}
+	b.req.ArrangeImages()
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
 	defer cancel()
 
@@ -102,23 +133,27 @@ func (b *insertProductBuilder) insertAttachment() error {
 	INSERT INTO "images" (
 		"filename",
 		"url",
-		"product_id"
+		"product_id",
+		"position",
+		"is_primary",
+		"alt_text",
+		"renditions"
 	)
 	VALUES`
 
 	valueStack := make([]any, 0)
 	var index int
 	for i, image := range b.req.Images {
-		valueStack = append(valueStack, image.FileName, image.Url, b.req.ID)
+		valueStack = append(valueStack, image.FileName, image.Url, b.req.ID, image.Position, image.IsPrimary, image.AltText, image.Renditions)
 
 		if i != len(b.req.Images)-1 {
 			query += fmt.Sprintf(`
-			( $%d, $%d, $%d )`, index+1, index+2, index+3)
+			( $%d, $%d, $%d, $%d, $%d, $%d, $%d ),`, index+1, index+2, index+3, index+4, index+5, index+6, index+7)
 		} else {
 			query += fmt.Sprintf(`
-			( $%d, $%d, $%d );`, index+1, index+2, index+3)
+			( $%d, $%d, $%d, $%d, $%d, $%d, $%d );`, index+1, index+2, index+3, index+4, index+5, index+6, index+7)
 		}
-		index += 3
+		index += 7
 	}
 
 	if _, err := b.tx.ExecContext(
@@ -133,6 +168,35 @@ func (b *insertProductBuilder) insertAttachment() error {
 	return nil
 }
 
+func (b *insertProductBuilder) insertPriceHistory() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	query := `
+	INSERT INTO "products_price_history" (
+		"product_id",
+		"price",
+		"sale_price",
+		"sale_starts_at",
+		"sale_ends_at"
+	)
+	SELECT
+		"id",
+		"price",
+		"sale_price",
+		"sale_starts_at",
+		"sale_ends_at"
+	FROM "products"
+	WHERE "id" = $1;`
+
+	if _, err := b.tx.ExecContext(ctx, query, b.req.ID); err != nil {
+		b.tx.Rollback()
+		return fmt.Errorf("insert price history failed: %v", err)
+	}
+
+	return nil
+}
+
 func (b *insertProductBuilder) commit() error {
 	if err := b.tx.Commit(); err != nil {
 		return err
@@ -166,6 +230,10 @@ func (en *insertProductEngineer) InsertProduct() (string, error) {
 		return "", err
 	}
 
+	if err := en.builder.insertPriceHistory(); err != nil {
+		return "", err
+	}
+
 	if err := en.builder.commit(); err != nil {
 		return "", err
 	}
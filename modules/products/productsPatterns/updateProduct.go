@@ -18,8 +18,18 @@ type IUpdateProductBuilder interface {
 	updateTitleQuery()
 	updateDescriptionQuery()
 	updatePriceQuery()
+	updateSalePriceQuery()
+	updateSaleStartsAtQuery()
+	updateSaleEndsAtQuery()
+	updateStockQuery()
+	updateStatusQuery()
+	updatePublishAtQuery()
+	updateUnpublishAtQuery()
 	updateCategory() error
 	insertImages() error
+	getOldPrice() error
+	checkSalePrice() error
+	insertPriceHistory() error
 	getOldImages() []*entities.Image
 	deleteOldImages() error
 	closeQuery()
@@ -41,6 +51,7 @@ type updateProductBuilder struct {
 	queryFields    []string
 	lastStackIndex int
 	values         []any
+	oldPrice       []byte
 }
 
 func UpdateProductBuilder(
@@ -106,6 +117,78 @@ func (b *updateProductBuilder) updatePriceQuery() {
 	}
 }
 
+// A sale price of 0 removes the sale
+func (b *updateProductBuilder) updateSalePriceQuery() {
+	if b.req.SalePrice != nil {
+		b.values = append(b.values, *b.req.SalePrice)
+		b.lastStackIndex = len(b.values)
+
+		b.queryFields = append(b.queryFields, fmt.Sprintf(`
+		sale_price = NULLIF($%d::FLOAT, 0)`, b.lastStackIndex))
+	}
+}
+
+func (b *updateProductBuilder) updateSaleStartsAtQuery() {
+	if b.req.SaleStartsAt != nil {
+		b.values = append(b.values, *b.req.SaleStartsAt)
+		b.lastStackIndex = len(b.values)
+
+		b.queryFields = append(b.queryFields, fmt.Sprintf(`
+		sale_starts_at = NULLIF($%d, '')::TIMESTAMP`, b.lastStackIndex))
+	}
+}
+
+func (b *updateProductBuilder) updateSaleEndsAtQuery() {
+	if b.req.SaleEndsAt != nil {
+		b.values = append(b.values, *b.req.SaleEndsAt)
+		b.lastStackIndex = len(b.values)
+
+		b.queryFields = append(b.queryFields, fmt.Sprintf(`
+		sale_ends_at = NULLIF($%d, '')::TIMESTAMP`, b.lastStackIndex))
+	}
+}
+
+func (b *updateProductBuilder) updateStockQuery() {
+	if b.req.Stock != nil {
+		b.values = append(b.values, *b.req.Stock)
+		b.lastStackIndex = len(b.values)
+
+		b.queryFields = append(b.queryFields, fmt.Sprintf(`
+		stock = $%d`, b.lastStackIndex))
+	}
+}
+
+func (b *updateProductBuilder) updateStatusQuery() {
+	if b.req.Status != "" {
+		b.values = append(b.values, b.req.Status)
+		b.lastStackIndex = len(b.values)
+
+		b.queryFields = append(b.queryFields, fmt.Sprintf(`
+		status = $%d`, b.lastStackIndex))
+	}
+}
+
+// An empty string clears the schedule
+func (b *updateProductBuilder) updatePublishAtQuery() {
+	if b.req.PublishAt != nil {
+		b.values = append(b.values, *b.req.PublishAt)
+		b.lastStackIndex = len(b.values)
+
+		b.queryFields = append(b.queryFields, fmt.Sprintf(`
+		publish_at = NULLIF($%d, '')::TIMESTAMP`, b.lastStackIndex))
+	}
+}
+
+func (b *updateProductBuilder) updateUnpublishAtQuery() {
+	if b.req.UnpublishAt != nil {
+		b.values = append(b.values, *b.req.UnpublishAt)
+		b.lastStackIndex = len(b.values)
+
+		b.queryFields = append(b.queryFields, fmt.Sprintf(`
+		unpublish_at = NULLIF($%d, '')::TIMESTAMP`, b.lastStackIndex))
+	}
+}
+
 func (b *updateProductBuilder) updateCategory() error {
 	if b.req.Category == nil {
 		return nil
@@ -131,30 +214,36 @@ func (b *updateProductBuilder) updateCategory() error {
 }
 
 func (b *updateProductBuilder) insertImages() error {
+	b.req.ArrangeImages()
+
 	query := `
 	INSERT INTO "images" (
 		"filename",
 		"url",
-		"product_id"
+		"product_id",
+		"position",
+		"is_primary",
+		"alt_text",
+		"renditions"
 	)
 	VALUES`
 
 	valueStack := make([]any, 0)
 	var index int
 	for i, image := range b.req.Images {
-		valueStack = append(valueStack, image.FileName, image.Url, b.req.ID)
+		valueStack = append(valueStack, image.FileName, image.Url, b.req.ID, image.Position, image.IsPrimary, image.AltText, image.Renditions)
 
 		if i != len(b.req.Images)-1 {
 			query += fmt.Sprintf(`
-			( $%d, $%d, $%d )`, index+1, index+2, index+3)
+			( $%d, $%d, $%d, $%d, $%d, $%d, $%d ),`, index+1, index+2, index+3, index+4, index+5, index+6, index+7)
 		} else {
 			query += fmt.Sprintf(`
-			( $%d, $%d, $%d );`, index+1, index+2, index+3)
+			( $%d, $%d, $%d, $%d, $%d, $%d, $%d );`, index+1, index+2, index+3, index+4, index+5, index+6, index+7)
 		}
-		index += 3
+		index += 7
 	}
 
-	if _, err := b.db.ExecContext(
+	if _, err := b.tx.ExecContext(
 		context.Background(),
 		query,
 		valueStack...,
@@ -166,9 +255,71 @@ func (b *updateProductBuilder) insertImages() error {
 	return nil
 }
 
+const priceSnapshotQuery = `
+	jsonb_build_object(
+		'price', price,
+		'sale_price', sale_price,
+		'sale_starts_at', sale_starts_at,
+		'sale_ends_at', sale_ends_at
+	)`
+
+// getOldPrice keeps the pricing before update and locks the product row
+func (b *updateProductBuilder) getOldPrice() error {
+	query := `
+	SELECT` + priceSnapshotQuery + `
+	FROM products
+	WHERE id = $1
+	FOR UPDATE;`
+
+	if err := b.tx.GetContext(context.Background(), &b.oldPrice, query, b.req.ID); err != nil {
+		b.tx.Rollback()
+		return fmt.Errorf("get product price failed: %v", err)
+	}
+
+	return nil
+}
+
+// checkSalePrice validates the updated row, a request may carry only one of
+// price and sale_price so the stored one is compared under the row lock
+func (b *updateProductBuilder) checkSalePrice() error {
+	query := `
+	SELECT sale_price IS NOT NULL AND sale_price >= price
+	FROM products
+	WHERE id = $1;`
+
+	var notLower bool
+	if err := b.tx.GetContext(context.Background(), &notLower, query, b.req.ID); err != nil {
+		b.tx.Rollback()
+		return fmt.Errorf("check sale price failed: %v", err)
+	}
+	if notLower {
+		b.tx.Rollback()
+		return products.ErrSalePriceNotLower
+	}
+
+	return nil
+}
+
+// insertPriceHistory writes the new pricing only when it has changed
+func (b *updateProductBuilder) insertPriceHistory() error {
+	query := `
+	INSERT INTO products_price_history (product_id, price, sale_price, sale_starts_at, sale_ends_at)
+	SELECT id, price, sale_price, sale_starts_at, sale_ends_at
+	FROM products
+	WHERE id = $1
+	  AND` + priceSnapshotQuery + ` IS DISTINCT FROM $2::jsonb;`
+
+	if _, err := b.tx.ExecContext(context.Background(), query, b.req.ID, string(b.oldPrice)); err != nil {
+		b.tx.Rollback()
+		return fmt.Errorf("insert price history failed: %v", err)
+	}
+
+	return nil
+}
+
 func (b *updateProductBuilder) getOldImages() []*entities.Image {
 	query := `
-	SELECT id, filename, url
+	SELECT id, filename, url, position, is_primary, alt_text, renditions
 	FROM images
 	WHERE product_id = $1;
 	`
@@ -188,14 +339,22 @@ func (b *updateProductBuilder) deleteOldImages() error {
 	if len(images) > 0 {
 		deleteFileReq := make([]*files.DeleteFileReq, 0)
 
+		// Keep files which are still used by the new images
+		keep := make(map[string]bool)
+		for _, img := range b.req.Images {
+			keep[img.FileName] = true
+		}
+
 		for _, img := range images {
+			if keep[img.FileName] {
+				continue
+			}
+
 			deleteFileReq = append(deleteFileReq, &files.DeleteFileReq{
-				Destination: fmt.Sprintf("product/%s", img.FileName),
+				Destination: fmt.Sprintf("products/%s", img.FileName),
 			})
 		}
 
-		fmt.Println(deleteFileReq)
-
 		if err := b.filesUsecases.DeleteFileOnStorage(deleteFileReq); err != nil {
 			log.Printf("delete image failed: %v\n", err)
 		}
@@ -255,6 +414,13 @@ func (en *updateProductEngineer) SumQueryField() {
 	en.builder.updateTitleQuery()
 	en.builder.updateDescriptionQuery()
 	en.builder.updatePriceQuery()
+	en.builder.updateSalePriceQuery()
+	en.builder.updateSaleStartsAtQuery()
+	en.builder.updateSaleEndsAtQuery()
+	en.builder.updateStockQuery()
+	en.builder.updateStatusQuery()
+	en.builder.updatePublishAtQuery()
+	en.builder.updateUnpublishAtQuery()
 
 	fields := en.builder.getQueryFields()
 
@@ -276,11 +442,24 @@ func (en *updateProductEngineer) UpdateProduct() error {
 	en.SumQueryField()
 	en.builder.closeQuery()
 
+	if err := en.builder.getOldPrice(); err != nil {
+		return err
+	}
+
 	// Update product
 	if err := en.builder.updateProduct(); err != nil {
 		return err
 	}
 
+	if err := en.builder.checkSalePrice(); err != nil {
+		return err
+	}
+
+	// Price history
+	if err := en.builder.insertPriceHistory(); err != nil {
+		return err
+	}
+
 	// Update category
 	if err := en.builder.updateCategory(); err != nil {
 		return err
@@ -2,6 +2,7 @@ package productsRepositories
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"github.com/jmoiron/sqlx"
@@ -19,6 +20,18 @@ type IProductsRepository interface {
 	InsertProduct(req *products.Product) (*products.Product, error)
 	UpdateProduct(req *products.Product) (*products.Product, error)
 	DeleteProduct(productID string) error
+	RestoreProduct(productID string) error
+	PurgeProducts(before time.Time) (int, []*entities.Image, error)
+	FindPriceHistory(productID string) ([]*products.PriceHistory, error)
+	FindImages(productID string) ([]*entities.Image, error)
+	InsertImage(productID string, req *entities.Image) (*entities.Image, error)
+	UpdateImage(productID string, req *products.UpdateImageReq) error
+	DeleteImage(productID, imageID string) (*entities.Image, error)
+	ReorderImages(productID string, imageIDs []string) error
+	InsertProductImport(req *products.ProductImport) error
+	UpdateProductImport(req *products.ProductImport) error
+	FindOneProductImport(importID string) (*products.ProductImport, error)
+	FailUnfinishedProductImports(reason string) (int, error)
 }
 
 type productsRepository struct {
@@ -45,16 +58,39 @@ func (r *productsRepository) FindOneProduct(productID string) (*products.Product
              p.title,
              p.description,
              p.price,
+             p.sale_price,
+             p.sale_starts_at,
+             p.sale_ends_at,
+             (p.sale_price IS NOT NULL AND
+              (p.sale_starts_at IS NULL OR p.sale_starts_at <= now()) AND
+              (p.sale_ends_at IS NULL OR p.sale_ends_at > now())) AS on_sale,
+             (CASE
+                  WHEN p.sale_price IS NOT NULL AND
+                       (p.sale_starts_at IS NULL OR p.sale_starts_at <= now()) AND
+                       (p.sale_ends_at IS NULL OR p.sale_ends_at > now())
+                      THEN p.sale_price
+                  ELSE p.price END)                                          AS effective_price,
+             p.stock,
+             p.status,
+             p.publish_at,
+             p.unpublish_at,
+             (p.status = 'published' AND
+              (p.publish_at IS NULL OR p.publish_at <= now()) AND
+              (p.unpublish_at IS NULL OR p.unpublish_at > now())) AS is_live,
              (SELECT to_jsonb(ct)
               FROM (SELECT c.id,
                            c.title
                     FROM categories c
                              LEFT JOIN products_categories pc ON pc.category_id = c.id
                     WHERE pc.product_id = p.id) AS ct) AS category,
-             (SELECT COALESCE(array_to_json(array_agg(it)), '[]'::json)
+             (SELECT COALESCE(array_to_json(array_agg(it ORDER BY it.position)), '[]'::json)
               FROM (SELECT i.id,
                            i.filename,
-                           i.url
+                           i.url,
+                           i.position,
+                           i.is_primary,
+                           i.alt_text,
+                           i.renditions
                     FROM images i
                     WHERE i.product_id = p.id) AS it)  AS images,
              p.created_at,
@@ -62,6 +98,7 @@ func (r *productsRepository) FindOneProduct(productID string) (*products.Product
 
       FROM products p
       WHERE p.id = $1
+        AND p.deleted_at IS NULL
       LIMIT 1) AS t;
 	`
 
@@ -124,11 +161,465 @@ func (r *productsRepository) DeleteProduct(productID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
-	query := `DELETE FROM products WHERE id = $1`
+	query := `
+	UPDATE products
+	SET deleted_at = now()
+	WHERE id = $1
+	  AND deleted_at IS NULL;`
 
-	if _, err := r.db.ExecContext(ctx, query, productID); err != nil {
+	result, err := r.db.ExecContext(ctx, query, productID)
+	if err != nil {
 		return fmt.Errorf("delete product failed: %v", err)
 	}
 
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *productsRepository) RestoreProduct(productID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	UPDATE products
+	SET deleted_at = NULL
+	WHERE id = $1
+	  AND deleted_at IS NOT NULL;`
+
+	result, err := r.db.ExecContext(ctx, query, productID)
+	if err != nil {
+		return fmt.Errorf("restore product failed: %v", err)
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *productsRepository) PurgeProducts(before time.Time) (int, []*entities.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Lock the products first so a concurrent restore can not race the
+	// purge, a product restored before the lock is skipped by the recheck
+	lockQuery := `
+	SELECT id
+	FROM products
+	WHERE deleted_at IS NOT NULL
+	  AND deleted_at < $1
+	FOR UPDATE;`
+
+	ids := make([]string, 0)
+	if err := tx.SelectContext(ctx, &ids, lockQuery, before); err != nil {
+		_ = tx.Rollback()
+		return 0, nil, fmt.Errorf("lock purge products failed: %v", err)
+	}
+	if len(ids) == 0 {
+		_ = tx.Rollback()
+		return 0, make([]*entities.Image, 0), nil
+	}
+
+	imagesQuery := `
+	SELECT id, filename, url
+	FROM images
+	WHERE product_id = ANY($1);`
+
+	images := make([]*entities.Image, 0)
+	if err := tx.SelectContext(ctx, &images, imagesQuery, ids); err != nil {
+		_ = tx.Rollback()
+		return 0, nil, fmt.Errorf("find purge images failed: %v", err)
+	}
+
+	query := `
+	DELETE
+	FROM products
+	WHERE id = ANY($1);`
+
+	result, err := tx.ExecContext(ctx, query, ids)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, nil, fmt.Errorf("purge products failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return int(rows), images, nil
+}
+
+func (r *productsRepository) InsertProductImport(req *products.ProductImport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	INSERT INTO products_imports (user_id, filename, format, status, total_rows)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id;`
+
+	if err := r.db.QueryRowContext(
+		ctx,
+		query,
+		req.UserID,
+		req.FileName,
+		req.Format,
+		req.Status,
+		req.TotalRows,
+	).Scan(&req.ID); err != nil {
+		return fmt.Errorf("insert products import failed: %v", err)
+	}
+
+	return nil
+}
+
+func (r *productsRepository) UpdateProductImport(req *products.ProductImport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	report, err := json.Marshal(req.Report)
+	if err != nil {
+		return fmt.Errorf("marshal products import report failed: %v", err)
+	}
+
+	query := `
+	UPDATE products_imports
+	SET status = $1,
+	    success_rows = $2,
+	    failed_rows = $3,
+	    report = $4,
+	    error = $5
+	WHERE id = $6;`
+
+	if _, err := r.db.ExecContext(
+		ctx,
+		query,
+		req.Status,
+		req.SuccessRows,
+		req.FailedRows,
+		report,
+		req.Error,
+		req.ID,
+	); err != nil {
+		return fmt.Errorf("update products import failed: %v", err)
+	}
+
 	return nil
 }
+
+func (r *productsRepository) FindOneProductImport(importID string) (*products.ProductImport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	SELECT to_jsonb(t)
+	FROM (SELECT pi.id,
+	             pi.user_id,
+	             pi.filename,
+	             pi.format,
+	             pi.status,
+	             pi.total_rows,
+	             pi.success_rows,
+	             pi.failed_rows,
+	             pi.error,
+	             pi.report,
+	             pi.created_at,
+	             pi.updated_at
+	      FROM products_imports pi
+	      WHERE pi.id::TEXT = $1) AS t;`
+
+	importBytes := make([]byte, 0)
+	productImport := &products.ProductImport{
+		Report: make([]*products.ProductImportRowReport, 0),
+	}
+
+	if err := r.db.GetContext(ctx, &importBytes, query, importID); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(importBytes, &productImport); err != nil {
+		return nil, fmt.Errorf("unmarshal products import failed: %v", err)
+	}
+
+	return productImport, nil
+}
+
+// FailUnfinishedProductImports marks the imports still pending or processing
+// as failed, nothing works on them once the process that started them is gone
+func (r *productsRepository) FailUnfinishedProductImports(reason string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	UPDATE products_imports
+	SET status = 'failed',
+	    error = $1
+	WHERE status IN ('pending', 'processing');`
+
+	result, err := r.db.ExecContext(ctx, query, reason)
+	if err != nil {
+		return 0, fmt.Errorf("fail unfinished products imports failed: %v", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	return int(rows), nil
+}
+
+func (r *productsRepository) FindPriceHistory(productID string) ([]*products.PriceHistory, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	SELECT id,
+	       product_id,
+	       price,
+	       sale_price,
+	       to_char(sale_starts_at, 'YYYY-MM-DD HH24:MI:SS') AS sale_starts_at,
+	       to_char(sale_ends_at, 'YYYY-MM-DD HH24:MI:SS')   AS sale_ends_at,
+	       to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')     AS created_at
+	FROM products_price_history
+	WHERE product_id = $1
+	ORDER BY created_at DESC;`
+
+	history := make([]*products.PriceHistory, 0)
+	if err := r.db.SelectContext(ctx, &history, query, productID); err != nil {
+		return nil, fmt.Errorf("find price history failed: %v", err)
+	}
+
+	return history, nil
+}
+
+// Close the gaps of positions after an image is removed
+const compactImagesQuery = `
+	UPDATE images i
+	SET position = o.position
+	FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY position, created_at) - 1 AS position
+	      FROM images
+	      WHERE product_id = $1) AS o
+	WHERE o.id = i.id;`
+
+// Make the first image primary when the product has no primary image
+const ensurePrimaryImageQuery = `
+	UPDATE images
+	SET is_primary = TRUE
+	WHERE id = (SELECT id FROM images WHERE product_id = $1 ORDER BY position LIMIT 1)
+	  AND NOT EXISTS (SELECT 1 FROM images WHERE product_id = $1 AND is_primary);`
+
+func (r *productsRepository) FindImages(productID string) ([]*entities.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+	SELECT id, filename, url, position, is_primary, alt_text, renditions
+	FROM images
+	WHERE product_id = $1
+	ORDER BY position;`
+
+	images := make([]*entities.Image, 0)
+	if err := r.db.SelectContext(ctx, &images, query, productID); err != nil {
+		return nil, fmt.Errorf("find images failed: %v", err)
+	}
+
+	return images, nil
+}
+
+func (r *productsRepository) setPrimaryImage(ctx context.Context, tx *sqlx.Tx, productID, imageID string) error {
+	// Clear first, the unique index allows only one primary image per product
+	query := `
+	UPDATE images
+	SET is_primary = FALSE
+	WHERE product_id = $1
+	  AND id::TEXT <> $2
+	  AND is_primary;`
+
+	if _, err := tx.ExecContext(ctx, query, productID, imageID); err != nil {
+		return fmt.Errorf("clear primary image failed: %v", err)
+	}
+
+	query = `
+	UPDATE images
+	SET is_primary = TRUE
+	WHERE product_id = $1
+	  AND id::TEXT = $2;`
+
+	if _, err := tx.ExecContext(ctx, query, productID, imageID); err != nil {
+		return fmt.Errorf("set primary image failed: %v", err)
+	}
+
+	return nil
+}
+
+func (r *productsRepository) InsertImage(productID string, req *entities.Image) (*entities.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	INSERT INTO images (filename, url, product_id, alt_text, renditions, position)
+	VALUES ($1, $2, $3, $4, $5, (SELECT COALESCE(MAX(position) + 1, 0) FROM images WHERE product_id = $3))
+	RETURNING id;`
+
+	if err := tx.QueryRowxContext(ctx, query, req.FileName, req.Url, productID, req.AltText, req.Renditions).Scan(&req.ID); err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("insert image failed: %v", err)
+	}
+
+	if req.IsPrimary {
+		if err := r.setPrimaryImage(ctx, tx, productID, req.ID); err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, ensurePrimaryImageQuery, productID); err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("set primary image failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	image := new(entities.Image)
+	query = `
+	SELECT id, filename, url, position, is_primary, alt_text, renditions
+	FROM images
+	WHERE id::TEXT = $1;`
+
+	if err := r.db.GetContext(ctx, image, query, req.ID); err != nil {
+		return nil, fmt.Errorf("find image failed: %v", err)
+	}
+
+	return image, nil
+}
+
+func (r *productsRepository) UpdateImage(productID string, req *products.UpdateImageReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE images
+	SET alt_text = COALESCE($1, alt_text)
+	WHERE product_id = $2
+	  AND id::TEXT = $3;`
+
+	result, err := tx.ExecContext(ctx, query, req.AltText, productID, req.ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("update image failed: %v", err)
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		_ = tx.Rollback()
+		return sql.ErrNoRows
+	}
+
+	if req.IsPrimary {
+		if err := r.setPrimaryImage(ctx, tx, productID, req.ID); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *productsRepository) DeleteImage(productID, imageID string) (*entities.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	DELETE
+	FROM images
+	WHERE product_id = $1
+	  AND id::TEXT = $2
+	RETURNING id, filename, url, position, is_primary, alt_text, renditions;`
+
+	image := new(entities.Image)
+	if err := tx.GetContext(ctx, image, query, productID, imageID); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, compactImagesQuery, productID); err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("reorder images failed: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, ensurePrimaryImageQuery, productID); err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("set primary image failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+func (r *productsRepository) ReorderImages(productID string, imageIDs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Every image of the product must be in the new order
+	var count int
+	if err := tx.GetContext(ctx, &count, `SELECT COUNT(*) FROM images WHERE product_id = $1;`, productID); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("count images failed: %v", err)
+	}
+
+	if count != len(imageIDs) {
+		_ = tx.Rollback()
+		return fmt.Errorf("image_ids must contain all %d images of the product", count)
+	}
+
+	query := `
+	UPDATE images i
+	SET position = o.position - 1
+	FROM unnest($2::TEXT[]) WITH ORDINALITY AS o(id, position)
+	WHERE i.product_id = $1
+	  AND i.id::TEXT = o.id;`
+
+	result, err := tx.ExecContext(ctx, query, productID, imageIDs)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("reorder images failed: %v", err)
+	}
+
+	if rows, _ := result.RowsAffected(); int(rows) != count {
+		_ = tx.Rollback()
+		return fmt.Errorf("image_ids must contain all %d images of the product", count)
+	}
+
+	return tx.Commit()
+}
@@ -1,10 +1,21 @@
 package productsUsecases
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/korvised/go-ecommerce/modules/entities"
+	"github.com/korvised/go-ecommerce/modules/files"
+	"github.com/korvised/go-ecommerce/modules/files/filesUsecases"
 	"github.com/korvised/go-ecommerce/modules/products"
 	"github.com/korvised/go-ecommerce/modules/products/productsRepositories"
+	"io"
+	"log"
 	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type IProductsUsecase interface {
@@ -13,15 +24,30 @@ type IProductsUsecase interface {
 	AddProduct(req *products.Product) (*products.Product, error)
 	UpdateProduct(req *products.Product) (*products.Product, error)
 	DeleteProduct(productID string) error
+	RestoreProduct(productID string) (*products.Product, error)
+	PurgeDeletedProducts(before time.Time) (int, error)
+	FindPriceHistory(productID string) ([]*products.PriceHistory, error)
+	AddImage(productID string, req *entities.Image) (*entities.Image, error)
+	UpdateImage(productID string, req *products.UpdateImageReq) ([]*entities.Image, error)
+	RemoveImage(productID, imageID string) ([]*entities.Image, error)
+	ReorderImages(productID string, req *products.ReorderImagesReq) ([]*entities.Image, error)
+	ImportProducts(req *products.ProductImport, file io.Reader) (*products.ProductImport, error)
+	FindOneProductImport(importID string) (*products.ProductImport, error)
+	FailUnfinishedImports() (int, error)
 }
 
 type productsUsecase struct {
 	productsRepository productsRepositories.IProductsRepository
+	filesUsecase       filesUsecases.IFilesUsecase
 }
 
-func ProductsUsecase(productsRepository productsRepositories.IProductsRepository) IProductsUsecase {
+func ProductsUsecase(
+	productsRepository productsRepositories.IProductsRepository,
+	filesUsecase filesUsecases.IFilesUsecase,
+) IProductsUsecase {
 	return &productsUsecase{
 		productsRepository: productsRepository,
+		filesUsecase:       filesUsecase,
 	}
 }
 
@@ -42,6 +68,11 @@ func (u *productsUsecase) FindManyProducts(req *products.ProductFilter) *entitie
 }
 
 func (u *productsUsecase) AddProduct(req *products.Product) (*products.Product, error) {
+	// New products are hidden until published
+	if req.Status == "" {
+		req.Status = products.StatusDraft
+	}
+
 	return u.productsRepository.InsertProduct(req)
 }
 
@@ -52,3 +83,302 @@ func (u *productsUsecase) UpdateProduct(req *products.Product) (*products.Produc
 func (u *productsUsecase) DeleteProduct(productID string) error {
 	return u.productsRepository.DeleteProduct(productID)
 }
+
+func (u *productsUsecase) RestoreProduct(productID string) (*products.Product, error) {
+	if err := u.productsRepository.RestoreProduct(productID); err != nil {
+		return nil, err
+	}
+
+	return u.productsRepository.FindOneProduct(productID)
+}
+
+func (u *productsUsecase) PurgeDeletedProducts(before time.Time) (int, error) {
+	count, images, err := u.productsRepository.PurgeProducts(before)
+	if err != nil {
+		return 0, err
+	}
+
+	// Delete images
+	if len(images) > 0 {
+		deleteFileReq := make([]*files.DeleteFileReq, 0)
+		for _, img := range images {
+			deleteFileReq = append(deleteFileReq, &files.DeleteFileReq{
+				Destination: fmt.Sprintf("products/%s", img.FileName),
+			})
+		}
+
+		if err := u.filesUsecase.DeleteFileOnStorage(deleteFileReq); err != nil {
+			log.Printf("delete image failed: %v", err)
+		}
+	}
+
+	return count, nil
+}
+
+func (u *productsUsecase) FindPriceHistory(productID string) ([]*products.PriceHistory, error) {
+	return u.productsRepository.FindPriceHistory(productID)
+}
+
+func (u *productsUsecase) AddImage(productID string, req *entities.Image) (*entities.Image, error) {
+	if _, err := u.productsRepository.FindOneProduct(productID); err != nil {
+		return nil, err
+	}
+
+	return u.productsRepository.InsertImage(productID, req)
+}
+
+func (u *productsUsecase) UpdateImage(productID string, req *products.UpdateImageReq) ([]*entities.Image, error) {
+	if err := u.productsRepository.UpdateImage(productID, req); err != nil {
+		return nil, err
+	}
+
+	return u.productsRepository.FindImages(productID)
+}
+
+func (u *productsUsecase) RemoveImage(productID, imageID string) ([]*entities.Image, error) {
+	image, err := u.productsRepository.DeleteImage(productID, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteFileReq := []*files.DeleteFileReq{
+		{Destination: fmt.Sprintf("products/%s", image.FileName)},
+	}
+	if err := u.filesUsecase.DeleteFileOnStorage(deleteFileReq); err != nil {
+		log.Printf("delete image failed: %v", err)
+	}
+
+	return u.productsRepository.FindImages(productID)
+}
+
+func (u *productsUsecase) ReorderImages(productID string, req *products.ReorderImagesReq) ([]*entities.Image, error) {
+	if err := u.productsRepository.ReorderImages(productID, req.ImageIDs); err != nil {
+		return nil, err
+	}
+
+	return u.productsRepository.FindImages(productID)
+}
+
+func (u *productsUsecase) ImportProducts(req *products.ProductImport, file io.Reader) (*products.ProductImport, error) {
+	var rows []*products.ProductImportRow
+	var err error
+
+	switch req.Format {
+	case products.ImportFormatCSV:
+		rows, err = parseImportCSV(file)
+	case products.ImportFormatJSON:
+		rows, err = parseImportJSON(file)
+	default:
+		return nil, fmt.Errorf("import format must be csv or json")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("import file has no rows")
+	}
+
+	req.Status = products.ImportStatusPending
+	req.TotalRows = len(rows)
+	if err := u.productsRepository.InsertProductImport(req); err != nil {
+		return nil, err
+	}
+
+	// Run import in background
+	go u.importWorker(req, rows)
+
+	return u.productsRepository.FindOneProductImport(req.ID)
+}
+
+func (u *productsUsecase) FindOneProductImport(importID string) (*products.ProductImport, error) {
+	return u.productsRepository.FindOneProductImport(importID)
+}
+
+// FailUnfinishedImports fails the imports a previous run left behind, call it
+// on startup before any import is started
+func (u *productsUsecase) FailUnfinishedImports() (int, error) {
+	return u.productsRepository.FailUnfinishedProductImports("import was interrupted by a restart, upload the file again")
+}
+
+func (u *productsUsecase) importWorker(req *products.ProductImport, rows []*products.ProductImportRow) {
+	// A panic fails the import instead of the whole api, the rows done so far
+	// stay in the report
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("products import %s: panic: %v", req.ID, r)
+
+			req.Status = products.ImportStatusFailed
+			req.Error = fmt.Sprintf("import stopped: %v", r)
+			if err := u.productsRepository.UpdateProductImport(req); err != nil {
+				log.Printf("products import %s: %v", req.ID, err)
+			}
+		}
+	}()
+
+	req.Status = products.ImportStatusProcessing
+	req.Report = make([]*products.ProductImportRowReport, 0, len(rows))
+	if err := u.productsRepository.UpdateProductImport(req); err != nil {
+		log.Printf("products import %s: %v", req.ID, err)
+	}
+
+	for _, row := range rows {
+		report := &products.ProductImportRowReport{
+			Row:       row.Row,
+			ProductID: row.ID,
+			Title:     row.Title,
+			Status:    products.ImportRowSuccess,
+		}
+
+		product, err := u.importRow(row)
+		if err != nil {
+			report.Status = products.ImportRowFailed
+			report.Error = err.Error()
+			req.FailedRows++
+		} else {
+			report.ProductID = product.ID
+			req.SuccessRows++
+		}
+
+		req.Report = append(req.Report, report)
+	}
+
+	req.Status = products.ImportStatusCompleted
+	if req.SuccessRows == 0 {
+		req.Status = products.ImportStatusFailed
+	}
+
+	if err := u.productsRepository.UpdateProductImport(req); err != nil {
+		log.Printf("products import %s: %v", req.ID, err)
+	}
+}
+
+func (u *productsUsecase) importRow(row *products.ProductImportRow) (*products.Product, error) {
+	if err := row.Validate(); err != nil {
+		return nil, err
+	}
+
+	product := row.ToProduct()
+
+	// Upsert, a row with an existing id updates the product
+	if product.ID != "" {
+		if _, err := u.productsRepository.FindOneProduct(product.ID); err != nil {
+			return nil, fmt.Errorf("product %s not found", product.ID)
+		}
+
+		return u.UpdateProduct(product)
+	}
+
+	return u.AddProduct(product)
+}
+
+func parseImportCSV(file io.Reader) ([]*products.ProductImportRow, error) {
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header failed: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, fmt.Errorf("csv header must contain title column")
+	}
+
+	rows := make([]*products.ProductImportRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		// Rows are numbered by the line they start on, quoted fields may
+		// span lines and blank lines are skipped
+		row := &products.ProductImportRow{
+			Images: make([]string, 0),
+		}
+		rows = append(rows, row)
+
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				row.Row = parseErr.StartLine
+			}
+			row.ParseErr = fmt.Sprintf("read csv row failed: %v", err)
+			continue
+		}
+		row.Row, _ = reader.FieldPos(0)
+
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row.ID = value("id")
+		row.Title = value("title")
+		row.Description = value("description")
+		row.Status = value("status")
+
+		if v := value("price"); v != "" {
+			if row.Price, err = strconv.ParseFloat(v, 64); err != nil {
+				row.ParseErr = "price must be a number"
+				continue
+			}
+		}
+
+		if v := value("category_id"); v != "" {
+			if row.CategoryID, err = strconv.Atoi(v); err != nil {
+				row.ParseErr = "category_id must be a number"
+				continue
+			}
+		}
+
+		if v := value("stock"); v != "" {
+			stock, err := strconv.Atoi(v)
+			if err != nil {
+				row.ParseErr = "stock must be a number"
+				continue
+			}
+			row.Stock = &stock
+		}
+
+		// Image urls are separated by "|"
+		for _, img := range strings.Split(value("images"), "|") {
+			if img = strings.TrimSpace(img); img != "" {
+				row.Images = append(row.Images, img)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+func parseImportJSON(file io.Reader) ([]*products.ProductImportRow, error) {
+	raws := make([]json.RawMessage, 0)
+	if err := json.NewDecoder(file).Decode(&raws); err != nil {
+		return nil, fmt.Errorf("json must be an array of products: %v", err)
+	}
+
+	rows := make([]*products.ProductImportRow, 0, len(raws))
+	for i, raw := range raws {
+		row := &products.ProductImportRow{
+			Images: make([]string, 0),
+		}
+		if err := json.Unmarshal(raw, row); err != nil {
+			row.ParseErr = fmt.Sprintf("unmarshal product failed: %v", err)
+		}
+		row.Row = i + 1
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,218 @@
+package productsUsecases
+
+import (
+	"fmt"
+	"github.com/korvised/go-ecommerce/modules/products"
+	"github.com/korvised/go-ecommerce/modules/products/productsRepositories"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func intPtr(v int) *int { return &v }
+
+type testParseImport struct {
+	name   string
+	input  string
+	isErr  bool
+	expect []*products.ProductImportRow
+}
+
+func TestParseImportCSV(t *testing.T) {
+	tests := []testParseImport{
+		{
+			name: "rows",
+			input: "id,title,description,price,category_id,stock,status,images\n" +
+				",Shirt,Cotton,12.5,1,10,published,https://cdn.example.com/a.png | https://cdn.example.com/b.png\n" +
+				"p-1,Hat,,0,,0,,\n",
+			expect: []*products.ProductImportRow{
+				{
+					Row:         2,
+					Title:       "Shirt",
+					Description: "Cotton",
+					Price:       12.5,
+					CategoryID:  1,
+					Stock:       intPtr(10),
+					Status:      "published",
+					Images:      []string{"https://cdn.example.com/a.png", "https://cdn.example.com/b.png"},
+				},
+				{
+					Row:    3,
+					ID:     "p-1",
+					Title:  "Hat",
+					Stock:  intPtr(0),
+					Images: []string{},
+				},
+			},
+		},
+		{
+			name:  "columns in any order and case, stock left out",
+			input: "Price, TITLE\n9,Sock\n",
+			expect: []*products.ProductImportRow{
+				{Row: 2, Title: "Sock", Price: 9, Images: []string{}},
+			},
+		},
+		{
+			name:  "invalid numbers are kept per row",
+			input: "title,price,category_id,stock\nA,cheap,1,1\nB,1,food,1\nC,1,1,many\nD,1,1,1\n",
+			expect: []*products.ProductImportRow{
+				{Row: 2, Title: "A", Images: []string{}, ParseErr: "price must be a number"},
+				{Row: 3, Title: "B", Price: 1, Images: []string{}, ParseErr: "category_id must be a number"},
+				{Row: 4, Title: "C", Price: 1, CategoryID: 1, Images: []string{}, ParseErr: "stock must be a number"},
+				{Row: 5, Title: "D", Price: 1, CategoryID: 1, Stock: intPtr(1), Images: []string{}},
+			},
+		},
+		{
+			name: "multi-line description and blank lines",
+			input: "title,description,price\n" +
+				"Shirt,\"Soft\nand warm\",1\n" +
+				"\n" +
+				"Hat,,2\n",
+			expect: []*products.ProductImportRow{
+				{Row: 2, Title: "Shirt", Description: "Soft\nand warm", Price: 1, Images: []string{}},
+				{Row: 5, Title: "Hat", Price: 2, Images: []string{}},
+			},
+		},
+		{
+			name:  "without title column",
+			input: "name,price\nShirt,1\n",
+			isErr: true,
+		},
+		{
+			name:  "empty",
+			input: "",
+			isErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		rows, err := parseImportCSV(strings.NewReader(test.input))
+		if test.isErr {
+			if err == nil {
+				t.Errorf("%s: expect: %s, got: %v", test.name, "error", nil)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: expect: %v, got: %v", test.name, nil, err)
+			continue
+		}
+		if !reflect.DeepEqual(rows, test.expect) {
+			t.Errorf("%s: expect: %s, got: %s", test.name, formatImportRows(test.expect), formatImportRows(rows))
+		}
+	}
+}
+
+func TestParseImportCSVMalformedRow(t *testing.T) {
+	rows, err := parseImportCSV(strings.NewReader("title,price\n\"Shirt,1\nHat,2\n"))
+	if err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+
+	if len(rows) == 0 || rows[0].ParseErr == "" || rows[0].Row != 2 {
+		t.Errorf("expect: %s, got: %s", "row 2 to keep the read error", formatImportRows(rows))
+	}
+}
+
+func TestParseImportJSON(t *testing.T) {
+	tests := []testParseImport{
+		{
+			name: "rows",
+			input: `[
+				{"title": "Shirt", "price": 12.5, "category_id": 1, "stock": 10, "images": ["https://cdn.example.com/a.png"]},
+				{"id": "p-1", "title": "Hat", "stock": 0},
+				{"id": "p-2", "title": "Sock"}
+			]`,
+			expect: []*products.ProductImportRow{
+				{Row: 1, Title: "Shirt", Price: 12.5, CategoryID: 1, Stock: intPtr(10), Images: []string{"https://cdn.example.com/a.png"}},
+				{Row: 2, ID: "p-1", Title: "Hat", Stock: intPtr(0), Images: []string{}},
+				{Row: 3, ID: "p-2", Title: "Sock", Images: []string{}},
+			},
+		},
+		{
+			name:  "not an array",
+			input: `{"title": "Shirt"}`,
+			isErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		rows, err := parseImportJSON(strings.NewReader(test.input))
+		if test.isErr {
+			if err == nil {
+				t.Errorf("%s: expect: %s, got: %v", test.name, "error", nil)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: expect: %v, got: %v", test.name, nil, err)
+			continue
+		}
+		if !reflect.DeepEqual(rows, test.expect) {
+			t.Errorf("%s: expect: %s, got: %s", test.name, formatImportRows(test.expect), formatImportRows(rows))
+		}
+	}
+}
+
+func TestParseImportJSONInvalidRow(t *testing.T) {
+	rows, err := parseImportJSON(strings.NewReader(`[{"title": "Shirt", "price": "cheap"}, {"title": "Hat"}]`))
+	if err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+
+	if len(rows) != 2 || rows[0].Row != 1 || rows[0].ParseErr == "" || rows[1].Row != 2 || rows[1].ParseErr != "" {
+		t.Errorf("expect: %s, got: %s", "row 1 to keep the unmarshal error", formatImportRows(rows))
+	}
+}
+
+// testImportRepository panics on the title "panic" and keeps the last
+// update of the import
+type testImportRepository struct {
+	productsRepositories.IProductsRepository
+	updated products.ProductImport
+}
+
+func (r *testImportRepository) InsertProduct(req *products.Product) (*products.Product, error) {
+	if req.Title == "panic" {
+		panic("insert product")
+	}
+	req.ID = "P000001"
+	return req, nil
+}
+
+func (r *testImportRepository) UpdateProductImport(req *products.ProductImport) error {
+	r.updated = *req
+	return nil
+}
+
+func TestImportWorkerPanic(t *testing.T) {
+	repo := &testImportRepository{}
+	usecase := &productsUsecase{productsRepository: repo}
+
+	usecase.importWorker(&products.ProductImport{ID: "import-1"}, []*products.ProductImportRow{
+		{Row: 2, Title: "Shirt", CategoryID: 1},
+		{Row: 3, Title: "panic", CategoryID: 1},
+	})
+
+	if repo.updated.Status != products.ImportStatusFailed || repo.updated.Error == "" {
+		t.Errorf("expect: %s, got: %s %q", products.ImportStatusFailed, repo.updated.Status, repo.updated.Error)
+	}
+	if repo.updated.SuccessRows != 1 || len(repo.updated.Report) != 1 {
+		t.Errorf("expect: %s, got: %+v", "the rows before the panic reported", repo.updated.Report)
+	}
+}
+
+// formatImportRows prints the rows with their stock value instead of its address
+func formatImportRows(rows []*products.ProductImportRow) string {
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		stock := "nil"
+		if row.Stock != nil {
+			stock = fmt.Sprint(*row.Stock)
+		}
+		lines = append(lines, fmt.Sprintf("%+v stock:%s", *row, stock))
+	}
+	return strings.Join(lines, "; ")
+}
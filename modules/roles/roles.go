@@ -0,0 +1,25 @@
+package roles
+
+type Role struct {
+	ID          int      `db:"id" json:"id"`
+	Title       string   `db:"title" json:"title"`
+	Permissions []string `db:"permissions" json:"permissions"`
+}
+
+type Permission struct {
+	ID          int    `db:"id" json:"id"`
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+}
+
+// RoleReq adds or updates a role, a nil Permissions keeps the granted ones
+type RoleReq struct {
+	ID          int      `json:"-" form:"-"`
+	Title       string   `json:"title" form:"title"`
+	Permissions []string `json:"permissions" form:"permissions"`
+}
+
+type UserRoleReq struct {
+	UserID string `json:"-" form:"-"`
+	RoleID int    `json:"role_id" form:"role_id"`
+}
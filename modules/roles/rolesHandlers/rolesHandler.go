@@ -0,0 +1,144 @@
+package rolesHandlers
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/korvised/go-ecommerce/config"
+	"github.com/korvised/go-ecommerce/modules/entities"
+	"github.com/korvised/go-ecommerce/modules/roles"
+	"github.com/korvised/go-ecommerce/modules/roles/rolesRepositories"
+	"github.com/korvised/go-ecommerce/modules/roles/rolesUsecases"
+	"strconv"
+	"strings"
+)
+
+type rolesHandlersErrCode string
+
+const (
+	findRolesErr       rolesHandlersErrCode = "roles-001"
+	findPermissionsErr rolesHandlersErrCode = "roles-002"
+	insertRoleErr      rolesHandlersErrCode = "roles-003"
+	updateRoleErr      rolesHandlersErrCode = "roles-004"
+	deleteRoleErr      rolesHandlersErrCode = "roles-005"
+	updateUserRoleErr  rolesHandlersErrCode = "roles-006"
+)
+
+type IRolesHandler interface {
+	FindRoles(c *fiber.Ctx) error
+	FindPermissions(c *fiber.Ctx) error
+	InsertRole(c *fiber.Ctx) error
+	UpdateRole(c *fiber.Ctx) error
+	DeleteRole(c *fiber.Ctx) error
+	UpdateUserRole(c *fiber.Ctx) error
+}
+
+type rolesHandler struct {
+	cfg          config.IConfig
+	rolesUsecase rolesUsecases.IRolesUsecase
+}
+
+func RolesHandler(cfg config.IConfig, rolesUsecase rolesUsecases.IRolesUsecase) IRolesHandler {
+	return &rolesHandler{
+		cfg:          cfg,
+		rolesUsecase: rolesUsecase,
+	}
+}
+
+func (h *rolesHandler) FindRoles(c *fiber.Ctx) error {
+	data, err := h.rolesUsecase.FindRoles()
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(findRolesErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, data).Res()
+}
+
+func (h *rolesHandler) FindPermissions(c *fiber.Ctx) error {
+	data, err := h.rolesUsecase.FindPermissions()
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(findPermissionsErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, data).Res()
+}
+
+func (h *rolesHandler) InsertRole(c *fiber.Ctx) error {
+	req := new(roles.RoleReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(insertRoleErr), err.Error()).Res()
+	}
+
+	req.Title = strings.ToLower(strings.TrimSpace(req.Title))
+	if req.Title == "" {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(insertRoleErr), "title is required").Res()
+	}
+
+	role, err := h.rolesUsecase.InsertRole(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(insertRoleErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusCreated, role).Res()
+}
+
+func (h *rolesHandler) UpdateRole(c *fiber.Ctx) error {
+	roleId, err := strconv.Atoi(c.Params("role_id"))
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateRoleErr), "role id must be a number").Res()
+	}
+
+	req := new(roles.RoleReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateRoleErr), err.Error()).Res()
+	}
+	req.ID = roleId
+	req.Title = strings.ToLower(strings.TrimSpace(req.Title))
+
+	role, err := h.rolesUsecase.UpdateRole(req)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateRoleErr), "role not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateRoleErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, role).Res()
+}
+
+func (h *rolesHandler) DeleteRole(c *fiber.Ctx) error {
+	roleId, err := strconv.Atoi(c.Params("role_id"))
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(deleteRoleErr), "role id must be a number").Res()
+	}
+
+	if err := h.rolesUsecase.DeleteRole(roleId); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(deleteRoleErr), "role not found").Res()
+		case errors.Is(err, rolesUsecases.ErrRoleBuiltIn), errors.Is(err, rolesRepositories.ErrRoleInUse):
+			return entities.NewResponse(c).Error(fiber.StatusConflict, string(deleteRoleErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(deleteRoleErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *rolesHandler) UpdateUserRole(c *fiber.Ctx) error {
+	req := new(roles.UserRoleReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateUserRoleErr), err.Error()).Res()
+	}
+	req.UserID = strings.TrimSpace(c.Params("user_id"))
+
+	if err := h.rolesUsecase.UpdateUserRole(req); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateUserRoleErr), "role or user not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(updateUserRoleErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
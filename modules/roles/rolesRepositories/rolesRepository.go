@@ -0,0 +1,264 @@
+package rolesRepositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/korvised/go-ecommerce/modules/roles"
+	"time"
+)
+
+var ErrRoleInUse = errors.New("role is assigned to users")
+
+type IRolesRepository interface {
+	FindRoles() ([]*roles.Role, error)
+	FindOneRole(roleId int) (*roles.Role, error)
+	FindPermissions() ([]*roles.Permission, error)
+	InsertRole(req *roles.RoleReq) (int, error)
+	UpdateRole(req *roles.RoleReq) error
+	DeleteRole(roleId int) error
+	UpdateUserRole(req *roles.UserRoleReq) error
+}
+
+type rolesRepository struct {
+	db *sqlx.DB
+}
+
+func RolesRepository(db *sqlx.DB) IRolesRepository {
+	return &rolesRepository{
+		db: db,
+	}
+}
+
+const findRolesQuery = `
+	SELECT
+		COALESCE(array_to_json(array_agg("t" ORDER BY "t"."id")), '[]'::json)
+	FROM (
+		SELECT
+			"r"."id",
+			"r"."title",
+			(
+				SELECT
+					COALESCE(array_to_json(array_agg("p"."name" ORDER BY "p"."name")), '[]'::json)
+				FROM "roles_permissions" "rp"
+					JOIN "permissions" "p" ON "p"."id" = "rp"."permission_id"
+				WHERE "rp"."role_id" = "r"."id"
+			) AS "permissions"
+		FROM "roles" "r"
+		WHERE $1 = 0 OR "r"."id" = $1
+	) AS "t";`
+
+func (r *rolesRepository) findRoles(roleId int) ([]*roles.Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	bytes := make([]byte, 0)
+	if err := r.db.GetContext(ctx, &bytes, findRolesQuery, roleId); err != nil {
+		return nil, fmt.Errorf("find roles failed: %v", err)
+	}
+
+	data := make([]*roles.Role, 0)
+	if err := json.Unmarshal(bytes, &data); err != nil {
+		return nil, fmt.Errorf("unmarshal roles failed: %v", err)
+	}
+
+	return data, nil
+}
+
+func (r *rolesRepository) FindRoles() ([]*roles.Role, error) {
+	return r.findRoles(0)
+}
+
+func (r *rolesRepository) FindOneRole(roleId int) (*roles.Role, error) {
+	data, err := r.findRoles(roleId)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return data[0], nil
+}
+
+func (r *rolesRepository) FindPermissions() ([]*roles.Permission, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := `
+	 SELECT id, name, description
+	 FROM permissions
+	 ORDER BY name;
+	`
+
+	permissions := make([]*roles.Permission, 0)
+	if err := r.db.SelectContext(ctx, &permissions, query); err != nil {
+		return nil, fmt.Errorf("find permissions failed: %v", err)
+	}
+
+	return permissions, nil
+}
+
+// setPermissions replaces the permissions granted to a role, unknown names
+// are an error so a typo can not silently drop a grant
+func setPermissions(ctx context.Context, tx *sqlx.Tx, roleId int, names []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM roles_permissions WHERE role_id = $1;`, roleId); err != nil {
+		return fmt.Errorf("delete role permissions failed: %v", err)
+	}
+
+	unique := make(map[string]bool)
+	for _, name := range names {
+		unique[name] = true
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In(`
+	 INSERT INTO roles_permissions (role_id, permission_id)
+	 SELECT ?, id
+	 FROM permissions
+	 WHERE name IN (?);`, roleId, names)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, tx.Rebind(query), args...)
+	if err != nil {
+		return fmt.Errorf("insert role permissions failed: %v", err)
+	}
+
+	if rows, _ := result.RowsAffected(); int(rows) != len(unique) {
+		return fmt.Errorf("unknown permission in %v", names)
+	}
+
+	return nil
+}
+
+func (r *rolesRepository) InsertRole(req *roles.RoleReq) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var roleId int
+	if err := tx.QueryRowxContext(ctx, `INSERT INTO roles (title) VALUES ($1) RETURNING id;`, req.Title).Scan(&roleId); err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("insert role failed: %v", err)
+	}
+
+	if err := setPermissions(ctx, tx, roleId, req.Permissions); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	return roleId, nil
+}
+
+func (r *rolesRepository) UpdateRole(req *roles.RoleReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	 UPDATE roles
+	 SET title = COALESCE(NULLIF($2, ''), title)
+	 WHERE id = $1;
+	`
+
+	result, err := tx.ExecContext(ctx, query, req.ID, req.Title)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("update role failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		_ = tx.Rollback()
+		return sql.ErrNoRows
+	}
+
+	if req.Permissions != nil {
+		if err := setPermissions(ctx, tx, req.ID, req.Permissions); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (r *rolesRepository) DeleteRole(roleId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var inUse bool
+	if err := r.db.GetContext(ctx, &inUse, `SELECT EXISTS (SELECT 1 FROM users WHERE role_id = $1);`, roleId); err != nil {
+		return fmt.Errorf("find role users failed: %v", err)
+	}
+	if inUse {
+		return ErrRoleInUse
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM roles WHERE id = $1;`, roleId)
+	if err != nil {
+		return fmt.Errorf("delete role failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// UpdateUserRole moves a user to another role and signs them out, the role
+// is carried in the access token so old tokens must not outlive the change
+func (r *rolesRepository) UpdateUserRole(req *roles.UserRoleReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE users SET role_id = $2 WHERE id = $1;`, req.UserID, req.RoleID)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("update user role failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		_ = tx.Rollback()
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oauth WHERE user_id = $1;`, req.UserID); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("delete user oauth failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package rolesUsecases
+
+import (
+	"errors"
+	"github.com/korvised/go-ecommerce/modules/middlewares"
+	"github.com/korvised/go-ecommerce/modules/roles"
+	"github.com/korvised/go-ecommerce/modules/roles/rolesRepositories"
+	"log"
+)
+
+// ErrRoleBuiltIn is returned when deleting a role the code depends on
+var ErrRoleBuiltIn = errors.New("built-in roles can not be deleted")
+
+type IRolesUsecase interface {
+	FindRoles() ([]*roles.Role, error)
+	FindPermissions() ([]*roles.Permission, error)
+	InsertRole(req *roles.RoleReq) (*roles.Role, error)
+	UpdateRole(req *roles.RoleReq) (*roles.Role, error)
+	DeleteRole(roleId int) error
+	UpdateUserRole(req *roles.UserRoleReq) error
+}
+
+type rolesUsecase struct {
+	rolesRepository   rolesRepositories.IRolesRepository
+	reloadPermissions func() error
+}
+
+// RolesUsecase calls reloadPermissions after every change so the permission
+// cache of the middlewares picks it up at once
+func RolesUsecase(rolesRepository rolesRepositories.IRolesRepository, reloadPermissions func() error) IRolesUsecase {
+	return &rolesUsecase{
+		rolesRepository:   rolesRepository,
+		reloadPermissions: reloadPermissions,
+	}
+}
+
+func (u *rolesUsecase) reload() {
+	if err := u.reloadPermissions(); err != nil {
+		log.Printf("reload permissions failed: %v", err)
+	}
+}
+
+func (u *rolesUsecase) FindRoles() ([]*roles.Role, error) {
+	return u.rolesRepository.FindRoles()
+}
+
+func (u *rolesUsecase) FindPermissions() ([]*roles.Permission, error) {
+	return u.rolesRepository.FindPermissions()
+}
+
+func (u *rolesUsecase) InsertRole(req *roles.RoleReq) (*roles.Role, error) {
+	roleId, err := u.rolesRepository.InsertRole(req)
+	if err != nil {
+		return nil, err
+	}
+	u.reload()
+
+	return u.rolesRepository.FindOneRole(roleId)
+}
+
+func (u *rolesUsecase) UpdateRole(req *roles.RoleReq) (*roles.Role, error) {
+	if err := u.rolesRepository.UpdateRole(req); err != nil {
+		return nil, err
+	}
+	u.reload()
+
+	return u.rolesRepository.FindOneRole(req.ID)
+}
+
+func (u *rolesUsecase) DeleteRole(roleId int) error {
+	switch roleId {
+	case middlewares.RoleUser, middlewares.RoleAdmin, middlewares.RoleStaff:
+		return ErrRoleBuiltIn
+	}
+
+	if err := u.rolesRepository.DeleteRole(roleId); err != nil {
+		return err
+	}
+	u.reload()
+
+	return nil
+}
+
+func (u *rolesUsecase) UpdateUserRole(req *roles.UserRoleReq) error {
+	if _, err := u.rolesRepository.FindOneRole(req.RoleID); err != nil {
+		return err
+	}
+
+	return u.rolesRepository.UpdateUserRole(req)
+}
@@ -2,12 +2,16 @@ package servers
 
 import (
 	"github.com/korvised/go-ecommerce/modules/files/filesHandlers"
+	"github.com/korvised/go-ecommerce/modules/files/filesRepositories"
 	"github.com/korvised/go-ecommerce/modules/files/filesUsecases"
 	"github.com/korvised/go-ecommerce/modules/middlewares"
+	"log"
+	"time"
 )
 
 type IFileModule interface {
 	Init()
+	SweepJob()
 	Usecase() filesUsecases.IFilesUsecase
 	Handler() filesHandlers.IFilesHandler
 }
@@ -19,7 +23,8 @@ type fileModule struct {
 }
 
 func (m *moduleFactory) FilesModule() IFileModule {
-	usecase := filesUsecases.FilesUsecase(m.s.cfg)
+	repository := filesRepositories.FilesRepository(m.s.db)
+	usecase := filesUsecases.FilesUsecase(m.s.cfg, m.s.storage, m.s.scanner, repository)
 	handler := filesHandlers.FilesHandler(m.s.cfg, usecase)
 
 	return &fileModule{
@@ -32,8 +37,45 @@ func (m *moduleFactory) FilesModule() IFileModule {
 func (f *fileModule) Init() {
 	router := f.r.Group("/files")
 
-	router.Post("/upload", f.mid.JwtAuth(), f.mid.Authorize(middlewares.RoleAdmin), f.handler.UploadFile)
-	router.Patch("/delete", f.mid.JwtAuth(), f.mid.Authorize(middlewares.RoleAdmin), f.handler.DeleteFile)
+	router.Post("/upload", f.mid.JwtAuth(), f.mid.Authorize(middlewares.PermFilesWrite), f.handler.UploadFile)
+	router.Patch("/delete", f.mid.JwtAuth(), f.mid.Authorize(middlewares.PermFilesWrite), f.handler.DeleteFile)
+
+	// Signed urls of private files, the signature replaces authentication
+	router.Get("/private/*", f.handler.DownloadPrivateFile)
+
+	// Resumable uploads, tus protocol 1.0.0
+	tus := router.Group("/tus")
+	tus.Options("/", f.handler.TusOptions)
+	tus.Post("/", f.mid.JwtAuth(), f.mid.Authorize(middlewares.PermFilesWrite), f.handler.TusResumable(), f.handler.CreateUpload)
+	tus.Head("/:upload_id", f.mid.JwtAuth(), f.mid.Authorize(middlewares.PermFilesWrite), f.handler.TusResumable(), f.handler.HeadUpload)
+	tus.Patch("/:upload_id", f.mid.JwtAuth(), f.mid.Authorize(middlewares.PermFilesWrite), f.handler.TusResumable(), f.handler.AppendUpload)
+	tus.Delete("/:upload_id", f.mid.JwtAuth(), f.mid.Authorize(middlewares.PermFilesWrite), f.handler.TusResumable(), f.handler.DeleteUpload)
+	tus.Get("/:upload_id", f.mid.JwtAuth(), f.mid.Authorize(middlewares.PermFilesWrite), f.handler.FindOneUpload)
+}
+
+// SweepJob deletes uploaded files which are no longer referenced after the
+// grace period and expired resumable uploads, it blocks so run it in a goroutine.
+func (f *fileModule) SweepJob() {
+	ticker := time.NewTicker(f.s.cfg.Storage().SweepInterval())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		before := time.Now().Add(-f.s.cfg.Storage().OrphanGrace())
+
+		count, err := f.usecase.SweepOrphanFiles(before)
+		if err != nil {
+			log.Printf("sweep orphan files failed: %v", err)
+		} else if count > 0 {
+			log.Printf("swept %d orphan files", count)
+		}
+
+		count, err = f.usecase.SweepExpiredUploads()
+		if err != nil {
+			log.Printf("sweep expired uploads failed: %v", err)
+		} else if count > 0 {
+			log.Printf("swept %d expired uploads", count)
+		}
+	}
 }
 
 func (f *fileModule) Usecase() filesUsecases.IFilesUsecase { return f.usecase }
@@ -5,10 +5,14 @@ import (
 	"github.com/korvised/go-ecommerce/modules/products/productsHandlers"
 	"github.com/korvised/go-ecommerce/modules/products/productsRepositories"
 	"github.com/korvised/go-ecommerce/modules/products/productsUsecases"
+	"log"
+	"time"
 )
 
 type IProductModule interface {
 	Init()
+	PurgeJob()
+	FailUnfinishedImports()
 	Repository() productsRepositories.IProductsRepository
 	Usecase() productsUsecases.IProductsUsecase
 	Handler() productsHandlers.IProductsHandler
@@ -23,11 +27,12 @@ type productModule struct {
 
 func (m *moduleFactory) ProductsModule() IProductModule {
 	repository := productsRepositories.ProductsRepository(m.s.db, m.s.cfg, m.FilesModule().Usecase())
-	usecase := productsUsecases.ProductsUsecase(repository)
+	usecase := productsUsecases.ProductsUsecase(repository, m.FilesModule().Usecase())
 	handler := productsHandlers.ProductsHandler(m.s.cfg, usecase, m.FilesModule().Usecase())
 
 	return &productModule{
 		moduleFactory: m,
+		repository:    repository,
 		usecase:       usecase,
 		handler:       handler,
 	}
@@ -37,14 +42,61 @@ func (p *productModule) Init() {
 
 	router := p.r.Group("/products")
 
-	router.Post("/", p.mid.JwtAuth(), p.mid.Authorize(middlewares.RoleAdmin), p.handler.AddProduct)
+	router.Post("/", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsWrite), p.handler.AddProduct)
+	router.Post("/imports", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsWrite), p.handler.ImportProducts)
 
-	router.Patch("/:product_id", p.mid.JwtAuth(), p.mid.Authorize(middlewares.RoleAdmin), p.handler.UpdateProduct)
+	router.Patch("/:product_id", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsWrite), p.handler.UpdateProduct)
 
-	router.Get("/", p.mid.ApiKeyAuth(), p.handler.FindManyProducts)
-	router.Get("/:product_id", p.mid.ApiKeyAuth(), p.handler.FindOneProduct)
+	router.Get("/imports/:import_id", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsReadAny), p.handler.FindOneProductImport)
+	router.Get("/imports/:import_id/report", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsReadAny), p.handler.DownloadProductImportReport)
 
-	router.Delete("/:product_id", p.mid.JwtAuth(), p.mid.Authorize(middlewares.RoleAdmin), p.handler.DeleteProduct)
+	router.Get("/", p.mid.ApiKeyAuth(), p.mid.OptionalJwtAuth(), p.handler.FindManyProducts)
+	router.Get("/:product_id", p.mid.ApiKeyAuth(), p.mid.OptionalJwtAuth(), p.handler.FindOneProduct)
+	router.Get("/:product_id/price-history", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsReadAny), p.handler.FindPriceHistory)
+
+	router.Patch("/:product_id/restore", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsWrite), p.handler.RestoreProduct)
+
+	router.Post("/:product_id/images", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsWrite), p.handler.AddImage)
+	router.Patch("/:product_id/images/order", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsWrite), p.handler.ReorderImages)
+	router.Patch("/:product_id/images/:image_id", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsWrite), p.handler.UpdateImage)
+	router.Delete("/:product_id/images/:image_id", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsWrite), p.handler.RemoveImage)
+
+	router.Delete("/:product_id", p.mid.JwtAuth(), p.mid.Authorize(middlewares.PermProductsWrite), p.handler.DeleteProduct)
+}
+
+// PurgeJob permanently removes products that were soft deleted longer than
+// the configured retention, it blocks so run it in a goroutine.
+func (p *productModule) PurgeJob() {
+	ticker := time.NewTicker(p.s.cfg.App().ProductPurgeInterval())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		before := time.Now().Add(-p.s.cfg.App().ProductPurgeAfter())
+
+		count, err := p.usecase.PurgeDeletedProducts(before)
+		if err != nil {
+			log.Printf("purge products failed: %v", err)
+			continue
+		}
+
+		if count > 0 {
+			log.Printf("purged %d deleted products", count)
+		}
+	}
+}
+
+// FailUnfinishedImports fails the imports interrupted by the last shutdown,
+// the api runs imports in process so only one instance may serve imports
+func (p *productModule) FailUnfinishedImports() {
+	count, err := p.usecase.FailUnfinishedImports()
+	if err != nil {
+		log.Printf("fail unfinished products imports failed: %v", err)
+		return
+	}
+
+	if count > 0 {
+		log.Printf("failed %d unfinished products imports", count)
+	}
 }
 
 func (p *productModule) Repository() productsRepositories.IProductsRepository { return p.repository }
@@ -2,10 +2,12 @@ package servers
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/korvised/go-ecommerce/modules/addresses/addressesHandlers"
+	"github.com/korvised/go-ecommerce/modules/addresses/addressesRepositories"
+	"github.com/korvised/go-ecommerce/modules/addresses/addressesUsecases"
 	"github.com/korvised/go-ecommerce/modules/appinfo/appinfoHandlers"
 	"github.com/korvised/go-ecommerce/modules/appinfo/appinfoRepositories"
 	"github.com/korvised/go-ecommerce/modules/appinfo/appinfoUsecases"
-	"github.com/korvised/go-ecommerce/modules/files/filesUsecases"
 	"github.com/korvised/go-ecommerce/modules/middlewares"
 	"github.com/korvised/go-ecommerce/modules/middlewares/middlewaresHandlers"
 	"github.com/korvised/go-ecommerce/modules/middlewares/middlewaresRepositories"
@@ -15,9 +17,15 @@ import (
 	"github.com/korvised/go-ecommerce/modules/orders/ordersRepositories"
 	"github.com/korvised/go-ecommerce/modules/orders/ordersUsecases"
 	"github.com/korvised/go-ecommerce/modules/products/productsRepositories"
+	"github.com/korvised/go-ecommerce/modules/roles/rolesHandlers"
+	"github.com/korvised/go-ecommerce/modules/roles/rolesRepositories"
+	"github.com/korvised/go-ecommerce/modules/roles/rolesUsecases"
 	"github.com/korvised/go-ecommerce/modules/users/userHandlers"
+	"github.com/korvised/go-ecommerce/modules/users/userLockouts"
+	"github.com/korvised/go-ecommerce/modules/users/userOidc"
 	"github.com/korvised/go-ecommerce/modules/users/userRepositories"
 	"github.com/korvised/go-ecommerce/modules/users/userUsecases"
+	"log"
 )
 
 type IModuleFactory interface {
@@ -27,6 +35,8 @@ type IModuleFactory interface {
 	FilesModule() IFileModule
 	ProductsModule() IProductModule
 	OrdersModule()
+	RolesModule()
+	AddressesModule()
 }
 
 type moduleFactory struct {
@@ -56,20 +66,62 @@ func (m *moduleFactory) MonitorModule() {
 }
 
 func (m *moduleFactory) UsersModule() {
+	lockouts, err := usersLockouts.LockoutStore(m.s.cfg, m.s.db)
+	if err != nil {
+		log.Fatalf("init lockout store failed: %v", err)
+	}
+
 	repository := usersRepositories.UsersRepository(m.s.db)
-	usecase := usersUsecases.UsersUsecase(m.s.cfg, repository)
+	oidc := usersOidc.OidcClient(m.s.cfg.Oidc())
+	usecase := usersUsecases.UsersUsecase(m.s.cfg, repository, m.s.mailer, lockouts, oidc, m.FilesModule().Usecase())
 	handler := usersHandlers.UsersHandler(m.s.cfg, usecase)
 
 	router := m.r.Group("/users")
 
 	router.Post("/signup", m.mid.ApiKeyAuth(), handler.SignUpCustomer)
 	router.Post("/signin", m.mid.ApiKeyAuth(), handler.SignIn)
+	router.Post("/signin/2fa", m.mid.ApiKeyAuth(), handler.SignInTwoFactor)
+	router.Post("/signin/2fa/enrol", m.mid.ApiKeyAuth(), handler.SignInEnrolTotp)
+	router.Get("/oidc/:provider", m.mid.ApiKeyAuth(), handler.OidcAuthorize)
+	router.Post("/oidc/:provider/signin", m.mid.ApiKeyAuth(), handler.OidcSignIn)
 	router.Post("/refresh", m.mid.ApiKeyAuth(), handler.RefreshPassport)
-	router.Post("/signout", m.mid.ApiKeyAuth(), handler.SingOut)
+	router.Post("/signout", m.mid.ApiKeyAuth(), m.mid.JwtAuth(), handler.SingOut)
 	router.Post("/signup-admin", m.mid.ApiKeyAuth(), handler.SignUpAdmin)
+	router.Post("/password/forgot", m.mid.ApiKeyAuth(), handler.ForgotPassword)
+	router.Post("/password/reset", m.mid.ApiKeyAuth(), handler.ResetPassword)
+	router.Post("/verify/resend", m.mid.ApiKeyAuth(), handler.ResendVerification)
+
+	// Opened from the verification mail, the signed token replaces the api key
+	router.Get("/verify", handler.VerifyEmail)
+
+	// Registered before /:user_id which would take it for a user id
+	router.Get("/admin", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.FindUsers)
 
 	router.Get("/:user_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.GetUserProfile)
-	router.Get("/admin/secret", m.mid.JwtAuth(), m.mid.Authorize(middlewares.RoleAdmin), handler.GenerateAdminToken)
+	router.Patch("/:user_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.UpdateUserProfile)
+	router.Post("/:user_id/password", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.ChangePassword)
+	router.Post("/:user_id/avatar", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.UploadAvatar)
+	router.Delete("/:user_id/avatar", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.DeleteAvatar)
+	router.Get("/:user_id/export", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.ExportUserData)
+	router.Delete("/:user_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.EraseUser)
+	router.Get("/:user_id/sessions", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.FindSessions)
+	router.Delete("/:user_id/sessions", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.RevokeAllSessions)
+	router.Delete("/:user_id/sessions/:oauth_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.RevokeSession)
+	router.Post("/:user_id/2fa", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.EnrolTotp)
+	router.Post("/:user_id/2fa/confirm", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.ConfirmTotp)
+	router.Delete("/:user_id/2fa", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.DisableTotp)
+	router.Post("/:user_id/2fa/recovery-codes", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.RegenerateRecoveryCodes)
+	router.Get("/admin/secret", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.GenerateAdminToken)
+	router.Post("/admin/unlock", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.UnlockUser)
+	router.Get("/admin/:user_id/sessions", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.FindSessions)
+	router.Delete("/admin/:user_id/sessions", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.RevokeAllSessions)
+	router.Get("/admin/:user_id/export", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.ExportUserData)
+	router.Delete("/admin/:user_id", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.AdminEraseUser)
+	router.Get("/admin/:user_id", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.FindOneAdminUser)
+	router.Post("/admin/:user_id/disable", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.DisableUser)
+	router.Post("/admin/:user_id/enable", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.EnableUser)
+	router.Post("/admin/:user_id/password/reset", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.ForcePasswordReset)
+	router.Post("/admin/:user_id/2fa/enrolment", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermUsersAdmin), handler.IssueTotpEnrolment)
 }
 
 func (m *moduleFactory) AppinfoModule() {
@@ -79,26 +131,62 @@ func (m *moduleFactory) AppinfoModule() {
 
 	router := m.r.Group("/appinfo")
 
-	router.Get("/apikey", m.mid.JwtAuth(), m.mid.Authorize(middlewares.RoleAdmin), handler.GenerateApiKey)
+	router.Get("/apikey", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermApiKeysWrite), handler.GenerateApiKey)
 	router.Get("/categories", m.mid.ApiKeyAuth(), handler.FindCategories)
-	router.Post("/categories", m.mid.JwtAuth(), m.mid.Authorize(middlewares.RoleAdmin), handler.AddCategories)
-	router.Delete("/categories/:category_id", m.mid.JwtAuth(), m.mid.Authorize(middlewares.RoleAdmin), handler.DeleteCategory)
+	router.Post("/categories", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermCategoriesWrite), handler.AddCategories)
+	router.Delete("/categories/:category_id", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermCategoriesWrite), handler.DeleteCategory)
+
+	// Served from the root where jwt libraries look for it
+	m.s.app.Get("/.well-known/jwks.json", handler.FindJwks)
 }
 
 func (m *moduleFactory) OrdersModule() {
-	fileUsecase := filesUsecases.FilesUsecase(m.s.cfg)
+	fileUsecase := m.FilesModule().Usecase()
 	productsRepository := productsRepositories.ProductsRepository(m.s.db, m.s.cfg, fileUsecase)
 
+	addressesRepository := addressesRepositories.AddressesRepository(m.s.db)
+
 	repository := ordersRepositories.OrdersRepository(m.s.db)
-	usecase := ordersUsecases.OrdersUsecase(repository, productsRepository)
+	usecase := ordersUsecases.OrdersUsecase(repository, productsRepository, addressesRepository, fileUsecase)
 	handler := ordersHandlers.OrdersHandler(m.s.cfg, usecase)
 
 	router := m.r.Group("/orders")
 
-	router.Post("/", m.mid.JwtAuth(), handler.InsertOrder)
+	router.Post("/", m.mid.JwtAuth(), m.mid.RequireVerified(m.s.cfg.App().VerifiedOrders()), handler.InsertOrder)
 
 	router.Patch("/:order_id", m.mid.JwtAuth(), handler.UpdateOrder)
 
-	router.Get("/", m.mid.JwtAuth(), m.mid.Authorize(middlewares.RoleAdmin), handler.FindManyOrders)
+	router.Get("/", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermOrdersReadAny), handler.FindManyOrders)
 	router.Get("/:order_id", m.mid.JwtAuth(), handler.FindOneOrder)
 }
+
+func (m *moduleFactory) RolesModule() {
+	repository := rolesRepositories.RolesRepository(m.s.db)
+	usecase := rolesUsecases.RolesUsecase(repository, m.mid.ReloadPermissions)
+	handler := rolesHandlers.RolesHandler(m.s.cfg, usecase)
+
+	router := m.r.Group("/roles")
+
+	router.Get("/", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermRolesWrite), handler.FindRoles)
+	router.Get("/permissions", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermRolesWrite), handler.FindPermissions)
+	router.Post("/", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermRolesWrite), handler.InsertRole)
+	router.Patch("/:role_id", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermRolesWrite), handler.UpdateRole)
+	router.Delete("/:role_id", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermRolesWrite), handler.DeleteRole)
+	router.Patch("/users/:user_id", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermRolesWrite), handler.UpdateUserRole)
+
+	// Also served next to the admin user endpoints
+	m.r.Patch("/users/admin/:user_id/role", m.mid.JwtAuth(), m.mid.Authorize(middlewares.PermRolesWrite), handler.UpdateUserRole)
+}
+
+func (m *moduleFactory) AddressesModule() {
+	repository := addressesRepositories.AddressesRepository(m.s.db)
+	usecase := addressesUsecases.AddressesUsecase(repository)
+	handler := addressesHandlers.AddressesHandler(m.s.cfg, usecase)
+
+	router := m.r.Group("/users/:user_id/addresses")
+
+	router.Get("/", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.FindAddresses)
+	router.Post("/", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.InsertAddress)
+	router.Patch("/:address_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.UpdateAddress)
+	router.Delete("/:address_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.DeleteAddress)
+}
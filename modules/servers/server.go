@@ -6,9 +6,13 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/jmoiron/sqlx"
 	"github.com/korvised/go-ecommerce/config"
+	"github.com/korvised/go-ecommerce/modules/files/filesScanners"
+	"github.com/korvised/go-ecommerce/modules/files/filesStorages"
+	"github.com/korvised/go-ecommerce/pkg/mailer"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 )
 
 type IServer interface {
@@ -17,15 +21,36 @@ type IServer interface {
 }
 
 type server struct {
-	app *fiber.App
-	cfg config.IConfig
-	db  *sqlx.DB
+	app     *fiber.App
+	cfg     config.IConfig
+	db      *sqlx.DB
+	storage filesStorages.IFilesStorage
+	scanner filesScanners.IFilesScanner
+	mailer  mailer.IMailer
 }
 
 func NewServer(cfg config.IConfig, db *sqlx.DB) IServer {
+	storage, err := filesStorages.FilesStorage(cfg)
+	if err != nil {
+		log.Fatalf("init storage failed: %v", err)
+	}
+
+	scanner, err := filesScanners.FilesScanner(cfg)
+	if err != nil {
+		log.Fatalf("init scanner failed: %v", err)
+	}
+
+	mail, err := mailer.NewMailer(cfg.Mail())
+	if err != nil {
+		log.Fatalf("init mailer failed: %v", err)
+	}
+
 	return &server{
-		cfg: cfg,
-		db:  db,
+		cfg:     cfg,
+		db:      db,
+		storage: storage,
+		scanner: scanner,
+		mailer:  mail,
 		app: fiber.New(fiber.Config{
 			AppName:      cfg.App().Name(),
 			BodyLimit:    cfg.App().BodyLimit(),
@@ -43,8 +68,16 @@ func (s *server) Start() {
 	s.app.Use(middlewares.Logger())
 	s.app.Use(middlewares.Cor())
 
-	// Serve static assets from the "assets" folder
-	s.app.Static("/public", "./assets/images")
+	// Serve uploaded files when they are stored on local disk
+	if s.storage.Driver() == config.StorageLocal {
+		s.app.Static("/public", s.cfg.Storage().LocalDir(), fiber.Static{
+			// Private files are served by /v1/files/private with a signed url only
+			Next: func(c *fiber.Ctx) bool {
+				// fasthttp path is decoded and normalized like the file server sees it
+				return s.cfg.Storage().IsPrivate(strings.TrimPrefix(string(c.Context().Path()), "/public"))
+			},
+		})
+	}
 
 	// Modules
 	v1 := s.app.Group("v1")
@@ -54,9 +87,16 @@ func (s *server) Start() {
 	modules.MonitorModule()
 	modules.UsersModule()
 	modules.AppinfoModule()
-	modules.FilesModule().Init()
-	modules.ProductsModule().Init()
+	filesModule := modules.FilesModule()
+	filesModule.Init()
+	go filesModule.SweepJob()
+	productsModule := modules.ProductsModule()
+	productsModule.Init()
+	productsModule.FailUnfinishedImports()
+	go productsModule.PurgeJob()
 	modules.OrdersModule()
+	modules.RolesModule()
+	modules.AddressesModule()
 
 	s.app.Use(middlewares.RouterCheck())
 
@@ -1,16 +1,69 @@
 package users
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/korvised/go-ecommerce/modules/entities"
 	"golang.org/x/crypto/bcrypt"
 	"regexp"
+	"strings"
 )
 
 type User struct {
-	ID       string `db:"id" json:"id"`
-	Email    string `db:"email" json:"email"`
-	Username string `db:"username" json:"username"`
-	RoleID   int    `db:"role_id" json:"role_id"`
+	ID           string  `db:"id" json:"id"`
+	Email        string  `db:"email" json:"email"`
+	Username     string  `db:"username" json:"username"`
+	RoleID       int     `db:"role_id" json:"role_id"`
+	Verified     bool    `db:"verified" json:"verified"`
+	PendingEmail *string `db:"pending_email" json:"pending_email,omitempty"` // until the new address is confirmed
+	DisplayName  *string `db:"display_name" json:"display_name,omitempty"`
+	Phone        *string `db:"phone" json:"phone,omitempty"`
+	AvatarUrl    *string `db:"avatar_url" json:"avatar_url,omitempty"`
+}
+
+// UserUpdateReq changes the fields that are set, an empty display name or
+// phone clears it. A new email has to be verified again.
+type UserUpdateReq struct {
+	Username        *string `json:"username" form:"username"`
+	Email           *string `json:"email" form:"email"`
+	DisplayName     *string `json:"display_name" form:"display_name"`
+	Phone           *string `json:"phone" form:"phone"`
+	CurrentPassword string  `json:"current_password" form:"current_password"` // required to change the email
+	IP              string  `json:"-" form:"-"`
+}
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 -]{5,19}$`)
+
+func (obj *UserUpdateReq) Validate() error {
+	if obj.Username != nil && strings.TrimSpace(*obj.Username) == "" {
+		return fmt.Errorf("username is required")
+	}
+	if obj.Email != nil && !(&UserRegisterReq{Email: *obj.Email}).IsEmail() {
+		return fmt.Errorf("email pattern is invalid")
+	}
+	if obj.DisplayName != nil && len(*obj.DisplayName) > 100 {
+		return fmt.Errorf("display name must be at most 100 characters")
+	}
+	if obj.Phone != nil && *obj.Phone != "" && !phonePattern.MatchString(*obj.Phone) {
+		return fmt.Errorf("phone pattern is invalid")
+	}
+
+	return nil
+}
+
+type ChangePasswordReq struct {
+	CurrentPassword string `json:"current_password" form:"current_password"`
+	NewPassword     string `json:"new_password" form:"new_password"`
+	IP              string `json:"-" form:"-"`
+}
+
+func (obj *ChangePasswordReq) BcryptHashing() error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(obj.NewPassword), 10)
+	if err != nil {
+		return fmt.Errorf("hash password failed: %v", err)
+	}
+	obj.NewPassword = string(hashedPassword)
+	return nil
 }
 
 type UserRegisterReq struct {
@@ -20,16 +73,22 @@ type UserRegisterReq struct {
 }
 
 type UserCredential struct {
-	Email    string `db:"email" json:"email" form:"email"`
-	Password string `db:"password" json:"password" form:"password"`
+	Email     string `db:"email" json:"email" form:"email"`
+	Password  string `db:"password" json:"password" form:"password"`
+	Device    string `json:"device" form:"device"` // optional name the session is listed under
+	IP        string `json:"-" form:"-"`           // client address, failed sign-ins are counted per ip
+	UserAgent string `json:"-" form:"-"`
 }
 
 type UserCredentialCheck struct {
-	ID       string `db:"id"`
-	Email    string `db:"email"`
-	Password string `db:"password"`
-	Username string `db:"username"`
-	RoleID   int    `db:"role_id"`
+	ID          string `db:"id"`
+	Email       string `db:"email"`
+	Password    string `db:"password"`
+	Username    string `db:"username"`
+	RoleID      int    `db:"role_id"`
+	Verified    bool   `db:"verified"`
+	TotpEnabled bool   `db:"totp_enabled"`
+	Disabled    bool   `db:"disabled"`
 }
 
 func (obj *UserRegisterReq) BcryptHashing() error {
@@ -50,9 +109,13 @@ func (obj *UserRegisterReq) IsEmail() bool {
 	return match
 }
 
+// UserPassport is the result of a sign-in, with two-factor only Challenge is
+// set and the passport comes from the second step
 type UserPassport struct {
-	User  *User      `json:"user"`
-	Token *UserToken `json:"token"`
+	User          *User               `json:"user"`
+	Token         *UserToken          `json:"token"`
+	Challenge     *TwoFactorChallenge `json:"challenge,omitempty"`
+	RecoveryCodes []string            `json:"recovery_codes,omitempty"` // once, when enrolled while signing in
 }
 
 type UserToken struct {
@@ -68,6 +131,8 @@ type UserClaims struct {
 
 type UserRefreshCredential struct {
 	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	IP           string `json:"-" form:"-"`
+	UserAgent    string `json:"-" form:"-"`
 }
 
 type Oauth struct {
@@ -75,6 +140,174 @@ type Oauth struct {
 	UserID string `db:"user_id" json:"user_id"`
 }
 
+// UserRemoveCredential signs out one session of the user, the current one
+// when OauthID is empty
 type UserRemoveCredential struct {
 	OauthID string `db:"id" json:"oauth_id" form:"oauth_id"`
 }
+
+// Session is an oauth row as its user sees it, tokens are never listed
+type Session struct {
+	ID         string `db:"id" json:"id"`
+	Device     string `db:"device" json:"device"`
+	IP         string `db:"ip" json:"ip"`
+	UserAgent  string `db:"user_agent" json:"user_agent"`
+	Current    bool   `db:"-" json:"current"` // the session of the request
+	LastUsedAt string `db:"last_used_at" json:"last_used_at"`
+	CreatedAt  string `db:"created_at" json:"created_at"`
+}
+
+type ForgotPasswordReq struct {
+	Email string `json:"email" form:"email"`
+}
+
+type ResetPasswordReq struct {
+	Token    string `json:"token" form:"token"`
+	Password string `json:"password" form:"password"`
+}
+
+func (obj *ResetPasswordReq) BcryptHashing() error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(obj.Password), 10)
+	if err != nil {
+		return fmt.Errorf("hash password failed: %v", err)
+	}
+	obj.Password = string(hashedPassword)
+	return nil
+}
+
+type ResendVerificationReq struct {
+	Email string `json:"email" form:"email"`
+}
+
+// UnlockReq clears the failed sign-ins of an account, an ip or both
+type UnlockReq struct {
+	UserID string `json:"user_id" form:"user_id"`
+	IP     string `json:"ip" form:"ip"`
+}
+
+type UserTotp struct {
+	Secret   *string `db:"totp_secret"`
+	Enabled  bool    `db:"totp_enabled"`
+	LastStep int64   `db:"totp_last_step"`
+}
+
+// TwoFactorChallenge is answered with a code at /users/signin/2fa, Enrolment
+// is set when it comes from an enrolment link and the code confirms it
+type TwoFactorChallenge struct {
+	Token     string         `json:"token"`
+	ExpiresAt string         `json:"expires_at"`
+	Enrolment *TotpEnrolment `json:"enrolment,omitempty"`
+}
+
+type TotpEnrolment struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"` // otpauth:// uri to render as a QR code
+}
+
+type TwoFactorReq struct {
+	ChallengeToken string `json:"challenge_token" form:"challenge_token"`
+	Code           string `json:"code" form:"code"` // totp code or recovery code
+	Device         string `json:"device" form:"device"`
+	IP             string `json:"-" form:"-"`
+	UserAgent      string `json:"-" form:"-"`
+}
+
+// TotpEnrolmentReq answers a mailed enrolment link, the password is still
+// required so the mailbox alone is not enough
+type TotpEnrolmentReq struct {
+	Token    string `json:"token" form:"token"`
+	Password string `json:"password" form:"password"`
+	IP       string `json:"-" form:"-"`
+}
+
+type TotpCodeReq struct {
+	Code string `json:"code" form:"code"` // totp code or recovery code
+	IP   string `json:"-" form:"-"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// OidcIdentity is a user of an identity provider, Subject is unique per provider
+type OidcIdentity struct {
+	Provider      string `db:"provider" json:"provider"`
+	Subject       string `db:"subject" json:"-"`
+	Email         string `db:"email" json:"email"`
+	EmailVerified bool   `db:"-" json:"-"`
+	Name          string `db:"-" json:"-"`
+}
+
+// OidcAuthorization starts a social sign-in, the browser is sent to Url and
+// the frontend keeps StateToken for the callback
+type OidcAuthorization struct {
+	Url        string `json:"url"`
+	StateToken string `json:"state_token"`
+	ExpiresAt  string `json:"expires_at"`
+}
+
+type OidcSignInReq struct {
+	Provider   string `json:"-" form:"-"`
+	Code       string `json:"code" form:"code"`
+	State      string `json:"state" form:"state"`
+	StateToken string `json:"state_token" form:"state_token"`
+	Device     string `json:"device" form:"device"`
+	IP         string `json:"-" form:"-"`
+	UserAgent  string `json:"-" form:"-"`
+}
+
+// DataExport is everything kept about a user, served as a download on a
+// data subject request
+type DataExport struct {
+	ExportedAt string          `json:"exported_at"`
+	Profile    *User           `json:"profile"`
+	Sessions   []*Session      `json:"sessions"`
+	Identities []*OidcIdentity `json:"identities"`
+	Addresses  json.RawMessage `json:"addresses"`
+	Orders     json.RawMessage `json:"orders"`
+	Files      []*ExportFile   `json:"files"`
+}
+
+type ExportFile struct {
+	StorageKey  string `db:"storage_key" json:"-"`
+	FileName    string `db:"filename" json:"filename"`
+	Url         string `db:"url" json:"url"` // signed and expiring for private files
+	ContentType string `db:"content_type" json:"content_type"`
+	Size        int64  `db:"size" json:"size"`
+	Private     bool   `db:"private" json:"private"`
+	CreatedAt   string `db:"created_at" json:"created_at"`
+}
+
+// EraseUserReq confirms erasing the own account with the password
+type EraseUserReq struct {
+	Password string `json:"password" form:"password"`
+	IP       string `json:"-" form:"-"`
+}
+
+// UserFilter searches the users for admins, the dates are YYYY-MM-DD
+type UserFilter struct {
+	Search    string `query:"search"` // id, email, username
+	RoleID    int    `query:"role_id"`
+	Status    string `query:"status"` // active | disabled | erased
+	StartDate string `query:"start_date"`
+	EndDate   string `query:"end_date"`
+	*entities.PaginationReq
+	*entities.SortReq
+}
+
+// AdminUser is a user as seen by admins, the order stats are only counted
+// when a single user is viewed
+type AdminUser struct {
+	*User
+	CreatedAt  string          `db:"created_at" json:"created_at"`
+	DisabledAt *string         `db:"disabled_at" json:"disabled_at"`
+	ErasedAt   *string         `db:"erased_at" json:"erased_at"`
+	Orders     *UserOrderStats `json:"orders,omitempty"`
+}
+
+type UserOrderStats struct {
+	Count       int     `db:"count" json:"count"`
+	Canceled    int     `db:"canceled" json:"canceled"`
+	TotalPaid   float64 `db:"total_paid" json:"total_paid"` // canceled orders excluded
+	LastOrderAt *string `db:"last_order_at" json:"last_order_at"`
+}
@@ -2,13 +2,26 @@ package usersHandlers
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/korvised/go-ecommerce/config"
 	"github.com/korvised/go-ecommerce/modules/entities"
+	"github.com/korvised/go-ecommerce/modules/files/filesScanners"
+	"github.com/korvised/go-ecommerce/modules/files/filesUsecases"
+	"github.com/korvised/go-ecommerce/modules/middlewares/middlewaresHandlers"
 	"github.com/korvised/go-ecommerce/modules/users"
+	"github.com/korvised/go-ecommerce/modules/users/userOidc"
+	"github.com/korvised/go-ecommerce/modules/users/userRepositories"
 	"github.com/korvised/go-ecommerce/modules/users/userUsecases"
 	"github.com/korvised/go-ecommerce/pkg/auth"
+	"github.com/korvised/go-ecommerce/pkg/imaging"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type userHandlersErrCode string
@@ -21,6 +34,35 @@ const (
 	signUpAdminErr        userHandlersErrCode = "users-005"
 	generateAdminTokenErr userHandlersErrCode = "users-006"
 	getUserProfileErr     userHandlersErrCode = "users-007"
+	forgotPasswordErr     userHandlersErrCode = "users-008"
+	resetPasswordErr      userHandlersErrCode = "users-009"
+	verifyEmailErr        userHandlersErrCode = "users-010"
+	resendVerificationErr userHandlersErrCode = "users-011"
+	signInLockedErr       userHandlersErrCode = "users-012"
+	unlockUserErr         userHandlersErrCode = "users-013"
+	findSessionsErr       userHandlersErrCode = "users-014"
+	revokeSessionErr      userHandlersErrCode = "users-015"
+	revokeAllSessionsErr  userHandlersErrCode = "users-016"
+	signInTwoFactorErr    userHandlersErrCode = "users-017"
+	enrolTotpErr          userHandlersErrCode = "users-018"
+	confirmTotpErr        userHandlersErrCode = "users-019"
+	disableTotpErr        userHandlersErrCode = "users-020"
+	recoveryCodesErr      userHandlersErrCode = "users-021"
+	oidcAuthorizeErr      userHandlersErrCode = "users-022"
+	oidcSignInErr         userHandlersErrCode = "users-023"
+	updateUserProfileErr  userHandlersErrCode = "users-024"
+	changePasswordErr     userHandlersErrCode = "users-025"
+	uploadAvatarErr       userHandlersErrCode = "users-026"
+	deleteAvatarErr       userHandlersErrCode = "users-027"
+	exportUserDataErr     userHandlersErrCode = "users-028"
+	eraseUserErr          userHandlersErrCode = "users-029"
+	findUsersErr          userHandlersErrCode = "users-030"
+	findOneAdminUserErr   userHandlersErrCode = "users-031"
+	disableUserErr        userHandlersErrCode = "users-032"
+	enableUserErr         userHandlersErrCode = "users-033"
+	forcePasswordResetErr userHandlersErrCode = "users-034"
+	signInEnrolTotpErr    userHandlersErrCode = "users-035"
+	issueTotpEnrolmentErr userHandlersErrCode = "users-036"
 )
 
 type IUsersHandler interface {
@@ -31,6 +73,35 @@ type IUsersHandler interface {
 	SingOut(c *fiber.Ctx) error
 	GenerateAdminToken(c *fiber.Ctx) error
 	GetUserProfile(c *fiber.Ctx) error
+	ForgotPassword(c *fiber.Ctx) error
+	ResetPassword(c *fiber.Ctx) error
+	VerifyEmail(c *fiber.Ctx) error
+	ResendVerification(c *fiber.Ctx) error
+	UnlockUser(c *fiber.Ctx) error
+	FindSessions(c *fiber.Ctx) error
+	RevokeSession(c *fiber.Ctx) error
+	RevokeAllSessions(c *fiber.Ctx) error
+	SignInTwoFactor(c *fiber.Ctx) error
+	SignInEnrolTotp(c *fiber.Ctx) error
+	IssueTotpEnrolment(c *fiber.Ctx) error
+	EnrolTotp(c *fiber.Ctx) error
+	ConfirmTotp(c *fiber.Ctx) error
+	DisableTotp(c *fiber.Ctx) error
+	RegenerateRecoveryCodes(c *fiber.Ctx) error
+	OidcAuthorize(c *fiber.Ctx) error
+	OidcSignIn(c *fiber.Ctx) error
+	UpdateUserProfile(c *fiber.Ctx) error
+	ChangePassword(c *fiber.Ctx) error
+	UploadAvatar(c *fiber.Ctx) error
+	DeleteAvatar(c *fiber.Ctx) error
+	ExportUserData(c *fiber.Ctx) error
+	EraseUser(c *fiber.Ctx) error
+	AdminEraseUser(c *fiber.Ctx) error
+	FindUsers(c *fiber.Ctx) error
+	FindOneAdminUser(c *fiber.Ctx) error
+	DisableUser(c *fiber.Ctx) error
+	EnableUser(c *fiber.Ctx) error
+	ForcePasswordReset(c *fiber.Ctx) error
 }
 
 type usersHandler struct {
@@ -122,9 +193,80 @@ func (h *usersHandler) SignIn(c *fiber.Ctx) error {
 		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(signInErr), err.Error()).Res()
 	}
 
+	req.IP = c.IP()
+	req.UserAgent = c.Get(fiber.HeaderUserAgent)
+
 	passport, err := h.usersUsecase.GetPassport(req)
 	if err != nil {
-		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(signInErr), err.Error()).Res()
+		var locked *usersUsecases.LockedError
+		switch {
+		case errors.As(err, &locked):
+			return lockedRes(c, locked)
+		case errors.Is(err, usersRepositories.ErrUserDisabled), errors.Is(err, usersUsecases.ErrTwoFactorRequired):
+			return entities.NewResponse(c).Error(fiber.StatusForbidden, string(signInErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(signInErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
+}
+
+// lockedRes answers every step that counts towards the sign-in lockout
+func lockedRes(c *fiber.Ctx, locked *usersUsecases.LockedError) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+	return entities.NewResponse(c).Error(fiber.StatusTooManyRequests, string(signInLockedErr), locked.Error()).Res()
+}
+
+// SignInTwoFactor is the second step of a sign-in answered with a challenge
+func (h *usersHandler) SignInTwoFactor(c *fiber.Ctx) error {
+	req := new(users.TwoFactorReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(signInTwoFactorErr), err.Error()).Res()
+	}
+
+	req.IP = c.IP()
+	req.UserAgent = c.Get(fiber.HeaderUserAgent)
+
+	passport, err := h.usersUsecase.SignInTwoFactor(req)
+	if err != nil {
+		var locked *usersUsecases.LockedError
+		switch {
+		case errors.As(err, &locked):
+			return lockedRes(c, locked)
+		case errors.Is(err, usersUsecases.ErrTwoFactorCodeInvalid):
+			return entities.NewResponse(c).Error(fiber.StatusUnauthorized, string(signInTwoFactorErr), err.Error()).Res()
+		case errors.Is(err, usersRepositories.ErrUserDisabled):
+			return entities.NewResponse(c).Error(fiber.StatusForbidden, string(signInTwoFactorErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(signInTwoFactorErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
+}
+
+// SignInEnrolTotp answers a mailed enrolment link, the challenge returned is
+// completed at /users/signin/2fa with the first code of the new secret
+func (h *usersHandler) SignInEnrolTotp(c *fiber.Ctx) error {
+	req := new(users.TotpEnrolmentReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(signInEnrolTotpErr), err.Error()).Res()
+	}
+
+	req.IP = c.IP()
+
+	passport, err := h.usersUsecase.SignInEnrolTotp(req)
+	if err != nil {
+		var locked *usersUsecases.LockedError
+		switch {
+		case errors.As(err, &locked):
+			return lockedRes(c, locked)
+		case errors.Is(err, usersRepositories.ErrUserDisabled):
+			return entities.NewResponse(c).Error(fiber.StatusForbidden, string(signInEnrolTotpErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(signInEnrolTotpErr), err.Error()).Res()
+		}
 	}
 
 	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
@@ -136,8 +278,14 @@ func (h *usersHandler) RefreshPassport(c *fiber.Ctx) error {
 		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(refreshPassportErr), err.Error()).Res()
 	}
 
+	req.IP = c.IP()
+	req.UserAgent = c.Get(fiber.HeaderUserAgent)
+
 	passport, err := h.usersUsecase.RefreshPassport(req)
 	if err != nil {
+		if errors.Is(err, usersUsecases.ErrRefreshTokenReused) {
+			return entities.NewResponse(c).Error(fiber.StatusUnauthorized, string(refreshPassportErr), err.Error()).Res()
+		}
 		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(refreshPassportErr), err.Error()).Res()
 	}
 
@@ -150,7 +298,12 @@ func (h *usersHandler) SingOut(c *fiber.Ctx) error {
 		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(signOutErr), err.Error()).Res()
 	}
 
-	if err := h.usersUsecase.DeleteOauth(req.OauthID); err != nil {
+	if req.OauthID == "" {
+		req.OauthID, _ = c.Locals(middlewaresHandlers.OauthID).(string)
+	}
+
+	userID, _ := c.Locals(middlewaresHandlers.UserID).(string)
+	if err := h.usersUsecase.DeleteOauth(userID, req.OauthID); err != nil {
 		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(signOutErr), err.Error()).Res()
 	}
 
@@ -175,3 +328,585 @@ func (h *usersHandler) GetUserProfile(c *fiber.Ctx) error {
 
 	return entities.NewResponse(c).Success(fiber.StatusOK, profile).Res()
 }
+
+func (h *usersHandler) ForgotPassword(c *fiber.Ctx) error {
+	req := new(users.ForgotPasswordReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(forgotPasswordErr), err.Error()).Res()
+	}
+
+	// Email validation
+	if !(&users.UserRegisterReq{Email: req.Email}).IsEmail() {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(forgotPasswordErr), "email pattern is invalid").Res()
+	}
+
+	if err := h.usersUsecase.ForgotPassword(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(forgotPasswordErr), err.Error()).Res()
+	}
+
+	// Same answer whether the email exists or not
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) ResetPassword(c *fiber.Ctx) error {
+	req := new(users.ResetPasswordReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(resetPasswordErr), err.Error()).Res()
+	}
+
+	if req.Token == "" {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(resetPasswordErr), "token is required").Res()
+	}
+	if len(req.Password) < 8 {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(resetPasswordErr), "password must be at least 8 characters").Res()
+	}
+
+	if err := h.usersUsecase.ResetPassword(req); err != nil {
+		if errors.Is(err, usersRepositories.ErrResetTokenInvalid) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(resetPasswordErr), err.Error()).Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(resetPasswordErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) VerifyEmail(c *fiber.Ctx) error {
+	token := strings.Trim(c.Query("token"), " ")
+	if token == "" {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(verifyEmailErr), "token is required").Res()
+	}
+
+	if err := h.usersUsecase.VerifyEmail(token); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(verifyEmailErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) ResendVerification(c *fiber.Ctx) error {
+	req := new(users.ResendVerificationReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(resendVerificationErr), err.Error()).Res()
+	}
+
+	if err := h.usersUsecase.ResendVerification(req); err != nil {
+		if errors.Is(err, usersUsecases.ErrVerificationThrottled) {
+			return entities.NewResponse(c).Error(fiber.StatusTooManyRequests, string(resendVerificationErr), err.Error()).Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(resendVerificationErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) UnlockUser(c *fiber.Ctx) error {
+	req := new(users.UnlockReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(unlockUserErr), err.Error()).Res()
+	}
+
+	if req.UserID == "" && req.IP == "" {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(unlockUserErr), "user id or ip is required").Res()
+	}
+
+	if err := h.usersUsecase.UnlockUser(req); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(unlockUserErr), "user not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(unlockUserErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+// FindSessions lists the sessions of the user in the path, it serves the user
+// behind ParamsCheck and admins behind the users:admin permission
+func (h *usersHandler) FindSessions(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+	currentOauthID, _ := c.Locals(middlewaresHandlers.OauthID).(string)
+
+	sessions, err := h.usersUsecase.FindSessions(userID, currentOauthID)
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(findSessionsErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, sessions).Res()
+}
+
+func (h *usersHandler) RevokeSession(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+	oauthID := strings.TrimSpace(c.Params("oauth_id"))
+
+	if err := h.usersUsecase.DeleteOauth(userID, oauthID); err != nil {
+		if errors.Is(err, usersRepositories.ErrOauthNotFound) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(revokeSessionErr), "session not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(revokeSessionErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+// RevokeAllSessions signs the user out everywhere, the current session included
+func (h *usersHandler) RevokeAllSessions(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	if err := h.usersUsecase.DeleteAllOauth(userID); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(revokeAllSessionsErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+// EnrolTotp returns a new secret, 2fa is not required until it is confirmed
+func (h *usersHandler) EnrolTotp(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	enrolment, err := h.usersUsecase.EnrolTotp(userID)
+	if err != nil {
+		if errors.Is(err, usersRepositories.ErrTotpEnabled) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(enrolTotpErr), err.Error()).Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(enrolTotpErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusCreated, enrolment).Res()
+}
+
+// IssueTotpEnrolment mails the user a link to enrol in 2fa without signing in
+func (h *usersHandler) IssueTotpEnrolment(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	if err := h.usersUsecase.IssueTotpEnrolment(userID); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(issueTotpEnrolmentErr), "user not found").Res()
+		case errors.Is(err, usersRepositories.ErrTotpEnabled):
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(issueTotpEnrolmentErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(issueTotpEnrolmentErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) ConfirmTotp(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	req := new(users.TotpCodeReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(confirmTotpErr), err.Error()).Res()
+	}
+	req.IP = c.IP()
+
+	codes, err := h.usersUsecase.ConfirmTotp(userID, req)
+	if err != nil {
+		return totpErrRes(c, confirmTotpErr, err)
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, codes).Res()
+}
+
+func (h *usersHandler) DisableTotp(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	req := new(users.TotpCodeReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(disableTotpErr), err.Error()).Res()
+	}
+	req.IP = c.IP()
+
+	if err := h.usersUsecase.DisableTotp(userID, req); err != nil {
+		return totpErrRes(c, disableTotpErr, err)
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	req := new(users.TotpCodeReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(recoveryCodesErr), err.Error()).Res()
+	}
+	req.IP = c.IP()
+
+	codes, err := h.usersUsecase.RegenerateRecoveryCodes(userID, req)
+	if err != nil {
+		return totpErrRes(c, recoveryCodesErr, err)
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, codes).Res()
+}
+
+func totpErrRes(c *fiber.Ctx, code userHandlersErrCode, err error) error {
+	var locked *usersUsecases.LockedError
+	switch {
+	case errors.As(err, &locked):
+		return lockedRes(c, locked)
+	case errors.Is(err, usersUsecases.ErrTwoFactorCodeInvalid):
+		return entities.NewResponse(c).Error(fiber.StatusUnauthorized, string(code), err.Error()).Res()
+	case errors.Is(err, usersUsecases.ErrTwoFactorRequired):
+		return entities.NewResponse(c).Error(fiber.StatusForbidden, string(code), err.Error()).Res()
+	case errors.Is(err, usersUsecases.ErrTotpNotEnabled),
+		errors.Is(err, usersUsecases.ErrTotpNotEnrolled),
+		errors.Is(err, usersRepositories.ErrTotpEnabled):
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(code), err.Error()).Res()
+	default:
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(code), err.Error()).Res()
+	}
+}
+
+// OidcAuthorize returns the url of the provider sign-in page, the frontend
+// keeps the state token until the provider redirects back with the code
+func (h *usersHandler) OidcAuthorize(c *fiber.Ctx) error {
+	provider := strings.ToLower(strings.TrimSpace(c.Params("provider")))
+
+	authorization, err := h.usersUsecase.OidcAuthorize(provider)
+	if err != nil {
+		if errors.Is(err, usersOidc.ErrProviderNotFound) {
+			return entities.NewResponse(c).Error(fiber.StatusNotFound, string(oidcAuthorizeErr), err.Error()).Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusBadGateway, string(oidcAuthorizeErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, authorization).Res()
+}
+
+func (h *usersHandler) OidcSignIn(c *fiber.Ctx) error {
+	req := new(users.OidcSignInReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(oidcSignInErr), err.Error()).Res()
+	}
+
+	req.Provider = strings.ToLower(strings.TrimSpace(c.Params("provider")))
+	req.IP = c.IP()
+	req.UserAgent = c.Get(fiber.HeaderUserAgent)
+
+	if req.Code == "" {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(oidcSignInErr), "code is required").Res()
+	}
+
+	passport, err := h.usersUsecase.OidcSignIn(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, usersOidc.ErrProviderNotFound):
+			return entities.NewResponse(c).Error(fiber.StatusNotFound, string(oidcSignInErr), err.Error()).Res()
+		case errors.Is(err, usersUsecases.ErrOidcEmailInUse), errors.Is(err, usersRepositories.ErrIdentityLinked):
+			return entities.NewResponse(c).Error(fiber.StatusConflict, string(oidcSignInErr), err.Error()).Res()
+		case errors.Is(err, usersRepositories.ErrUserDisabled), errors.Is(err, usersUsecases.ErrTwoFactorRequired):
+			return entities.NewResponse(c).Error(fiber.StatusForbidden, string(oidcSignInErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(oidcSignInErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
+}
+
+func (h *usersHandler) UpdateUserProfile(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	req := new(users.UserUpdateReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateUserProfileErr), err.Error()).Res()
+	}
+
+	for _, field := range []*string{req.Username, req.Email, req.DisplayName, req.Phone} {
+		if field != nil {
+			*field = strings.TrimSpace(*field)
+		}
+	}
+	if err := req.Validate(); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateUserProfileErr), err.Error()).Res()
+	}
+	req.IP = c.IP()
+
+	profile, err := h.usersUsecase.UpdateUserProfile(userID, req)
+	if err != nil {
+		var locked *usersUsecases.LockedError
+		if errors.As(err, &locked) {
+			return lockedRes(c, locked)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateUserProfileErr), "user not found").Res()
+		}
+		if errors.Is(err, usersUsecases.ErrPasswordInvalid) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateUserProfileErr), err.Error()).Res()
+		}
+		switch err.Error() {
+		case "username is already in used", "email is already in used":
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(updateUserProfileErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(updateUserProfileErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, profile).Res()
+}
+
+// ChangePassword keeps the session it is called from and revokes the others
+func (h *usersHandler) ChangePassword(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+	currentOauthID, _ := c.Locals(middlewaresHandlers.OauthID).(string)
+
+	req := new(users.ChangePasswordReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(changePasswordErr), err.Error()).Res()
+	}
+	req.IP = c.IP()
+
+	if len(req.NewPassword) < 8 {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(changePasswordErr), "password must be at least 8 characters").Res()
+	}
+
+	if err := h.usersUsecase.ChangePassword(userID, currentOauthID, req); err != nil {
+		var locked *usersUsecases.LockedError
+		switch {
+		case errors.As(err, &locked):
+			return lockedRes(c, locked)
+		case errors.Is(err, usersUsecases.ErrPasswordInvalid):
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(changePasswordErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(changePasswordErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) UploadAvatar(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(uploadAvatarErr), err.Error()).Res()
+	}
+
+	// Only images, which are re-encoded without metadata by the files module
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file.Filename), "."))
+	if !imaging.IsImage(ext) || !slices.Contains(h.cfg.Storage().AllowedTypes(usersUsecases.AvatarDestination), ext) {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(uploadAvatarErr), "file is not acceptable").Res()
+	}
+
+	if file.Size > int64(h.cfg.App().FileLimit()) {
+		maxMiB := int(math.Ceil(float64(h.cfg.App().FileLimit()) / math.Pow(1024, 2)))
+		return entities.NewResponse(c).Error(
+			fiber.StatusBadRequest,
+			string(uploadAvatarErr),
+			fmt.Sprintf("file size must less than than %d MiB", maxMiB),
+		).Res()
+	}
+
+	profile, err := h.usersUsecase.UploadAvatar(userID, file, ext)
+	if err != nil {
+		if errors.Is(err, imaging.ErrInvalidImage) ||
+			errors.Is(err, filesUsecases.ErrFileRejected) ||
+			errors.Is(err, filesScanners.ErrInfected) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(uploadAvatarErr), err.Error()).Res()
+		}
+		if errors.Is(err, filesScanners.ErrTooLargeToScan) {
+			return entities.NewResponse(c).Error(fiber.StatusRequestEntityTooLarge, string(uploadAvatarErr), err.Error()).Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(uploadAvatarErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, profile).Res()
+}
+
+func (h *usersHandler) DeleteAvatar(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	if err := h.usersUsecase.DeleteAvatar(userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(deleteAvatarErr), "user not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(deleteAvatarErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+// ExportUserData is downloaded as a json file, it serves the user behind
+// ParamsCheck and admins behind the users:admin permission
+func (h *usersHandler) ExportUserData(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	export, err := h.usersUsecase.ExportUserData(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(exportUserDataErr), "user not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(exportUserDataErr), err.Error()).Res()
+	}
+
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-export.json"`, userID))
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return entities.NewResponse(c).Success(fiber.StatusOK, export).Res()
+}
+
+func (h *usersHandler) EraseUser(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	req := new(users.EraseUserReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(eraseUserErr), err.Error()).Res()
+	}
+	req.IP = c.IP()
+
+	if err := h.usersUsecase.EraseUser(userID, req); err != nil {
+		return eraseErrRes(c, err)
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) AdminEraseUser(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	if err := h.usersUsecase.AdminEraseUser(userID); err != nil {
+		return eraseErrRes(c, err)
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func eraseErrRes(c *fiber.Ctx, err error) error {
+	var locked *usersUsecases.LockedError
+	switch {
+	case errors.As(err, &locked):
+		return lockedRes(c, locked)
+	case errors.Is(err, sql.ErrNoRows):
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(eraseUserErr), "user not found").Res()
+	case errors.Is(err, usersUsecases.ErrPasswordInvalid),
+		errors.Is(err, usersUsecases.ErrEraseAdmin),
+		errors.Is(err, usersRepositories.ErrUserErased):
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(eraseUserErr), err.Error()).Res()
+	default:
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(eraseUserErr), err.Error()).Res()
+	}
+}
+
+func (h *usersHandler) FindUsers(c *fiber.Ctx) error {
+	req := &users.UserFilter{
+		SortReq:       &entities.SortReq{},
+		PaginationReq: &entities.PaginationReq{},
+	}
+
+	if err := c.QueryParser(req); err != nil {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(findUsersErr), err.Error()).Res()
+	}
+
+	// Pagination
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.Size < 5 {
+		req.Size = 5
+	}
+	if req.Size > 100 {
+		req.Size = 100
+	}
+
+	// Sort, the column is written into the query so only mapped ones pass
+	orderByMap := map[string]string{
+		"id":         `u.id`,
+		"email":      `u.email`,
+		"username":   `u.username`,
+		"created_at": `u.created_at`,
+	}
+	if orderByMap[strings.ToLower(req.OrderBy)] == "" {
+		req.OrderBy = orderByMap["created_at"]
+	} else {
+		req.OrderBy = orderByMap[strings.ToLower(req.OrderBy)]
+	}
+
+	if strings.ToUpper(req.Sort) == "ASC" {
+		req.Sort = "ASC"
+	} else {
+		req.Sort = "DESC"
+	}
+
+	req.Search = strings.TrimSpace(req.Search)
+	req.Status = strings.ToLower(req.Status)
+	if req.Status != "" && req.Status != "active" && req.Status != "disabled" && req.Status != "erased" {
+		return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(findUsersErr), "status must be active, disabled or erased").Res()
+	}
+
+	// * Created between, format: YYYY-MM-DD
+	for _, date := range []*string{&req.StartDate, &req.EndDate} {
+		if *date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", *date); err != nil {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(findUsersErr), "date must be formatted as YYYY-MM-DD").Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, h.usersUsecase.FindUsers(req)).Res()
+}
+
+func (h *usersHandler) FindOneAdminUser(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	user, err := h.usersUsecase.FindOneAdminUser(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(findOneAdminUserErr), "user not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(findOneAdminUserErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, user).Res()
+}
+
+func (h *usersHandler) DisableUser(c *fiber.Ctx) error {
+	adminID := c.Locals(middlewaresHandlers.UserID).(string)
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	if err := h.usersUsecase.DisableUser(adminID, userID); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(disableUserErr), "user not found").Res()
+		case errors.Is(err, usersUsecases.ErrDisableSelf):
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(disableUserErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(disableUserErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) EnableUser(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	if err := h.usersUsecase.EnableUser(userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(enableUserErr), "user not found").Res()
+		}
+		return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(enableUserErr), err.Error()).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) ForcePasswordReset(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Params("user_id"))
+
+	if err := h.usersUsecase.ForcePasswordReset(userID); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(forcePasswordResetErr), "user not found").Res()
+		case errors.Is(err, usersRepositories.ErrUserErased):
+			return entities.NewResponse(c).Error(fiber.StatusBadRequest, string(forcePasswordResetErr), err.Error()).Res()
+		default:
+			return entities.NewResponse(c).Error(fiber.StatusInternalServerError, string(forcePasswordResetErr), err.Error()).Res()
+		}
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
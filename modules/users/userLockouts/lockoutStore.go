@@ -0,0 +1,60 @@
+package usersLockouts
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/korvised/go-ecommerce/config"
+	"time"
+)
+
+// Attempt is the failed sign-in state of a key, e.g. user:<email> or ip:<address>
+type Attempt struct {
+	Failures   int
+	RetryAfter time.Duration // zero unless locked
+}
+
+// ILockoutStore counts failed sign-ins, Fail increments the failures of key,
+// starting over once the last failure is older than window, and locks the key
+// for the duration lockFor returns.
+type ILockoutStore interface {
+	Find(ctx context.Context, key string) (*Attempt, error)
+	Fail(ctx context.Context, key string, window time.Duration, lockFor func(failures int) time.Duration) (*Attempt, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// LockoutStore creates the store selected by LOCKOUT_STORE
+func LockoutStore(cfg config.IConfig, db *sqlx.DB) (ILockoutStore, error) {
+	switch cfg.Lockout().Store() {
+	case config.LockoutPostgres:
+		return PostgresStore(db), nil
+	case config.LockoutMemory:
+		return MemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("lockout store %s is not supported", cfg.Lockout().Store())
+	}
+}
+
+// Backoff locks once failures reach threshold, doubling the lockout with
+// every further failure up to max
+func Backoff(threshold int, duration, max time.Duration) func(failures int) time.Duration {
+	return func(failures int) time.Duration {
+		if failures < threshold {
+			return 0
+		}
+
+		lock := duration
+		for i := threshold; i < failures && lock < max; i++ {
+			lock *= 2
+		}
+		if lock > max {
+			lock = max
+		}
+
+		return lock
+	}
+}
+
+func UserKey(email string) string { return "user:" + email }
+
+func IpKey(ip string) string { return "ip:" + ip }
@@ -0,0 +1,92 @@
+package usersLockouts
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type testBackoff struct {
+	failures int
+	expect   time.Duration
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []testBackoff{
+		{failures: 1, expect: 0},
+		{failures: 4, expect: 0},
+		{failures: 5, expect: time.Minute},
+		{failures: 6, expect: time.Minute * 2},
+		{failures: 7, expect: time.Minute * 4},
+		{failures: 9, expect: time.Minute * 15},
+		{failures: 100, expect: time.Minute * 15},
+	}
+
+	lockFor := Backoff(5, time.Minute, time.Minute*15)
+
+	for _, test := range tests {
+		if lock := lockFor(test.failures); lock != test.expect {
+			t.Errorf("failures %d: expect: %v, got: %v", test.failures, test.expect, lock)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := MemoryStore()
+	lockFor := Backoff(3, time.Minute, time.Hour)
+
+	for i := 1; i <= 2; i++ {
+		attempt, err := store.Fail(ctx, UserKey("user@example.com"), time.Hour, lockFor)
+		if err != nil {
+			t.Fatalf("expect: %v, got: %v", nil, err)
+		}
+		if attempt.Failures != i || attempt.RetryAfter != 0 {
+			t.Errorf("failure %d: expect: %s, got: %+v", i, "unlocked", attempt)
+		}
+	}
+
+	attempt, err := store.Fail(ctx, UserKey("user@example.com"), time.Hour, lockFor)
+	if err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+	if attempt.Failures != 3 || attempt.RetryAfter != time.Minute {
+		t.Errorf("expect: %v, got: %+v", time.Minute, attempt)
+	}
+
+	if attempt, _ = store.Find(ctx, UserKey("user@example.com")); attempt.RetryAfter <= 0 {
+		t.Errorf("expect: %s, got: %+v", "locked", attempt)
+	}
+
+	// Other keys are not affected
+	if attempt, _ = store.Find(ctx, IpKey("127.0.0.1")); attempt.Failures != 0 || attempt.RetryAfter != 0 {
+		t.Errorf("expect: %s, got: %+v", "no failures", attempt)
+	}
+
+	if err := store.Reset(ctx, UserKey("user@example.com")); err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+	if attempt, _ = store.Find(ctx, UserKey("user@example.com")); attempt.Failures != 0 || attempt.RetryAfter != 0 {
+		t.Errorf("expect: %s, got: %+v", "reset", attempt)
+	}
+}
+
+func TestMemoryStoreWindow(t *testing.T) {
+	ctx := context.Background()
+	store := MemoryStore()
+	lockFor := Backoff(2, time.Minute, time.Hour)
+
+	if _, err := store.Fail(ctx, IpKey("127.0.0.1"), time.Millisecond, lockFor); err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+	time.Sleep(time.Millisecond * 5)
+
+	// The first failure is older than the window, counting starts over
+	attempt, err := store.Fail(ctx, IpKey("127.0.0.1"), time.Millisecond, lockFor)
+	if err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+	if attempt.Failures != 1 || attempt.RetryAfter != 0 {
+		t.Errorf("expect: %s, got: %+v", "one failure", attempt)
+	}
+}
@@ -0,0 +1,72 @@
+package usersLockouts
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryAttempt struct {
+	failures     int
+	lastFailedAt time.Time
+	lockedUntil  time.Time
+}
+
+type memoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]*memoryAttempt
+}
+
+// MemoryStore keeps attempts in the process, use it for a single instance or in tests
+func MemoryStore() ILockoutStore {
+	return &memoryStore{
+		attempts: make(map[string]*memoryAttempt),
+	}
+}
+
+func (s *memoryStore) Find(ctx context.Context, key string) (*Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attempts[key]
+	if !ok {
+		return &Attempt{}, nil
+	}
+
+	return &Attempt{Failures: a.failures, RetryAfter: max(time.Until(a.lockedUntil), 0)}, nil
+}
+
+func (s *memoryStore) Fail(ctx context.Context, key string, window time.Duration, lockFor func(failures int) time.Duration) (*Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	a, ok := s.attempts[key]
+	if !ok || now.Sub(a.lastFailedAt) > window {
+		a = &memoryAttempt{}
+		s.attempts[key] = a
+	}
+
+	a.failures++
+	a.lastFailedAt = now
+
+	lock := lockFor(a.failures)
+	a.lockedUntil = now.Add(lock)
+
+	// Forget stale keys so the map does not grow with every ip
+	for k, v := range s.attempts {
+		if now.Sub(v.lastFailedAt) > window && now.After(v.lockedUntil) {
+			delete(s.attempts, k)
+		}
+	}
+
+	return &Attempt{Failures: a.failures, RetryAfter: lock}, nil
+}
+
+func (s *memoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,92 @@
+package usersLockouts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type postgresStore struct {
+	db *sqlx.DB
+}
+
+// PostgresStore shares attempts between instances through the login_attempts table
+func PostgresStore(db *sqlx.DB) ILockoutStore {
+	return &postgresStore{
+		db: db,
+	}
+}
+
+func (s *postgresStore) Find(ctx context.Context, key string) (*Attempt, error) {
+	// Durations are computed by the database, its clock set locked_until
+	query := `
+	SELECT
+		failures,
+		GREATEST(COALESCE(EXTRACT(EPOCH FROM (locked_until - now())), 0), 0)::FLOAT AS retry_after
+	FROM login_attempts
+	WHERE key = $1;`
+
+	var failures int
+	var retryAfter float64
+	if err := s.db.QueryRowxContext(ctx, query, key).Scan(&failures, &retryAfter); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &Attempt{}, nil
+		}
+		return nil, fmt.Errorf("find login attempt failed: %v", err)
+	}
+
+	return &Attempt{Failures: failures, RetryAfter: time.Duration(retryAfter * float64(time.Second))}, nil
+}
+
+func (s *postgresStore) Fail(ctx context.Context, key string, window time.Duration, lockFor func(failures int) time.Duration) (*Attempt, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	INSERT INTO login_attempts (key, failures, last_failed_at)
+	VALUES ($1, 1, now())
+	ON CONFLICT (key) DO UPDATE
+		SET failures       = CASE
+		                         WHEN login_attempts.last_failed_at < now() - $2::INT * INTERVAL '1 second' THEN 1
+		                         ELSE login_attempts.failures + 1
+		    END,
+		    last_failed_at = now()
+	RETURNING failures;`
+
+	attempt := new(Attempt)
+	if err := tx.QueryRowxContext(ctx, query, key, int64(window.Seconds())).Scan(&attempt.Failures); err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("insert login attempt failed: %v", err)
+	}
+
+	attempt.RetryAfter = lockFor(attempt.Failures)
+
+	query = `
+	UPDATE login_attempts
+	SET locked_until = now() + $2::INT * INTERVAL '1 second'
+	WHERE key = $1;`
+
+	if _, err := tx.ExecContext(ctx, query, key, int64(attempt.RetryAfter.Seconds())); err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("lock login attempt failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return attempt, nil
+}
+
+func (s *postgresStore) Reset(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = $1;`, key); err != nil {
+		return fmt.Errorf("delete login attempt failed: %v", err)
+	}
+
+	return nil
+}
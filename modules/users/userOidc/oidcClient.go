@@ -0,0 +1,306 @@
+package usersOidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korvised/go-ecommerce/config"
+	"github.com/korvised/go-ecommerce/modules/users"
+	"golang.org/x/oauth2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrProviderNotFound is returned for a provider missing from OIDC_PROVIDERS
+var ErrProviderNotFound = errors.New("identity provider not found")
+
+// IOidcClient runs the authorization code flow with pkce against the
+// configured providers and returns the identity the provider vouches for
+type IOidcClient interface {
+	AuthCodeUrl(ctx context.Context, provider, state, nonce, verifier string) (string, error)
+	Exchange(ctx context.Context, provider, code, verifier, nonce string) (*users.OidcIdentity, error)
+}
+
+type oidcClient struct {
+	cfg        config.IOidcConfig
+	httpClient *http.Client
+	mu         sync.Mutex
+	providers  map[string]*provider
+}
+
+func OidcClient(cfg config.IOidcConfig) IOidcClient {
+	return &oidcClient{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: time.Second * 10},
+		providers:  make(map[string]*provider),
+	}
+}
+
+// NewVerifier returns a random value for state, nonce or the pkce verifier
+func NewVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate verifier failed: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// provider finds the endpoints once, a failed discovery is retried on the
+// next sign-in so a provider outage at startup does not stick
+func (c *oidcClient) provider(ctx context.Context, name string) (*provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if p, ok := c.providers[name]; ok {
+		return p, nil
+	}
+
+	cfg, ok := c.cfg.Provider(name)
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+
+	p := &provider{
+		cfg:         cfg,
+		httpClient:  c.httpClient,
+		authUrl:     cfg.AuthUrl,
+		tokenUrl:    cfg.TokenUrl,
+		jwksUrl:     cfg.JwksUrl,
+		userinfoUrl: cfg.UserinfoUrl,
+	}
+	if cfg.Issuer != "" {
+		if err := p.discover(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	c.providers[name] = p
+	return p, nil
+}
+
+func (c *oidcClient) AuthCodeUrl(ctx context.Context, name, state, nonce, verifier string) (string, error) {
+	p, err := c.provider(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	return p.oauth2().AuthCodeURL(
+		state,
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), nil
+}
+
+// Exchange redeems the code, the identity comes from the id token and from
+// the userinfo endpoint for providers which do not issue one
+func (c *oidcClient) Exchange(ctx context.Context, name, code, verifier, nonce string) (*users.OidcIdentity, error) {
+	p, err := c.provider(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c.httpClient)
+	token, err := p.oauth2().Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code failed: %v", err)
+	}
+
+	var claims *identityClaims
+	if idToken, ok := token.Extra("id_token").(string); ok && idToken != "" {
+		if claims, err = p.verifyIdToken(ctx, idToken, nonce); err != nil {
+			return nil, err
+		}
+	} else if p.userinfoUrl != "" {
+		if claims, err = p.userinfo(ctx, token); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("provider %s returned no id token", name)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("provider %s returned no subject", name)
+	}
+
+	return &users.OidcIdentity{
+		Provider:      name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+type provider struct {
+	cfg         *config.OidcProvider
+	httpClient  *http.Client
+	authUrl     string
+	tokenUrl    string
+	jwksUrl     string
+	userinfoUrl string
+	keys        keySet
+}
+
+// discover fills the endpoints not set in config from the issuer metadata
+func (p *provider) discover(ctx context.Context) error {
+	metadata := &struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JwksUri               string `json:"jwks_uri"`
+		UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	}{}
+	if err := p.getJson(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", "", metadata); err != nil {
+		return fmt.Errorf("discover provider %s failed: %v", p.cfg.Name, err)
+	}
+
+	if metadata.Issuer != p.cfg.Issuer {
+		return fmt.Errorf("discover provider %s failed, issuer %s does not match", p.cfg.Name, metadata.Issuer)
+	}
+
+	for _, endpoint := range []struct {
+		value *string
+		found string
+	}{
+		{&p.authUrl, metadata.AuthorizationEndpoint},
+		{&p.tokenUrl, metadata.TokenEndpoint},
+		{&p.jwksUrl, metadata.JwksUri},
+		{&p.userinfoUrl, metadata.UserinfoEndpoint},
+	} {
+		if *endpoint.value == "" {
+			*endpoint.value = endpoint.found
+		}
+	}
+
+	return nil
+}
+
+func (p *provider) oauth2() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectUrl,
+		Scopes:       p.cfg.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.authUrl,
+			TokenURL: p.tokenUrl,
+		},
+	}
+}
+
+// identityClaims are the standard claims read from id tokens and userinfo
+type identityClaims struct {
+	Email         string    `json:"email"`
+	EmailVerified claimBool `json:"email_verified"`
+	Name          string    `json:"name"`
+	Nonce         string    `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// claimBool accepts "true" as well, some providers send email_verified as a string
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// verifyIdToken checks the signature, issuer, audience and nonce. HS256 id
+// tokens, as issued by LINE, are signed with the client secret.
+func (p *provider) verifyIdToken(ctx context.Context, idToken, nonce string) (*identityClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA", "HS256"}),
+	}
+	if p.cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(p.cfg.Issuer))
+	}
+
+	token, err := jwt.ParseWithClaims(idToken, &identityClaims{}, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			if p.cfg.ClientSecret == "" {
+				return nil, fmt.Errorf("signing method is invalid")
+			}
+			return []byte(p.cfg.ClientSecret), nil
+		}
+
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	}, options...)
+	if err != nil {
+		return nil, fmt.Errorf("verify id token failed: %v", err)
+	}
+
+	claims, ok := token.Claims.(*identityClaims)
+	if !ok {
+		return nil, fmt.Errorf("claims type is invalid")
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("verify id token failed, exp is required")
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("verify id token failed, nonce does not match")
+	}
+
+	return claims, nil
+}
+
+// userinfo reads the identity from providers without id tokens, facebook
+// names the subject id and sends no email_verified
+func (p *provider) userinfo(ctx context.Context, token *oauth2.Token) (*identityClaims, error) {
+	info := &struct {
+		identityClaims
+		ID string `json:"id"`
+	}{}
+	if err := p.getJson(ctx, p.userinfoUrl, token.AccessToken, info); err != nil {
+		return nil, fmt.Errorf("get userinfo failed: %v", err)
+	}
+
+	if info.Subject == "" {
+		info.Subject = info.ID
+	}
+
+	return &info.identityClaims, nil
+}
+
+func (p *provider) getJson(ctx context.Context, url, accessToken string, dest any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(dest)
+}
@@ -0,0 +1,277 @@
+package usersOidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korvised/go-ecommerce/config"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testOidcConfig struct {
+	providers map[string]*config.OidcProvider
+}
+
+func (c *testOidcConfig) Provider(name string) (*config.OidcProvider, bool) {
+	p, ok := c.providers[name]
+	return p, ok
+}
+
+func (c *testOidcConfig) Providers() []string { return nil }
+
+func (c *testOidcConfig) StateExpires() time.Duration { return time.Minute }
+
+// testIssuer serves discovery, jwks and token endpoints, the token endpoint
+// answers with idToken
+type testIssuer struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key failed: %v", err)
+	}
+	issuer := &testIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": "k1",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil ||
+			r.PostForm.Get("grant_type") != "authorization_code" ||
+			r.PostForm.Get("code") != "code-1" ||
+			r.PostForm.Get("code_verifier") != "verifier-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access-1",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     issuer.idToken,
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func (i *testIssuer) provider() *config.OidcProvider {
+	return &config.OidcProvider{
+		Name:         "test",
+		Issuer:       i.server.URL,
+		ClientID:     "client-1",
+		ClientSecret: "secret-1",
+		RedirectUrl:  "http://localhost/callback",
+		Scopes:       []string{"openid", "email"},
+	}
+}
+
+func (i *testIssuer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	ss, err := token.SignedString(i.key)
+	if err != nil {
+		t.Fatalf("sign id token failed: %v", err)
+	}
+	return ss
+}
+
+func (i *testIssuer) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            i.server.URL,
+		"aud":            "client-1",
+		"sub":            "subject-1",
+		"email":          "user@example.com",
+		"email_verified": "true",
+		"name":           "User",
+		"nonce":          "nonce-1",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.idToken = issuer.sign(t, "k1", issuer.claims())
+
+	client := OidcClient(&testOidcConfig{providers: map[string]*config.OidcProvider{"test": issuer.provider()}})
+
+	identity, err := client.Exchange(context.Background(), "test", "code-1", "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+
+	if identity.Provider != "test" || identity.Subject != "subject-1" || identity.Email != "user@example.com" || !identity.EmailVerified || identity.Name != "User" {
+		t.Errorf("expect: %s, got: %+v", "identity of subject-1", identity)
+	}
+
+	if _, err := client.Exchange(context.Background(), "test", "code-1", "wrong-verifier", "nonce-1"); err == nil {
+		t.Errorf("expect: %s, got: %v", "exchange error for a wrong verifier", nil)
+	}
+
+	if _, err := client.Exchange(context.Background(), "missing", "code-1", "verifier-1", "nonce-1"); err != ErrProviderNotFound {
+		t.Errorf("expect: %v, got: %v", ErrProviderNotFound, err)
+	}
+}
+
+type testVerifyIdToken struct {
+	name   string
+	token  func(issuer *testIssuer) string
+	nonce  string
+	isErr  bool
+	expect string // subject
+}
+
+func TestVerifyIdToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	with := func(key string, value any) func(issuer *testIssuer) string {
+		return func(issuer *testIssuer) string {
+			claims := issuer.claims()
+			if value == nil {
+				delete(claims, key)
+			} else {
+				claims[key] = value
+			}
+			return issuer.sign(t, "k1", claims)
+		}
+	}
+
+	tests := []testVerifyIdToken{
+		{
+			name:   "valid",
+			token:  with("sub", "subject-1"),
+			nonce:  "nonce-1",
+			expect: "subject-1",
+		},
+		{
+			name:  "nonce mismatch",
+			token: with("sub", "subject-1"),
+			nonce: "nonce-2",
+			isErr: true,
+		},
+		{
+			name:  "wrong audience",
+			token: with("aud", "client-2"),
+			nonce: "nonce-1",
+			isErr: true,
+		},
+		{
+			name:  "wrong issuer",
+			token: with("iss", "https://attacker.example.com"),
+			nonce: "nonce-1",
+			isErr: true,
+		},
+		{
+			name:  "expired",
+			token: with("exp", time.Now().Add(-time.Minute).Unix()),
+			nonce: "nonce-1",
+			isErr: true,
+		},
+		{
+			name:  "without exp",
+			token: with("exp", nil),
+			nonce: "nonce-1",
+			isErr: true,
+		},
+		{
+			name: "unknown kid",
+			token: func(issuer *testIssuer) string {
+				return issuer.sign(t, "k2", issuer.claims())
+			},
+			nonce: "nonce-1",
+			isErr: true,
+		},
+		{
+			name: "signed by another key",
+			token: func(issuer *testIssuer) string {
+				other, err := rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					t.Fatalf("generate key failed: %v", err)
+				}
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, issuer.claims())
+				token.Header["kid"] = "k1"
+				ss, _ := token.SignedString(other)
+				return ss
+			},
+			nonce: "nonce-1",
+			isErr: true,
+		},
+		{
+			name: "hs256 with the client secret",
+			token: func(issuer *testIssuer) string {
+				ss, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, issuer.claims()).SignedString([]byte("secret-1"))
+				return ss
+			},
+			nonce:  "nonce-1",
+			expect: "subject-1",
+		},
+		{
+			name: "hs256 with another secret",
+			token: func(issuer *testIssuer) string {
+				ss, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, issuer.claims()).SignedString([]byte("secret-2"))
+				return ss
+			},
+			nonce: "nonce-1",
+			isErr: true,
+		},
+	}
+
+	p := &provider{cfg: issuer.provider(), httpClient: issuer.server.Client()}
+	if err := p.discover(context.Background()); err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+
+	for _, test := range tests {
+		claims, err := p.verifyIdToken(context.Background(), test.token(issuer), test.nonce)
+		if test.isErr {
+			if err == nil {
+				t.Errorf("%s: expect: %s, got: %v", test.name, "error", nil)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: expect: %v, got: %v", test.name, nil, err)
+			continue
+		}
+		if claims.Subject != test.expect {
+			t.Errorf("%s: expect: %s, got: %s", test.name, test.expect, claims.Subject)
+		}
+	}
+}
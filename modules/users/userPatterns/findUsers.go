@@ -0,0 +1,207 @@
+package usersPatterns
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/korvised/go-ecommerce/modules/users"
+	"log"
+	"strings"
+	"time"
+)
+
+type IFindUsersBuilder interface {
+	initQuery()
+	initCountQuery()
+	buildWhereSearch()
+	buildWhereRole()
+	buildWhereStatus()
+	buildWhereDate()
+	buildSort()
+	buildPaginate()
+	getQuery() string
+	getValues() []any
+	getDb() *sqlx.DB
+	reset()
+}
+
+type findUsersBuilder struct {
+	db        *sqlx.DB
+	req       *users.UserFilter
+	query     string
+	values    []any
+	lastIndex int
+}
+
+type findUsersEngineer struct {
+	builder IFindUsersBuilder
+}
+
+// FindUsersBuilder expects req.OrderBy to be a column mapped by the handler,
+// it is written into the query as is
+func FindUsersBuilder(db *sqlx.DB, req *users.UserFilter) IFindUsersBuilder {
+	return &findUsersBuilder{
+		db:     db,
+		req:    req,
+		values: make([]any, 0),
+	}
+}
+
+func FindUsersEngineer(b IFindUsersBuilder) *findUsersEngineer {
+	return &findUsersEngineer{builder: b}
+}
+
+func (b *findUsersBuilder) initQuery() {
+	b.query += `
+	SELECT u.id,
+		   u.email,
+		   u.username,
+		   u.role_id,
+		   u.verified,
+		   u.display_name,
+		   u.phone,
+		   u.avatar_url,
+		   u.created_at,
+		   u.disabled_at,
+		   u.erased_at
+	FROM users u
+	WHERE 1 = 1`
+}
+
+func (b *findUsersBuilder) initCountQuery() {
+	b.query += `
+	SELECT COUNT(*) AS count
+	FROM users u
+	WHERE 1 = 1`
+}
+
+func (b *findUsersBuilder) buildWhereSearch() {
+	if b.req.Search != "" {
+		b.values = append(b.values, "%"+strings.ToLower(b.req.Search)+"%")
+
+		b.query += fmt.Sprintf(`
+		AND (
+				LOWER(u.id) LIKE $%d OR
+				LOWER(u.email) LIKE $%d OR
+				LOWER(u.username) LIKE $%d
+			)`,
+			b.lastIndex+1,
+			b.lastIndex+1,
+			b.lastIndex+1,
+		)
+
+		b.lastIndex = len(b.values)
+	}
+}
+
+func (b *findUsersBuilder) buildWhereRole() {
+	if b.req.RoleID != 0 {
+		b.values = append(b.values, b.req.RoleID)
+
+		b.query += fmt.Sprintf(`
+		AND u.role_id = $%d`, b.lastIndex+1)
+
+		b.lastIndex = len(b.values)
+	}
+}
+
+func (b *findUsersBuilder) buildWhereStatus() {
+	switch b.req.Status {
+	case "active":
+		b.query += `
+		AND u.disabled_at IS NULL AND u.erased_at IS NULL`
+	case "disabled":
+		b.query += `
+		AND u.disabled_at IS NOT NULL`
+	case "erased":
+		b.query += `
+		AND u.erased_at IS NOT NULL`
+	}
+}
+
+func (b *findUsersBuilder) buildWhereDate() {
+	if b.req.StartDate != "" {
+		b.values = append(b.values, b.req.StartDate)
+
+		b.query += fmt.Sprintf(`
+		AND u.created_at >= DATE($%d)`, b.lastIndex+1)
+
+		b.lastIndex = len(b.values)
+	}
+
+	if b.req.EndDate != "" {
+		b.values = append(b.values, b.req.EndDate)
+
+		b.query += fmt.Sprintf(`
+		AND u.created_at < DATE($%d) + 1`, b.lastIndex+1)
+
+		b.lastIndex = len(b.values)
+	}
+}
+
+func (b *findUsersBuilder) buildSort() {
+	b.query += fmt.Sprintf(`
+	ORDER BY %s %s, u.id`, b.req.OrderBy, b.req.Sort)
+}
+
+func (b *findUsersBuilder) buildPaginate() {
+	b.values = append(b.values, (b.req.Page-1)*b.req.Size, b.req.Size)
+
+	b.query += fmt.Sprintf(`
+	OFFSET $%d LIMIT $%d;`, b.lastIndex+1, b.lastIndex+2)
+
+	b.lastIndex = len(b.values)
+}
+
+func (b *findUsersBuilder) getQuery() string { return b.query }
+
+func (b *findUsersBuilder) getValues() []any { return b.values }
+
+func (b *findUsersBuilder) getDb() *sqlx.DB { return b.db }
+
+func (b *findUsersBuilder) reset() {
+	b.query = ""
+	b.values = make([]any, 0)
+	b.lastIndex = 0
+}
+
+func (en *findUsersEngineer) FindUsers() []*users.AdminUser {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	en.builder.initQuery()
+	en.builder.buildWhereSearch()
+	en.builder.buildWhereRole()
+	en.builder.buildWhereStatus()
+	en.builder.buildWhereDate()
+	en.builder.buildSort()
+	en.builder.buildPaginate()
+	defer en.builder.reset()
+
+	data := make([]*users.AdminUser, 0)
+	if err := en.builder.getDb().SelectContext(ctx, &data, en.builder.getQuery(), en.builder.getValues()...); err != nil {
+		log.Printf("query users failed: %v", err)
+	}
+
+	return data
+}
+
+func (en *findUsersEngineer) CountUsers() int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	en.builder.initCountQuery()
+	en.builder.buildWhereSearch()
+	en.builder.buildWhereRole()
+	en.builder.buildWhereStatus()
+	en.builder.buildWhereDate()
+	defer en.builder.reset()
+
+	var count int
+	if err := en.builder.getDb().GetContext(ctx, &count, en.builder.getQuery(), en.builder.getValues()...); err != nil {
+		log.Printf("count users failed: %v", err)
+		return 0
+	}
+
+	return count
+}
@@ -116,7 +116,7 @@ func (f *userReq) Admin() (IInsertUser, error) {
 func (f *userReq) Result() (*users.UserPassport, error) {
 	query := `
 	 SELECT json_build_object('user', t, 'token', NULL) as json
-	 FROM (SELECT u.id, u.email, u.username, u.role_id
+	 FROM (SELECT u.id, u.email, u.username, u.role_id, u.verified
       FROM users u
       WHERE u.id = $1) as t;
 	`
@@ -2,6 +2,9 @@ package usersRepositories
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/korvised/go-ecommerce/modules/users"
@@ -9,14 +12,64 @@ import (
 	"time"
 )
 
+// ErrResetTokenInvalid is returned for unknown, used or expired reset tokens
+var ErrResetTokenInvalid = errors.New("reset token is invalid or has expired")
+
+// ErrVerifyTokenInvalid is returned when the email of the token is no longer the user's
+var ErrVerifyTokenInvalid = errors.New("verification token is invalid")
+
+// ErrTotpEnabled is returned when enrolling a user who already has 2fa
+var ErrTotpEnabled = errors.New("two-factor authentication is already enabled")
+
+// ErrOauthNotFound is returned when no session holds the refresh token
+var ErrOauthNotFound = errors.New("oauth not found")
+
+// ErrIdentityLinked is returned when the provider account belongs to a user already
+var ErrIdentityLinked = errors.New("provider account is already linked to a user")
+
+// ErrUserErased is returned when erasing a user twice
+var ErrUserErased = errors.New("user is already erased")
+
+// ErrUserDisabled is returned when starting a session of a disabled user
+var ErrUserDisabled = errors.New("account is disabled, contact support")
+
 type IUsersRepository interface {
 	InsertUser(req *users.UserRegisterReq, isAdmin bool) (*users.UserPassport, error)
 	FindOneUserByEmail(email string) (*users.UserCredentialCheck, error)
-	InsertOauth(req *users.UserPassport) error
-	FindOneOauth(refreshToken string) (*users.Oauth, error)
-	UpdateOauth(req *users.UserToken) error
+	InsertOauth(req *users.UserPassport, session *users.Session, refreshHash string) error
+	FindOneOauth(refreshHash string) (*users.Oauth, error)
+	UpdateOauth(req *users.UserToken, session *users.Session, usedHash, refreshHash string) error
+	FindSessions(userId string) ([]*users.Session, error)
+	DeleteUserOauth(userId, oauthId string) error
+	DeleteAllOauth(userId string) error
 	GetProfile(userId string) (*users.User, error)
 	DeleteOauth(oauthId string) error
+	InsertPasswordReset(userId, tokenHash string, expires time.Duration) error
+	ResetPassword(tokenHash, password string) error
+	MarkVerificationSent(userId string, interval time.Duration) (bool, error)
+	VerifyEmail(userId, email string) error
+	FindTotp(userId string) (*users.UserTotp, error)
+	SetTotpSecret(userId, secret string) error
+	EnableTotp(userId string, step int64, codeHashes []string) error
+	DisableTotp(userId string) error
+	UseTotpStep(userId string, step int64) (bool, error)
+	UseRecoveryCode(userId, codeHash string) (bool, error)
+	ReplaceRecoveryCodes(userId string, codeHashes []string) error
+	FindOneUserByIdentity(provider, subject string) (*users.UserCredentialCheck, error)
+	InsertIdentity(userId string, identity *users.OidcIdentity) error
+	InsertOidcUser(req *users.UserRegisterReq, verified bool, identity *users.OidcIdentity) (*users.UserCredentialCheck, error)
+	UpdateProfile(userId string, req *users.UserUpdateReq) error
+	ChangePassword(userId, password, keepOauthId string) error
+	UpdateAvatar(userId string, url *string) error
+	FindIdentities(userId string) ([]*users.OidcIdentity, error)
+	FindAddressesExport(userId string) (json.RawMessage, error)
+	FindOrdersExport(userId string) (json.RawMessage, error)
+	FindOwnedFiles(userId string) ([]*users.ExportFile, error)
+	EraseUser(userId, email, username, password string) error
+	FindUsers(req *users.UserFilter) ([]*users.AdminUser, int)
+	FindOneAdminUser(userId string) (*users.AdminUser, error)
+	SetUserDisabled(userId string, disabled bool) error
+	ForcePasswordReset(userId, password string) error
 }
 
 type usersRepository struct {
@@ -55,43 +108,55 @@ func (r *usersRepository) InsertUser(req *users.UserRegisterReq, isAdmin bool) (
 
 func (r *usersRepository) FindOneUserByEmail(email string) (*users.UserCredentialCheck, error) {
 	query := `
-	 SELECT id, email, password, username, role_id
+	 SELECT id, email, password, username, role_id, verified, totp_enabled, disabled_at IS NOT NULL AS disabled
 	 FROM users
 	 WHERE email = $1;
 	`
 
 	user := new(users.UserCredentialCheck)
 	if err := r.db.Get(user, query, email); err != nil {
-		return nil, fmt.Errorf("user not found: %v", err)
+		return nil, fmt.Errorf("user not found: %w", err)
 	}
 
 	return user, nil
 }
 
-func (r *usersRepository) InsertOauth(req *users.UserPassport) error {
+// InsertOauth starts a session with the id set by the caller, the id is the
+// family every refresh token of the session is signed with. Disabled users
+// get no session whichever way they signed in.
+func (r *usersRepository) InsertOauth(req *users.UserPassport, session *users.Session, refreshHash string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
 	query := `
-	 INSERT INTO oauth (user_id, access_token, refresh_token)
-	 VALUES ($1, $2, $3)
-	 RETURNING "id";
+	 INSERT INTO oauth (id, user_id, access_token, refresh_token, device, ip, user_agent)
+	 SELECT $1::uuid, u.id, $3, $4, $5, $6, $7
+	 FROM users u
+	 WHERE u.id = $2 AND u.disabled_at IS NULL;
 	`
 
-	if err := r.db.QueryRowContext(
+	result, err := r.db.ExecContext(
 		ctx,
 		query,
+		req.Token.ID,
 		req.User.ID,
 		req.Token.AccessToken,
-		req.Token.RefreshToken,
-	).Scan(&req.Token.ID); err != nil {
+		refreshHash,
+		session.Device,
+		session.IP,
+		session.UserAgent,
+	)
+	if err != nil {
 		return fmt.Errorf("insert oauth failed: %v", err)
 	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrUserDisabled
+	}
 
 	return nil
 }
 
-func (r *usersRepository) FindOneOauth(refreshToken string) (*users.Oauth, error) {
+func (r *usersRepository) FindOneOauth(refreshHash string) (*users.Oauth, error) {
 	query := `
 	 SELECT id, user_id
 	 FROM oauth
@@ -99,34 +164,46 @@ func (r *usersRepository) FindOneOauth(refreshToken string) (*users.Oauth, error
 	`
 
 	oauth := new(users.Oauth)
-	if err := r.db.Get(oauth, query, refreshToken); err != nil {
-		return nil, fmt.Errorf("oauth not found")
+	if err := r.db.Get(oauth, query, refreshHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOauthNotFound
+		}
+		return nil, fmt.Errorf("find oauth failed: %v", err)
 	}
 
 	return oauth, nil
 }
 
-func (r *usersRepository) UpdateOauth(req *users.UserToken) error {
+// UpdateOauth swaps the refresh token of a session, it only succeeds while
+// usedHash is still the current token so two refreshes can not both win
+func (r *usersRepository) UpdateOauth(req *users.UserToken, session *users.Session, usedHash, refreshHash string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
 	query := `
 	 UPDATE oauth
-	 SET access_token = :access_token,
-         refresh_token = :refresh_token
-	 WHERE id = :id;
+	 SET access_token = $2,
+	     refresh_token = $3,
+	     ip = $5,
+	     user_agent = $6,
+	     last_used_at = now()
+	 WHERE id = $1 AND refresh_token = $4;
 	`
 
-	if _, err := r.db.NamedExecContext(ctx, query, req); err != nil {
+	result, err := r.db.ExecContext(ctx, query, req.ID, req.AccessToken, refreshHash, usedHash, session.IP, session.UserAgent)
+	if err != nil {
 		return fmt.Errorf("update oauth failed: %v", err)
 	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrOauthNotFound
+	}
 
 	return nil
 }
 
 func (r *usersRepository) GetProfile(userId string) (*users.User, error) {
 	query := `
-	 SELECT id, email, username, role_id
+	 SELECT id, email, username, role_id, verified, pending_email, display_name, phone, avatar_url
 	 FROM users
 	 WHERE id = $1;
 	`
@@ -138,6 +215,49 @@ func (r *usersRepository) GetProfile(userId string) (*users.User, error) {
 	return profile, nil
 }
 
+func (r *usersRepository) FindSessions(userId string) ([]*users.Session, error) {
+	query := `
+	 SELECT id, device, ip, user_agent, last_used_at, created_at
+	 FROM oauth
+	 WHERE user_id = $1
+	 ORDER BY last_used_at DESC;
+	`
+
+	sessions := make([]*users.Session, 0)
+	if err := r.db.Select(&sessions, query, userId); err != nil {
+		return nil, fmt.Errorf("find sessions failed: %v", err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteUserOauth deletes a session only when it belongs to the user
+func (r *usersRepository) DeleteUserOauth(userId, oauthId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM oauth WHERE id = $1 AND user_id = $2;`, oauthId, userId)
+	if err != nil {
+		return fmt.Errorf("delete oauth failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrOauthNotFound
+	}
+
+	return nil
+}
+
+func (r *usersRepository) DeleteAllOauth(userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM oauth WHERE user_id = $1;`, userId); err != nil {
+		return fmt.Errorf("delete oauth failed: %v", err)
+	}
+
+	return nil
+}
+
 func (r *usersRepository) DeleteOauth(oauthId string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
@@ -154,3 +274,736 @@ func (r *usersRepository) DeleteOauth(oauthId string) error {
 
 	return nil
 }
+
+// InsertPasswordReset replaces the unused reset tokens of the user, only the
+// latest mail works
+func (r *usersRepository) InsertPasswordReset(userId, tokenHash string, expires time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	 DELETE
+	 FROM password_resets
+	 WHERE user_id = $1
+	   AND used_at IS NULL;
+	`
+
+	if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("delete password resets failed: %v", err)
+	}
+
+	query = `
+	 INSERT INTO password_resets (user_id, token_hash, expires_at)
+	 VALUES ($1, $2, now() + $3::INT * INTERVAL '1 second');
+	`
+
+	if _, err := tx.ExecContext(ctx, query, userId, tokenHash, int64(expires.Seconds())); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("insert password reset failed: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// ResetPassword consumes the token, sets the hashed password and revokes
+// every oauth session of the user at once
+func (r *usersRepository) ResetPassword(tokenHash, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	 UPDATE password_resets
+	 SET used_at = now()
+	 WHERE token_hash = $1
+	   AND used_at IS NULL
+	   AND expires_at > now()
+	 RETURNING user_id;
+	`
+
+	var userId string
+	if err := tx.QueryRowxContext(ctx, query, tokenHash).Scan(&userId); err != nil {
+		_ = tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrResetTokenInvalid
+		}
+		return fmt.Errorf("use password reset failed: %v", err)
+	}
+
+	query = `
+	 UPDATE users
+	 SET password = $1
+	 WHERE id = $2;
+	`
+
+	if _, err := tx.ExecContext(ctx, query, password, userId); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("update password failed: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oauth WHERE user_id = $1;`, userId); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("delete oauth failed: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// MarkVerificationSent records a verification mail unless one was sent within
+// interval, false is returned when throttled or already verified
+func (r *usersRepository) MarkVerificationSent(userId string, interval time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := `
+	 UPDATE users
+	 SET verification_sent_at = now()
+	 WHERE id = $1
+	   AND verified = FALSE
+	   AND (verification_sent_at IS NULL OR verification_sent_at <= now() - $2::INT * INTERVAL '1 second');
+	`
+
+	result, err := r.db.ExecContext(ctx, query, userId, int64(interval.Seconds()))
+	if err != nil {
+		return false, fmt.Errorf("update verification sent failed: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// VerifyEmail confirms the current email or swaps in the pending one, the
+// token of an address no longer current nor pending is void
+func (r *usersRepository) VerifyEmail(userId, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := `
+	 UPDATE users
+	 SET email         = $2,
+	     pending_email = CASE WHEN pending_email = $2 THEN NULL ELSE pending_email END,
+	     verified      = TRUE,
+	     verified_at   = CASE WHEN email = $2 THEN COALESCE(verified_at, now()) ELSE now() END
+	 WHERE id = $1
+	   AND (email = $2 OR pending_email = $2);
+	`
+
+	result, err := r.db.ExecContext(ctx, query, userId, email)
+	if err != nil {
+		if err.Error() == "ERROR: duplicate key value violates unique constraint \"users_email_key\" (SQLSTATE 23505)" {
+			return fmt.Errorf("email is already in used")
+		}
+		return fmt.Errorf("verify email failed: %v", err)
+	}
+
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return ErrVerifyTokenInvalid
+	}
+
+	return nil
+}
+
+func (r *usersRepository) FindTotp(userId string) (*users.UserTotp, error) {
+	query := `
+	 SELECT totp_secret, totp_enabled, totp_last_step
+	 FROM users
+	 WHERE id = $1;
+	`
+
+	totp := new(users.UserTotp)
+	if err := r.db.Get(totp, query, userId); err != nil {
+		return nil, err
+	}
+
+	return totp, nil
+}
+
+// SetTotpSecret starts an enrolment, the secret is not trusted until EnableTotp
+func (r *usersRepository) SetTotpSecret(userId, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := `
+	 UPDATE users
+	 SET totp_secret = $2
+	 WHERE id = $1 AND totp_enabled = FALSE;
+	`
+
+	result, err := r.db.ExecContext(ctx, query, userId, secret)
+	if err != nil {
+		return fmt.Errorf("set totp secret failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrTotpEnabled
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sqlx.Tx, userId string, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1;`, userId); err != nil {
+		return fmt.Errorf("delete recovery codes failed: %v", err)
+	}
+
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2);`, userId, hash); err != nil {
+			return fmt.Errorf("insert recovery code failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// EnableTotp confirms an enrolment with the step of its first code and
+// replaces the recovery codes in the same transaction
+func (r *usersRepository) EnableTotp(userId string, step int64, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	 UPDATE users
+	 SET totp_enabled = TRUE,
+	     totp_last_step = $2
+	 WHERE id = $1 AND totp_enabled = FALSE AND totp_secret IS NOT NULL;
+	`
+
+	result, err := tx.ExecContext(ctx, query, userId, step)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("enable totp failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		_ = tx.Rollback()
+		return ErrTotpEnabled
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (r *usersRepository) DisableTotp(userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	 UPDATE users
+	 SET totp_secret = NULL,
+	     totp_enabled = FALSE
+	 WHERE id = $1;
+	`
+
+	if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("disable totp failed: %v", err)
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, nil); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+// UseTotpStep accepts a code only for a step later than the last accepted one
+func (r *usersRepository) UseTotpStep(userId string, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2;`, userId, step)
+	if err != nil {
+		return false, fmt.Errorf("use totp step failed: %v", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+func (r *usersRepository) UseRecoveryCode(userId, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := `
+	 UPDATE recovery_codes
+	 SET used_at = now()
+	 WHERE id = (
+	     SELECT id
+	     FROM recovery_codes
+	     WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	     LIMIT 1
+	 ) AND used_at IS NULL;
+	`
+
+	result, err := r.db.ExecContext(ctx, query, userId, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("use recovery code failed: %v", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+func (r *usersRepository) ReplaceRecoveryCodes(userId string, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (r *usersRepository) FindOneUserByIdentity(provider, subject string) (*users.UserCredentialCheck, error) {
+	query := `
+	 SELECT u.id, u.email, u.password, u.username, u.role_id, u.verified, u.totp_enabled, u.disabled_at IS NOT NULL AS disabled
+	 FROM user_identities i
+	 JOIN users u ON u.id = i.user_id
+	 WHERE i.provider = $1 AND i.subject = $2;
+	`
+
+	user := new(users.UserCredentialCheck)
+	if err := r.db.Get(user, query, provider, subject); err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+
+	return user, nil
+}
+
+func insertIdentity(ctx context.Context, ext sqlx.ExecerContext, userId string, identity *users.OidcIdentity) error {
+	query := `
+	 INSERT INTO user_identities (user_id, provider, subject, email)
+	 VALUES ($1, $2, $3, NULLIF($4, ''))
+	 ON CONFLICT (provider, subject) DO NOTHING;
+	`
+
+	result, err := ext.ExecContext(ctx, query, userId, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		return fmt.Errorf("insert identity failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrIdentityLinked
+	}
+
+	return nil
+}
+
+func (r *usersRepository) InsertIdentity(userId string, identity *users.OidcIdentity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return insertIdentity(ctx, r.db, userId, identity)
+}
+
+// InsertOidcUser creates a customer on the first social sign-in together
+// with the identity, so a failed link leaves no account behind
+func (r *usersRepository) InsertOidcUser(req *users.UserRegisterReq, verified bool, identity *users.OidcIdentity) (*users.UserCredentialCheck, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	 INSERT INTO users (email, password, username, role_id, verified)
+	 VALUES ($1, $2, $3, 1, $4)
+	 RETURNING id, email, password, username, role_id, verified, totp_enabled;
+	`
+
+	user := new(users.UserCredentialCheck)
+	if err := tx.QueryRowxContext(ctx, query, req.Email, req.Password, req.Username, verified).StructScan(user); err != nil {
+		_ = tx.Rollback()
+		switch err.Error() {
+		case "ERROR: duplicate key value violates unique constraint \"users_username_key\" (SQLSTATE 23505)":
+			return nil, fmt.Errorf("username is already in used")
+		case "ERROR: duplicate key value violates unique constraint \"users_email_key\" (SQLSTATE 23505)":
+			return nil, fmt.Errorf("email is already in used")
+		default:
+			return nil, fmt.Errorf("insert user failed: %v", err)
+		}
+	}
+
+	if err := insertIdentity(ctx, tx, user.ID, identity); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// UpdateProfile sets the fields of req which are not nil, a changed email
+// is only kept pending, VerifyEmail swaps it in once the new address is
+// confirmed
+func (r *usersRepository) UpdateProfile(userId string, req *users.UserUpdateReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := `
+	 UPDATE users
+	 SET username = COALESCE($2, username),
+	     pending_email = COALESCE($3, pending_email),
+	     display_name = CASE WHEN $4::VARCHAR IS NULL THEN display_name ELSE NULLIF($4, '') END,
+	     phone = CASE WHEN $5::VARCHAR IS NULL THEN phone ELSE NULLIF($5, '') END
+	 WHERE id = $1;
+	`
+
+	result, err := r.db.ExecContext(ctx, query, userId, req.Username, req.Email, req.DisplayName, req.Phone)
+	if err != nil {
+		switch err.Error() {
+		case "ERROR: duplicate key value violates unique constraint \"users_username_key\" (SQLSTATE 23505)":
+			return fmt.Errorf("username is already in used")
+		case "ERROR: duplicate key value violates unique constraint \"users_email_key\" (SQLSTATE 23505)":
+			return fmt.Errorf("email is already in used")
+		default:
+			return fmt.Errorf("update profile failed: %v", err)
+		}
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ChangePassword signs out every session but the one changing the password
+func (r *usersRepository) ChangePassword(userId, password, keepOauthId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password = $2 WHERE id = $1;`, userId, password); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("update password failed: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oauth WHERE user_id = $1 AND id::VARCHAR <> $2;`, userId, keepOauthId); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("delete oauth failed: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// UpdateAvatar sets or, with nil, removes the avatar. The files reference
+// count follows the url by trigger.
+func (r *usersRepository) UpdateAvatar(userId string, url *string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE users SET avatar_url = $2 WHERE id = $1;`, userId, url)
+	if err != nil {
+		return fmt.Errorf("update avatar failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *usersRepository) FindIdentities(userId string) ([]*users.OidcIdentity, error) {
+	query := `
+	 SELECT provider, subject, COALESCE(email, '') AS email
+	 FROM user_identities
+	 WHERE user_id = $1
+	 ORDER BY created_at;
+	`
+
+	identities := make([]*users.OidcIdentity, 0)
+	if err := r.db.Select(&identities, query, userId); err != nil {
+		return nil, fmt.Errorf("find identities failed: %v", err)
+	}
+
+	return identities, nil
+}
+
+// FindAddressesExport returns the address book of the user as a json array
+func (r *usersRepository) FindAddressesExport(userId string) (json.RawMessage, error) {
+	query := `
+	SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.created_at), '[]'::jsonb)
+	FROM (SELECT id, recipient, phone, line1, line2, district, province, postcode, country, is_default, created_at, updated_at
+		  FROM addresses
+		  WHERE user_id = $1) AS t;
+	`
+
+	data := make([]byte, 0)
+	if err := r.db.Get(&data, query, userId); err != nil {
+		return nil, fmt.Errorf("find addresses failed: %v", err)
+	}
+
+	return data, nil
+}
+
+// FindOrdersExport returns the orders of the user as a json array in the
+// shape of the orders module
+func (r *usersRepository) FindOrdersExport(userId string) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	query := `
+	SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.created_at), '[]'::jsonb)
+	FROM (SELECT o.id,
+				 o.transfer_slip,
+				 (SELECT array_to_json(array_agg(pt))
+				  FROM (SELECT spo.id, spo.qty, spo.product
+						FROM products_orders spo
+						WHERE spo.order_id = o.id) AS pt) AS products,
+				 o.address,
+				 o.contact,
+				 o.shipping_address,
+				 o.status,
+				 o.created_at,
+				 o.updated_at
+		  FROM orders o
+		  WHERE o.user_id = $1) AS t;
+	`
+
+	data := make([]byte, 0)
+	if err := r.db.GetContext(ctx, &data, query, userId); err != nil {
+		return nil, fmt.Errorf("find orders failed: %v", err)
+	}
+
+	return data, nil
+}
+
+func (r *usersRepository) FindOwnedFiles(userId string) ([]*users.ExportFile, error) {
+	query := `
+	 SELECT storage_key, filename, url, content_type, size, private, created_at
+	 FROM files
+	 WHERE owner_id = $1
+	 ORDER BY created_at;
+	`
+
+	ownedFiles := make([]*users.ExportFile, 0)
+	if err := r.db.Select(&ownedFiles, query, userId); err != nil {
+		return nil, fmt.Errorf("find files failed: %v", err)
+	}
+
+	return ownedFiles, nil
+}
+
+// EraseUser anonymises the user in place, the row stays for the orders kept
+// for accounting whose address and contact are redacted. Sessions, linked
+// identities and second factors are deleted, uploaded files are disowned
+// and the ones nothing references are left to the orphan sweep.
+func (r *usersRepository) EraseUser(userId, email, username, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	 UPDATE users
+	 SET email = $2,
+	     username = $3,
+	     password = $4,
+	     verified = FALSE,
+	     display_name = NULL,
+	     phone = NULL,
+	     avatar_url = NULL,
+	     totp_secret = NULL,
+	     totp_enabled = FALSE,
+	     verification_sent_at = NULL,
+	     pending_email = NULL,
+	     erased_at = now()
+	 WHERE id = $1 AND erased_at IS NULL;
+	`
+
+	result, err := tx.ExecContext(ctx, query, userId, email, username, password)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("erase user failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		_ = tx.Rollback()
+		return ErrUserErased
+	}
+
+	for _, query := range []string{
+		`DELETE FROM oauth WHERE user_id = $1;`,
+		`DELETE FROM user_identities WHERE user_id = $1;`,
+		`DELETE FROM recovery_codes WHERE user_id = $1;`,
+		`DELETE FROM password_resets WHERE user_id = $1;`,
+		`DELETE FROM addresses WHERE user_id = $1;`,
+		`UPDATE orders SET address = '[redacted]', contact = '[redacted]', shipping_address = NULL WHERE user_id = $1;`,
+		`UPDATE files SET owner_id = NULL WHERE owner_id = $1;`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("erase user failed: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *usersRepository) FindUsers(req *users.UserFilter) ([]*users.AdminUser, int) {
+	builder := usersPatterns.FindUsersBuilder(r.db, req)
+	engineer := usersPatterns.FindUsersEngineer(builder)
+
+	return engineer.FindUsers(), engineer.CountUsers()
+}
+
+func (r *usersRepository) FindOneAdminUser(userId string) (*users.AdminUser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	query := `
+	 SELECT id, email, username, role_id, verified, display_name, phone, avatar_url, created_at, disabled_at, erased_at
+	 FROM users
+	 WHERE id = $1;
+	`
+
+	user := &users.AdminUser{User: new(users.User)}
+	if err := r.db.GetContext(ctx, user, query, userId); err != nil {
+		return nil, err
+	}
+
+	query = `
+	 SELECT COUNT(o.id)                                                     AS count,
+	        COUNT(o.id) FILTER (WHERE o.status = 'canceled')                AS canceled,
+	        COALESCE(SUM(p.paid) FILTER (WHERE o.status <> 'canceled'), 0) AS total_paid,
+	        MAX(o.created_at)                                               AS last_order_at
+	 FROM orders o
+	 LEFT JOIN LATERAL (SELECT SUM(COALESCE((COALESCE(po.product ->> 'effective_price', po.product ->> 'price'))::FLOAT * (po.qty)::FLOAT, 0)) AS paid
+	                    FROM products_orders po
+	                    WHERE po.order_id = o.id) p ON TRUE
+	 WHERE o.user_id = $1;
+	`
+
+	user.Orders = new(users.UserOrderStats)
+	if err := r.db.GetContext(ctx, user.Orders, query, userId); err != nil {
+		return nil, fmt.Errorf("find order stats failed: %v", err)
+	}
+
+	return user, nil
+}
+
+// SetUserDisabled disables or enables a user, disabling signs the user out
+// everywhere so the refresh tokens can not start new sessions
+func (r *usersRepository) SetUserDisabled(userId string, disabled bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	 UPDATE users
+	 SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, now()) END
+	 WHERE id = $1;
+	`
+
+	result, err := tx.ExecContext(ctx, query, userId, disabled)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("update user disabled failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		_ = tx.Rollback()
+		return sql.ErrNoRows
+	}
+
+	if disabled {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oauth WHERE user_id = $1;`, userId); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("delete oauth failed: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ForcePasswordReset replaces the password with one nobody knows and signs
+// the user out everywhere, the user sets a new one from the reset mail
+func (r *usersRepository) ForcePasswordReset(userId, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE users SET password = $2 WHERE id = $1 AND erased_at IS NULL;`, userId, password)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("update password failed: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		_ = tx.Rollback()
+		return ErrUserErased
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oauth WHERE user_id = $1;`, userId); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("delete oauth failed: %v", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,67 @@
+package usersUsecases
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/korvised/go-ecommerce/modules/entities"
+	"github.com/korvised/go-ecommerce/modules/users"
+	"golang.org/x/crypto/bcrypt"
+	"math"
+)
+
+// ErrDisableSelf keeps an admin from locking themselves out
+var ErrDisableSelf = errors.New("admins can not disable their own account")
+
+func (u *usersUsecase) FindUsers(req *users.UserFilter) *entities.PaginateRes {
+	data, count := u.usersRepository.FindUsers(req)
+
+	return &entities.PaginateRes{
+		Page:      req.Page,
+		Size:      req.Size,
+		TotalPage: int(math.Ceil(float64(count) / float64(req.Size))),
+		TotalItem: count,
+		Data:      data,
+	}
+}
+
+func (u *usersUsecase) FindOneAdminUser(userID string) (*users.AdminUser, error) {
+	return u.usersRepository.FindOneAdminUser(userID)
+}
+
+func (u *usersUsecase) DisableUser(adminID, userID string) error {
+	if adminID == userID {
+		return ErrDisableSelf
+	}
+
+	return u.usersRepository.SetUserDisabled(userID, true)
+}
+
+func (u *usersUsecase) EnableUser(userID string) error {
+	return u.usersRepository.SetUserDisabled(userID, false)
+}
+
+// ForcePasswordReset voids the password of a user, e.g. after a leak, and
+// mails a reset link. The sessions are revoked with the password.
+func (u *usersUsecase) ForcePasswordReset(userID string) error {
+	profile, err := u.usersRepository.GetProfile(userID)
+	if err != nil {
+		return err
+	}
+
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return fmt.Errorf("generate password failed: %v", err)
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(password)), 10)
+	if err != nil {
+		return fmt.Errorf("hash password failed: %v", err)
+	}
+
+	if err := u.usersRepository.ForcePasswordReset(userID, string(hashed)); err != nil {
+		return err
+	}
+
+	return u.sendPasswordReset(profile)
+}
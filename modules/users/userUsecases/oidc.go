@@ -0,0 +1,178 @@
+package usersUsecases
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/korvised/go-ecommerce/modules/middlewares"
+	"github.com/korvised/go-ecommerce/modules/users"
+	"github.com/korvised/go-ecommerce/modules/users/userOidc"
+	"github.com/korvised/go-ecommerce/modules/users/userRepositories"
+	"github.com/korvised/go-ecommerce/pkg/auth"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrOidcStateInvalid  = errors.New("sign-in state is invalid, start over")
+	ErrOidcEmailRequired = errors.New("identity provider shared no email")
+	// ErrOidcEmailInUse keeps a provider account from taking over a password
+	// account whose email neither side has verified
+	ErrOidcEmailInUse = errors.New("email is already in used, sign in with password and verify the email first")
+)
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// OidcAuthorize starts a social sign-in, the verifier and nonce stay in the
+// state token the frontend sends back with the code
+func (u usersUsecase) OidcAuthorize(provider string) (*users.OidcAuthorization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	claims := &auth.OidcStateClaims{Provider: provider}
+	for _, value := range []*string{&claims.State, &claims.Nonce, &claims.Verifier} {
+		v, err := usersOidc.NewVerifier()
+		if err != nil {
+			return nil, err
+		}
+		*value = v
+	}
+
+	url, err := u.oidc.AuthCodeUrl(ctx, provider, claims.State, claims.Nonce, claims.Verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := u.cfg.Oidc().StateExpires()
+	return &users.OidcAuthorization{
+		Url:        url,
+		StateToken: auth.SignOidcStateToken(u.cfg.Jwt(), claims, expires),
+		ExpiresAt:  time.Now().Add(expires).Format(time.RFC3339),
+	}, nil
+}
+
+// OidcSignIn finishes a social sign-in, the identity is linked to its user,
+// to the verified user of the same email or to a new customer
+func (u usersUsecase) OidcSignIn(req *users.OidcSignInReq) (*users.UserPassport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	claims, err := auth.ParseOidcStateToken(u.cfg.Jwt(), req.StateToken)
+	if err != nil {
+		return nil, ErrOidcStateInvalid
+	}
+	if claims.Provider != req.Provider || subtle.ConstantTimeCompare([]byte(claims.State), []byte(req.State)) != 1 {
+		return nil, ErrOidcStateInvalid
+	}
+
+	identity, err := u.oidc.Exchange(ctx, req.Provider, req.Code, claims.Verifier, claims.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := u.usersRepository.FindOneUserByIdentity(identity.Provider, identity.Subject)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if user, err = u.linkIdentity(identity); err != nil {
+			return nil, err
+		}
+	}
+
+	if user.Disabled {
+		return nil, usersRepositories.ErrUserDisabled
+	}
+
+	if u.cfg.App().VerifiedSignIn() && !user.Verified {
+		return nil, fmt.Errorf("email is not verified")
+	}
+
+	// The provider replaces the password, not the second factor
+	if user.TotpEnabled || (user.RoleID == middlewares.RoleAdmin && u.cfg.App().AdminTwoFactor()) {
+		return u.challenge(user)
+	}
+
+	return u.issuePassport(&users.User{
+		ID:       user.ID,
+		Email:    user.Email,
+		Username: user.Username,
+		RoleID:   user.RoleID,
+		Verified: user.Verified,
+	}, &users.Session{
+		Device:    req.Device,
+		IP:        req.IP,
+		UserAgent: req.UserAgent,
+	})
+}
+
+func (u usersUsecase) linkIdentity(identity *users.OidcIdentity) (*users.UserCredentialCheck, error) {
+	if identity.Email == "" {
+		return nil, ErrOidcEmailRequired
+	}
+
+	user, err := u.usersRepository.FindOneUserByEmail(identity.Email)
+	if err == nil {
+		if !identity.EmailVerified || !user.Verified {
+			return nil, ErrOidcEmailInUse
+		}
+		if err := u.usersRepository.InsertIdentity(user.ID, identity); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// The random password is never shown, forgot password sets a real one
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, fmt.Errorf("generate password failed: %v", err)
+	}
+
+	req := &users.UserRegisterReq{
+		Email:    identity.Email,
+		Password: hex.EncodeToString(password),
+		Username: oidcUsername(identity.Email),
+	}
+	if err := req.BcryptHashing(); err != nil {
+		return nil, err
+	}
+
+	user, err = u.usersRepository.InsertOidcUser(req, identity.EmailVerified, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Verified {
+		if _, err := u.sendVerification(&users.User{ID: user.ID, Email: user.Email}, 0); err != nil {
+			log.Printf("send verification to user %s failed: %v", user.ID, err)
+		}
+	}
+
+	return user, nil
+}
+
+// oidcUsername derives a unique username from the email, e.g. jane_3f9a1c
+func oidcUsername(email string) string {
+	name, _, _ := strings.Cut(strings.ToLower(email), "@")
+	name = strings.Trim(usernameInvalidChars.ReplaceAllString(name, "_"), "_")
+	if len(name) > 20 {
+		name = name[:20]
+	}
+	if name == "" {
+		name = "user"
+	}
+
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+
+	return name + "_" + hex.EncodeToString(suffix)
+}
@@ -0,0 +1,149 @@
+package usersUsecases
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/korvised/go-ecommerce/modules/middlewares"
+	"github.com/korvised/go-ecommerce/modules/users"
+	"github.com/korvised/go-ecommerce/modules/users/userLockouts"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrEraseAdmin keeps the shop from erasing its admins, demote them first
+var ErrEraseAdmin = errors.New("admins can not be erased, change the role first")
+
+// ExportUserData collects the data of a user, private files get urls signed
+// for the usual expiry so the export can be downloaded right away
+func (u *usersUsecase) ExportUserData(userID string) (*users.DataExport, error) {
+	profile, err := u.usersRepository.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := u.usersRepository.FindSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := u.usersRepository.FindIdentities(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := u.usersRepository.FindAddressesExport(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := u.usersRepository.FindOrdersExport(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ownedFiles, err := u.usersRepository.FindOwnedFiles(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range ownedFiles {
+		if !f.Private {
+			continue
+		}
+		if f.Url, err = u.filesUsecase.FileUrl(f.StorageKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return &users.DataExport{
+		ExportedAt: time.Now().Format(time.RFC3339),
+		Profile:    profile,
+		Sessions:   sessions,
+		Identities: identities,
+		Addresses:  addresses,
+		Orders:     orders,
+		Files:      ownedFiles,
+	}, nil
+}
+
+// EraseUser erases the own account, the password is asked again since the
+// erasure can not be undone
+func (u *usersUsecase) EraseUser(userID string, req *users.EraseUserReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	profile, err := u.usersRepository.GetProfile(userID)
+	if err != nil {
+		return err
+	}
+
+	userKey := usersLockouts.UserKey(strings.ToLower(profile.Email))
+	if err := u.checkLockout(ctx, userKey, req.IP); err != nil {
+		return err
+	}
+
+	user, err := u.usersRepository.FindOneUserByEmail(profile.Email)
+	if err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		if err := u.failSignIn(ctx, userKey, req.IP); err != nil {
+			return err
+		}
+		return ErrPasswordInvalid
+	}
+
+	return u.erase(profile)
+}
+
+// AdminEraseUser serves erasure requests received by support, e.g. from
+// users who only sign in with a social account
+func (u *usersUsecase) AdminEraseUser(userID string) error {
+	profile, err := u.usersRepository.GetProfile(userID)
+	if err != nil {
+		return err
+	}
+
+	return u.erase(profile)
+}
+
+func (u *usersUsecase) erase(profile *users.User) error {
+	if profile.RoleID == middlewares.RoleAdmin {
+		return ErrEraseAdmin
+	}
+
+	// Nobody knows the new password, the row only keeps the orders linked
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return fmt.Errorf("generate password failed: %v", err)
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(password)), 10)
+	if err != nil {
+		return fmt.Errorf("hash password failed: %v", err)
+	}
+
+	id := strings.ToLower(profile.ID)
+	if err := u.usersRepository.EraseUser(
+		profile.ID,
+		fmt.Sprintf("%s@erased.invalid", id),
+		fmt.Sprintf("erased_%s", id),
+		string(hashed),
+	); err != nil {
+		return err
+	}
+
+	// The failed sign-ins are keyed by the old email
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if err := u.lockouts.Reset(ctx, usersLockouts.UserKey(strings.ToLower(profile.Email))); err != nil {
+		log.Printf("reset lockout of erased user %s failed: %v", profile.ID, err)
+	}
+
+	return nil
+}
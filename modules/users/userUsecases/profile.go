@@ -0,0 +1,175 @@
+package usersUsecases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/korvised/go-ecommerce/modules/files"
+	"github.com/korvised/go-ecommerce/modules/users"
+	"github.com/korvised/go-ecommerce/modules/users/userLockouts"
+	"github.com/korvised/go-ecommerce/pkg/auth"
+	"github.com/korvised/go-ecommerce/pkg/mailer"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AvatarDestination is the storage directory of uploaded avatars
+const AvatarDestination = "avatars"
+
+// ErrPasswordInvalid is returned when the current password does not match
+var ErrPasswordInvalid = errors.New("current password is invalid")
+
+// UpdateUserProfile applies the fields right away but the email, which stays
+// pending until the new address is confirmed. Changing it requires the
+// current password like ChangePassword, a stolen access token must not be
+// enough to move the account to another mailbox.
+func (u *usersUsecase) UpdateUserProfile(userID string, req *users.UserUpdateReq) (*users.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	profile, err := u.usersRepository.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Email != nil && *req.Email == profile.Email {
+		req.Email = nil
+	}
+
+	if req.Email != nil {
+		if err := u.checkPassword(ctx, profile, req.CurrentPassword, req.IP); err != nil {
+			return nil, err
+		}
+
+		if _, err := u.usersRepository.FindOneUserByEmail(*req.Email); err == nil {
+			return nil, fmt.Errorf("email is already in used")
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	if err := u.usersRepository.UpdateProfile(userID, req); err != nil {
+		return nil, err
+	}
+
+	if profile, err = u.usersRepository.GetProfile(userID); err != nil {
+		return nil, err
+	}
+
+	// Only the new address is told, the old one keeps working until then
+	if req.Email != nil {
+		u.sendEmailChange(profile, *req.Email)
+	}
+
+	return profile, nil
+}
+
+// sendEmailChange mails the link confirming a pending email to the new
+// address. Mail failures are logged, the change can be requested again.
+func (u *usersUsecase) sendEmailChange(user *users.User, email string) {
+	expires := u.cfg.App().EmailVerifyExpires()
+	token := auth.SignEmailToken(u.cfg.Jwt(), user.ID, email, expires)
+
+	link, err := url.Parse(u.cfg.App().EmailVerifyUrl())
+	if err != nil {
+		log.Printf("parse email verify url failed: %v", err)
+		return
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	if err := u.mailer.Send(ctx, &mailer.Message{
+		To:      email,
+		Subject: "Confirm your new email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nConfirm your new email with the link below, it expires in %d hours. Until then you keep signing in with your current email.\n\n%s\n\nIf you did not change your email, ignore this email.\n",
+			user.Username,
+			int(expires.Hours()),
+			link.String(),
+		),
+	}); err != nil {
+		log.Printf("send email change mail to user %s failed: %v", user.ID, err)
+	}
+}
+
+// checkPassword compares the current password of profile, wrong guesses count
+// towards the sign-in lockout since a stolen access token must not buy
+// unlimited ones
+func (u *usersUsecase) checkPassword(ctx context.Context, profile *users.User, password, ip string) error {
+	userKey := usersLockouts.UserKey(strings.ToLower(profile.Email))
+	if err := u.checkLockout(ctx, userKey, ip); err != nil {
+		return err
+	}
+
+	user, err := u.usersRepository.FindOneUserByEmail(profile.Email)
+	if err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		if err := u.failSignIn(ctx, userKey, ip); err != nil {
+			return err
+		}
+		return ErrPasswordInvalid
+	}
+
+	return nil
+}
+
+// ChangePassword requires the current password
+func (u *usersUsecase) ChangePassword(userID, currentOauthID string, req *users.ChangePasswordReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	profile, err := u.usersRepository.GetProfile(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := u.checkPassword(ctx, profile, req.CurrentPassword, req.IP); err != nil {
+		return err
+	}
+
+	if err := req.BcryptHashing(); err != nil {
+		return err
+	}
+
+	return u.usersRepository.ChangePassword(userID, req.NewPassword, currentOauthID)
+}
+
+// UploadAvatar stores the image through the files module, which strips its
+// metadata, and replaces the avatar of the user
+func (u *usersUsecase) UploadAvatar(userID string, file *multipart.FileHeader, ext string) (*users.User, error) {
+	res, err := u.filesUsecase.UploadToStorage([]*files.FileReq{
+		{
+			File:        file,
+			Destination: AvatarDestination,
+			Extension:   ext,
+			OwnerID:     userID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("upload avatar failed")
+	}
+
+	if err := u.usersRepository.UpdateAvatar(userID, &res[0].Url); err != nil {
+		return nil, err
+	}
+
+	return u.usersRepository.GetProfile(userID)
+}
+
+func (u *usersUsecase) DeleteAvatar(userID string) error {
+	return u.usersRepository.UpdateAvatar(userID, nil)
+}
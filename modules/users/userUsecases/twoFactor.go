@@ -0,0 +1,394 @@
+package usersUsecases
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"github.com/korvised/go-ecommerce/modules/middlewares"
+	"github.com/korvised/go-ecommerce/modules/users"
+	"github.com/korvised/go-ecommerce/modules/users/userLockouts"
+	"github.com/korvised/go-ecommerce/modules/users/userRepositories"
+	"github.com/korvised/go-ecommerce/pkg/auth"
+	"github.com/korvised/go-ecommerce/pkg/mailer"
+	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorCodeInvalid = errors.New("two-factor code is invalid")
+	ErrTwoFactorRequired    = errors.New("two-factor authentication is required for admins")
+	ErrTotpNotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrTotpNotEnrolled      = errors.New("two-factor enrolment was not started")
+)
+
+// challenge answers the password step of a 2fa sign-in. Admins who must use
+// 2fa but never enrolled are refused, a password alone must not choose their
+// second factor. They enrol through a link mailed by IssueTotpEnrolment.
+func (u usersUsecase) challenge(user *users.UserCredentialCheck) (*users.UserPassport, error) {
+	if !user.TotpEnabled {
+		return nil, ErrTwoFactorRequired
+	}
+
+	return &users.UserPassport{Challenge: u.newChallenge(user.ID, false)}, nil
+}
+
+func (u usersUsecase) newChallenge(userID string, enrol bool) *users.TwoFactorChallenge {
+	expires := u.cfg.App().TwoFactorChallengeExpires()
+	return &users.TwoFactorChallenge{
+		Token:     auth.SignChallengeToken(u.cfg.Jwt(), userID, enrol, expires),
+		ExpiresAt: time.Now().Add(expires).Format(time.RFC3339),
+	}
+}
+
+func (u usersUsecase) enrolTotp(userID, email string) (*users.TotpEnrolment, error) {
+	secret, err := auth.NewTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.usersRepository.SetTotpSecret(userID, secret); err != nil {
+		return nil, err
+	}
+
+	return &users.TotpEnrolment{
+		Secret: secret,
+		Uri:    auth.TotpUri(u.cfg.App().TotpIssuer(), email, secret),
+	}, nil
+}
+
+// IssueTotpEnrolment mails a user the link to enrol in 2fa without signing in
+// first, for admins refused by a mandatory 2fa. The first admin enrols while
+// APP_ADMIN_TWO_FACTOR is still off.
+func (u usersUsecase) IssueTotpEnrolment(userID string) error {
+	profile, totp, err := u.findTotp(userID)
+	if err != nil {
+		return err
+	}
+	if totp.Enabled {
+		return usersRepositories.ErrTotpEnabled
+	}
+
+	expires := u.cfg.App().PasswordResetExpires()
+	token := auth.SignEnrolmentToken(u.cfg.Jwt(), profile.ID, profile.Email, expires)
+
+	link, err := url.Parse(u.cfg.App().TwoFactorEnrolUrl())
+	if err != nil {
+		return fmt.Errorf("parse two-factor enrol url failed: %v", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	if err := u.mailer.Send(ctx, &mailer.Message{
+		To:      profile.Email,
+		Subject: "Set up two-factor authentication",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYour account must use two-factor authentication. Use the link below to set it up, it expires in %d minutes.\n\n%s\n",
+			profile.Username,
+			int(expires.Minutes()),
+			link.String(),
+		),
+	}); err != nil {
+		return fmt.Errorf("send two-factor enrolment mail failed: %v", err)
+	}
+
+	return nil
+}
+
+// SignInEnrolTotp answers an enrolment link with the password. The secret is
+// returned with a challenge, its first code at /users/signin/2fa confirms the
+// enrolment and completes the sign-in. A pending secret is kept, so opening
+// the link again does not void a secret already scanned.
+func (u usersUsecase) SignInEnrolTotp(req *users.TotpEnrolmentReq) (*users.UserPassport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	claims, err := auth.ParseEnrolmentToken(u.cfg.Jwt(), req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	userKey := usersLockouts.UserKey(strings.ToLower(claims.Email))
+	if err := u.checkLockout(ctx, userKey, req.IP); err != nil {
+		return nil, err
+	}
+
+	// The token is void once the email changed
+	user, err := u.usersRepository.FindOneUserByEmail(claims.Email)
+	if err != nil || user.ID != claims.UserID {
+		return nil, fmt.Errorf("enrolment link is no longer valid")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		if err := u.failSignIn(ctx, userKey, req.IP); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	if user.Disabled {
+		return nil, usersRepositories.ErrUserDisabled
+	}
+	if user.TotpEnabled {
+		return nil, usersRepositories.ErrTotpEnabled
+	}
+
+	totp, err := u.usersRepository.FindTotp(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var enrolment *users.TotpEnrolment
+	if totp.Secret != nil {
+		enrolment = &users.TotpEnrolment{
+			Secret: *totp.Secret,
+			Uri:    auth.TotpUri(u.cfg.App().TotpIssuer(), user.Email, *totp.Secret),
+		}
+	} else if enrolment, err = u.enrolTotp(user.ID, user.Email); err != nil {
+		return nil, err
+	}
+
+	challenge := u.newChallenge(user.ID, true)
+	challenge.Enrolment = enrolment
+
+	return &users.UserPassport{Challenge: challenge}, nil
+}
+
+// newRecoveryCodes returns the codes shown once to the user and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for range recoveryCodeCount {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code failed: %v", err)
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b)[:10])
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// useCode accepts a totp code of a later step than the last one or an
+// unused recovery code, recovery codes are matched without dashes or case
+func (u usersUsecase) useCode(userID string, totp *users.UserTotp, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	if step, ok := auth.ValidateTotp(*totp.Secret, code, time.Now()); ok {
+		return u.usersRepository.UseTotpStep(userID, step)
+	}
+
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return u.usersRepository.UseRecoveryCode(userID, hashToken(code))
+}
+
+// verifyCode checks a code of an enabled user, failures count towards the
+// sign-in lockout of the account so codes can not be guessed
+func (u usersUsecase) verifyCode(ctx context.Context, profile *users.User, totp *users.UserTotp, code, ip string) error {
+	userKey := usersLockouts.UserKey(strings.ToLower(profile.Email))
+	if err := u.checkLockout(ctx, userKey, ip); err != nil {
+		return err
+	}
+
+	ok, err := u.useCode(profile.ID, totp, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := u.failSignIn(ctx, userKey, ip); err != nil {
+			return err
+		}
+		return ErrTwoFactorCodeInvalid
+	}
+
+	return u.lockouts.Reset(ctx, userKey)
+}
+
+// SignInTwoFactor completes a sign-in with the challenge token and a code,
+// a pending enrolment is confirmed by its first code when the challenge came
+// from an enrolment link
+func (u usersUsecase) SignInTwoFactor(req *users.TwoFactorReq) (*users.UserPassport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	claims, err := auth.ParseChallengeToken(u.cfg.Jwt(), req.ChallengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := u.usersRepository.GetProfile(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	totp, err := u.usersRepository.FindTotp(profile.ID)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Secret == nil {
+		return nil, ErrTotpNotEnrolled
+	}
+	if !totp.Enabled && !claims.Enrol {
+		return nil, ErrTotpNotEnabled
+	}
+
+	var codes []string
+	if totp.Enabled {
+		if err := u.verifyCode(ctx, profile, totp, req.Code, req.IP); err != nil {
+			return nil, err
+		}
+	} else {
+		if codes, err = u.confirmTotp(ctx, profile, totp, req.Code, req.IP); err != nil {
+			return nil, err
+		}
+	}
+
+	passport, err := u.issuePassport(profile, &users.Session{
+		Device:    req.Device,
+		IP:        req.IP,
+		UserAgent: req.UserAgent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	passport.RecoveryCodes = codes
+
+	return passport, nil
+}
+
+func (u usersUsecase) confirmTotp(ctx context.Context, profile *users.User, totp *users.UserTotp, code, ip string) ([]string, error) {
+	userKey := usersLockouts.UserKey(strings.ToLower(profile.Email))
+	if err := u.checkLockout(ctx, userKey, ip); err != nil {
+		return nil, err
+	}
+
+	step, ok := auth.ValidateTotp(*totp.Secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		if err := u.failSignIn(ctx, userKey, ip); err != nil {
+			return nil, err
+		}
+		return nil, ErrTwoFactorCodeInvalid
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.usersRepository.EnableTotp(profile.ID, step, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, u.lockouts.Reset(ctx, userKey)
+}
+
+// EnrolTotp starts an enrolment, it is not active until ConfirmTotp
+func (u usersUsecase) EnrolTotp(userID string) (*users.TotpEnrolment, error) {
+	profile, err := u.usersRepository.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.enrolTotp(profile.ID, profile.Email)
+}
+
+func (u usersUsecase) ConfirmTotp(userID string, req *users.TotpCodeReq) (*users.RecoveryCodes, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	profile, totp, err := u.findTotp(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Enabled {
+		return nil, usersRepositories.ErrTotpEnabled
+	}
+	if totp.Secret == nil {
+		return nil, ErrTotpNotEnrolled
+	}
+
+	codes, err := u.confirmTotp(ctx, profile, totp, req.Code, req.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &users.RecoveryCodes{Codes: codes}, nil
+}
+
+func (u usersUsecase) DisableTotp(userID string, req *users.TotpCodeReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	profile, totp, err := u.findTotp(userID)
+	if err != nil {
+		return err
+	}
+	if profile.RoleID == middlewares.RoleAdmin && u.cfg.App().AdminTwoFactor() {
+		return ErrTwoFactorRequired
+	}
+	if !totp.Enabled {
+		return ErrTotpNotEnabled
+	}
+
+	if err := u.verifyCode(ctx, profile, totp, req.Code, req.IP); err != nil {
+		return err
+	}
+
+	return u.usersRepository.DisableTotp(profile.ID)
+}
+
+// RegenerateRecoveryCodes replaces every recovery code, used or not
+func (u usersUsecase) RegenerateRecoveryCodes(userID string, req *users.TotpCodeReq) (*users.RecoveryCodes, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	profile, totp, err := u.findTotp(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !totp.Enabled {
+		return nil, ErrTotpNotEnabled
+	}
+
+	if err := u.verifyCode(ctx, profile, totp, req.Code, req.IP); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.usersRepository.ReplaceRecoveryCodes(profile.ID, hashes); err != nil {
+		return nil, err
+	}
+
+	return &users.RecoveryCodes{Codes: codes}, nil
+}
+
+func (u usersUsecase) findTotp(userID string) (*users.User, *users.UserTotp, error) {
+	profile, err := u.usersRepository.GetProfile(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	totp, err := u.usersRepository.FindTotp(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return profile, totp, nil
+}
@@ -1,32 +1,111 @@
 package usersUsecases
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/korvised/go-ecommerce/config"
+	"github.com/korvised/go-ecommerce/modules/entities"
+	"github.com/korvised/go-ecommerce/modules/files/filesUsecases"
+	"github.com/korvised/go-ecommerce/modules/middlewares"
 	"github.com/korvised/go-ecommerce/modules/users"
+	"github.com/korvised/go-ecommerce/modules/users/userLockouts"
+	"github.com/korvised/go-ecommerce/modules/users/userOidc"
 	"github.com/korvised/go-ecommerce/modules/users/userRepositories"
 	"github.com/korvised/go-ecommerce/pkg/auth"
+	"github.com/korvised/go-ecommerce/pkg/mailer"
 	"golang.org/x/crypto/bcrypt"
+	"log"
+	"math"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"time"
 )
 
 type IUsersUsecase interface {
 	InsertAdmin(req *users.UserRegisterReq) (*users.UserPassport, error)
 	InsertCustomer(req *users.UserRegisterReq) (*users.UserPassport, error)
 	GetPassport(req *users.UserCredential) (*users.UserPassport, error)
+	SignInTwoFactor(req *users.TwoFactorReq) (*users.UserPassport, error)
+	SignInEnrolTotp(req *users.TotpEnrolmentReq) (*users.UserPassport, error)
+	IssueTotpEnrolment(userID string) error
 	RefreshPassport(req *users.UserRefreshCredential) (*users.UserPassport, error)
-	DeleteOauth(oauthID string) error
+	DeleteOauth(userID, oauthID string) error
+	DeleteAllOauth(userID string) error
+	FindSessions(userID, currentOauthID string) ([]*users.Session, error)
 	GetUserProfile(userID string) (*users.User, error)
+	ForgotPassword(req *users.ForgotPasswordReq) error
+	ResetPassword(req *users.ResetPasswordReq) error
+	VerifyEmail(token string) error
+	ResendVerification(req *users.ResendVerificationReq) error
+	UnlockUser(req *users.UnlockReq) error
+	EnrolTotp(userID string) (*users.TotpEnrolment, error)
+	ConfirmTotp(userID string, req *users.TotpCodeReq) (*users.RecoveryCodes, error)
+	DisableTotp(userID string, req *users.TotpCodeReq) error
+	RegenerateRecoveryCodes(userID string, req *users.TotpCodeReq) (*users.RecoveryCodes, error)
+	OidcAuthorize(provider string) (*users.OidcAuthorization, error)
+	OidcSignIn(req *users.OidcSignInReq) (*users.UserPassport, error)
+	UpdateUserProfile(userID string, req *users.UserUpdateReq) (*users.User, error)
+	ChangePassword(userID, currentOauthID string, req *users.ChangePasswordReq) error
+	UploadAvatar(userID string, file *multipart.FileHeader, ext string) (*users.User, error)
+	DeleteAvatar(userID string) error
+	ExportUserData(userID string) (*users.DataExport, error)
+	EraseUser(userID string, req *users.EraseUserReq) error
+	AdminEraseUser(userID string) error
+	FindUsers(req *users.UserFilter) *entities.PaginateRes
+	FindOneAdminUser(userID string) (*users.AdminUser, error)
+	DisableUser(adminID, userID string) error
+	EnableUser(userID string) error
+	ForcePasswordReset(userID string) error
+}
+
+// ErrVerificationThrottled is returned when a verification mail was sent too recently
+var ErrVerificationThrottled = errors.New("verification email was sent recently, try again later")
+
+// ErrRefreshTokenReused is returned when a refresh token is used twice, the
+// whole session is revoked since one of the two holders is not the user
+var ErrRefreshTokenReused = errors.New("refresh token was already used, sign in again")
+
+// LockedError is returned by GetPassport while the account or the ip is locked
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed sign-ins, try again in %d seconds", int(math.Ceil(e.RetryAfter.Seconds())))
 }
 
 type usersUsecase struct {
 	cfg             config.IConfig
 	usersRepository usersRepositories.IUsersRepository
+	mailer          mailer.IMailer
+	lockouts        usersLockouts.ILockoutStore
+	oidc            usersOidc.IOidcClient
+	filesUsecase    filesUsecases.IFilesUsecase
 }
 
-func UsersUsecase(cfg config.IConfig, userRepository usersRepositories.IUsersRepository) IUsersUsecase {
+func UsersUsecase(
+	cfg config.IConfig,
+	userRepository usersRepositories.IUsersRepository,
+	mailer mailer.IMailer,
+	lockouts usersLockouts.ILockoutStore,
+	oidc usersOidc.IOidcClient,
+	filesUsecase filesUsecases.IFilesUsecase,
+) IUsersUsecase {
 	return &usersUsecase{
 		cfg:             cfg,
 		usersRepository: userRepository,
+		mailer:          mailer,
+		lockouts:        lockouts,
+		oidc:            oidc,
+		filesUsecase:    filesUsecase,
 	}
 }
 
@@ -42,6 +121,9 @@ func (u usersUsecase) InsertAdmin(req *users.UserRegisterReq) (*users.UserPasspo
 		return nil, err
 	}
 
+	if _, err := u.sendVerification(result.User, 0); err != nil {
+		log.Printf("send verification to user %s failed: %v", result.User.ID, err)
+	}
 	return result, nil
 }
 
@@ -57,64 +139,124 @@ func (u usersUsecase) InsertCustomer(req *users.UserRegisterReq) (*users.UserPas
 		return nil, err
 	}
 
+	if _, err := u.sendVerification(result.User, 0); err != nil {
+		log.Printf("send verification to user %s failed: %v", result.User.ID, err)
+	}
 	return result, nil
 }
 
 func (u usersUsecase) GetPassport(req *users.UserCredential) (*users.UserPassport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// Locked accounts and ips are refused before bcrypt runs
+	userKey := usersLockouts.UserKey(strings.ToLower(strings.TrimSpace(req.Email)))
+	if err := u.checkLockout(ctx, userKey, req.IP); err != nil {
+		return nil, err
+	}
+
 	// Find user
 	user, err := u.usersRepository.FindOneUserByEmail(req.Email)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			if err := u.failSignIn(ctx, userKey, req.IP); err != nil {
+				return nil, err
+			}
+		}
 		return nil, err
 	}
 
 	// Compare password
 	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		if err := u.failSignIn(ctx, userKey, req.IP); err != nil {
+			return nil, err
+		}
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
-	// Sign tokens
-	accessToken, err := auth.NewAuth(auth.Access, u.cfg.Jwt(), &users.UserClaims{
-		ID:     user.ID,
-		RoleID: user.RoleID,
+	if user.Disabled {
+		return nil, usersRepositories.ErrUserDisabled
+	}
+
+	if u.cfg.App().VerifiedSignIn() && !user.Verified {
+		return nil, fmt.Errorf("email is not verified")
+	}
+
+	// The failures are kept until the code is right, a known password must
+	// not buy fresh guesses at the code
+	if user.TotpEnabled || (user.RoleID == middlewares.RoleAdmin && u.cfg.App().AdminTwoFactor()) {
+		return u.challenge(user)
+	}
+
+	// The ip keeps its failures, one valid account must not reset them
+	if err := u.lockouts.Reset(ctx, userKey); err != nil {
+		return nil, err
+	}
+
+	return u.issuePassport(&users.User{
+		ID:       user.ID,
+		Email:    user.Email,
+		Username: user.Username,
+		RoleID:   user.RoleID,
+		Verified: user.Verified,
+	}, &users.Session{
+		Device:    req.Device,
+		IP:        req.IP,
+		UserAgent: req.UserAgent,
 	})
+}
 
-	refreshToken, err := auth.NewAuth(auth.Refresh, u.cfg.Jwt(), &users.UserClaims{
+// issuePassport signs a token pair and starts the oauth session
+func (u usersUsecase) issuePassport(user *users.User, session *users.Session) (*users.UserPassport, error) {
+	claims := &users.UserClaims{
 		ID:     user.ID,
 		RoleID: user.RoleID,
-	})
+	}
+
+	// Sign tokens
+	accessToken, err := auth.NewAuth(auth.Access, u.cfg.Jwt(), claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// The oauth id is the family of the refresh tokens rotated from this one
+	oauthID := uuid.NewString()
+	refreshToken := auth.RepeatToken(u.cfg.Jwt(), claims, oauthID, time.Now().Add(time.Duration(u.cfg.Jwt().RefreshExpiresAt())*time.Second).Unix())
 
-	// Set password
 	passport := &users.UserPassport{
-		User: &users.User{
-			ID:       user.ID,
-			Email:    user.Email,
-			Username: user.Username,
-			RoleID:   user.RoleID,
-		},
+		User: user,
 		Token: &users.UserToken{
+			ID:           oauthID,
 			AccessToken:  accessToken.SignToken(),
-			RefreshToken: refreshToken.SignToken(),
+			RefreshToken: refreshToken,
 		},
 	}
 
 	// Insert oauth session
-	if err = u.usersRepository.InsertOauth(passport); err != nil {
+	if err = u.usersRepository.InsertOauth(passport, session, hashToken(refreshToken)); err != nil {
 		return nil, err
 	}
 
 	return passport, nil
 }
 
+// RefreshPassport trades a refresh token for a new pair, the used token is
+// void afterwards and replaying it revokes the session it belongs to
 func (u usersUsecase) RefreshPassport(req *users.UserRefreshCredential) (*users.UserPassport, error) {
 	// Parse token
-	claims, err := auth.ParseToken(u.cfg.Jwt(), req.RefreshToken)
+	claims, err := auth.ParseRefreshToken(u.cfg.Jwt(), req.RefreshToken)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check oauth
-	oauth, err := u.usersRepository.FindOneOauth(req.RefreshToken)
+	usedHash := hashToken(req.RefreshToken)
+	oauth, err := u.usersRepository.FindOneOauth(usedHash)
 	if err != nil {
+		// Signed by us but no longer current, it was rotated before
+		if errors.Is(err, usersRepositories.ErrOauthNotFound) && claims.Family != "" {
+			return nil, u.revokeFamily(claims.Family)
+		}
 		return nil, err
 	}
 
@@ -134,7 +276,8 @@ func (u usersUsecase) RefreshPassport(req *users.UserRefreshCredential) (*users.
 		return nil, err
 	}
 
-	refreshToken := auth.RepeatToken(u.cfg.Jwt(), newClaims, claims.ExpiresAt.Unix())
+	// The session keeps the expiry of its first refresh token
+	refreshToken := auth.RepeatToken(u.cfg.Jwt(), newClaims, oauth.ID, claims.ExpiresAt.Unix())
 
 	passport := &users.UserPassport{
 		User: profile,
@@ -145,21 +288,49 @@ func (u usersUsecase) RefreshPassport(req *users.UserRefreshCredential) (*users.
 		},
 	}
 
-	if err = u.usersRepository.UpdateOauth(passport.Token); err != nil {
-		if err != nil {
-			return nil, err
+	session := &users.Session{
+		IP:        req.IP,
+		UserAgent: req.UserAgent,
+	}
+	if err = u.usersRepository.UpdateOauth(passport.Token, session, usedHash, hashToken(refreshToken)); err != nil {
+		// Another refresh with the same token got there first
+		if errors.Is(err, usersRepositories.ErrOauthNotFound) {
+			return nil, u.revokeFamily(oauth.ID)
 		}
+		return nil, err
 	}
 
 	return passport, nil
 }
 
-func (u *usersUsecase) DeleteOauth(oauthID string) error {
+func (u usersUsecase) revokeFamily(oauthID string) error {
 	if err := u.usersRepository.DeleteOauth(oauthID); err != nil {
-		return err
+		log.Printf("revoke oauth %s failed: %v", oauthID, err)
 	}
 
-	return nil
+	return ErrRefreshTokenReused
+}
+
+// DeleteOauth signs out one session, sessions of other users are not found
+func (u *usersUsecase) DeleteOauth(userID, oauthID string) error {
+	return u.usersRepository.DeleteUserOauth(userID, oauthID)
+}
+
+func (u *usersUsecase) DeleteAllOauth(userID string) error {
+	return u.usersRepository.DeleteAllOauth(userID)
+}
+
+func (u *usersUsecase) FindSessions(userID, currentOauthID string) ([]*users.Session, error) {
+	sessions, err := u.usersRepository.FindSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, session := range sessions {
+		session.Current = session.ID == currentOauthID
+	}
+
+	return sessions, nil
 }
 
 func (u *usersUsecase) GetUserProfile(userID string) (*users.User, error) {
@@ -170,3 +341,222 @@ func (u *usersUsecase) GetUserProfile(userID string) (*users.User, error) {
 
 	return profile, nil
 }
+
+// hashToken keys reset and refresh tokens in the database, a leaked table
+// can not be used to reset passwords or refresh sessions
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// ForgotPassword mails a single use reset link. Unknown emails succeed
+// silently so the endpoint can not tell which accounts exist.
+func (u *usersUsecase) ForgotPassword(req *users.ForgotPasswordReq) error {
+	user, err := u.usersRepository.FindOneUserByEmail(req.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	return u.sendPasswordReset(&users.User{
+		ID:       user.ID,
+		Email:    user.Email,
+		Username: user.Username,
+	})
+}
+
+// sendPasswordReset stores a reset token and mails its link to the user
+func (u *usersUsecase) sendPasswordReset(user *users.User) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("generate reset token failed: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	expires := u.cfg.App().PasswordResetExpires()
+	if err := u.usersRepository.InsertPasswordReset(user.ID, hashToken(token), expires); err != nil {
+		return err
+	}
+
+	link, err := url.Parse(u.cfg.App().PasswordResetUrl())
+	if err != nil {
+		return fmt.Errorf("parse password reset url failed: %v", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	// The token is stored, a failed mail is logged and can be requested again
+	if err := u.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to choose a new password, it expires in %d minutes.\n\n%s\n\nIf you did not ask to reset your password, ignore this email.\n",
+			user.Username,
+			int(expires.Minutes()),
+			link.String(),
+		),
+	}); err != nil {
+		log.Printf("send password reset mail to user %s failed: %v", user.ID, err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets the new password and signs the user out everywhere
+func (u *usersUsecase) ResetPassword(req *users.ResetPasswordReq) error {
+	tokenHash := hashToken(req.Token)
+
+	if err := req.BcryptHashing(); err != nil {
+		return err
+	}
+
+	return u.usersRepository.ResetPassword(tokenHash, req.Password)
+}
+
+// sendVerification mails a signed verification link, false is returned when
+// throttled by interval. Mail failures are logged, the user can resend.
+func (u *usersUsecase) sendVerification(user *users.User, interval time.Duration) (bool, error) {
+	sent, err := u.usersRepository.MarkVerificationSent(user.ID, interval)
+	if err != nil || !sent {
+		return false, err
+	}
+
+	expires := u.cfg.App().EmailVerifyExpires()
+	token := auth.SignEmailToken(u.cfg.Jwt(), user.ID, user.Email, expires)
+
+	link, err := url.Parse(u.cfg.App().EmailVerifyUrl())
+	if err != nil {
+		return false, fmt.Errorf("parse email verify url failed: %v", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	if err := u.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nConfirm your email with the link below, it expires in %d hours.\n\n%s\n\nIf you did not sign up, ignore this email.\n",
+			user.Username,
+			int(expires.Hours()),
+			link.String(),
+		),
+	}); err != nil {
+		log.Printf("send verification mail to user %s failed: %v", user.ID, err)
+	}
+
+	return true, nil
+}
+
+func (u *usersUsecase) VerifyEmail(token string) error {
+	claims, err := auth.ParseEmailToken(u.cfg.Jwt(), token)
+	if err != nil {
+		return err
+	}
+
+	return u.usersRepository.VerifyEmail(claims.UserID, claims.Email)
+}
+
+// ResendVerification is silent for unknown and verified emails, like ForgotPassword
+func (u *usersUsecase) ResendVerification(req *users.ResendVerificationReq) error {
+	user, err := u.usersRepository.FindOneUserByEmail(req.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if user.Verified {
+		return nil
+	}
+
+	sent, err := u.sendVerification(&users.User{
+		ID:       user.ID,
+		Email:    user.Email,
+		Username: user.Username,
+	}, u.cfg.App().EmailVerifyResendInterval())
+	if err != nil {
+		return err
+	}
+	if !sent {
+		return ErrVerificationThrottled
+	}
+
+	return nil
+}
+
+// checkLockout refuses the account and the ip while either is locked
+func (u *usersUsecase) checkLockout(ctx context.Context, userKey, ip string) error {
+	for _, key := range []string{userKey, usersLockouts.IpKey(ip)} {
+		attempt, err := u.lockouts.Find(ctx, key)
+		if err != nil {
+			return err
+		}
+		if attempt.RetryAfter > 0 {
+			return &LockedError{RetryAfter: attempt.RetryAfter}
+		}
+	}
+
+	return nil
+}
+
+// failSignIn counts a failed sign-in for the account and the ip, a LockedError
+// is returned when this failure locks either of them
+func (u *usersUsecase) failSignIn(ctx context.Context, userKey, ip string) error {
+	cfg := u.cfg.Lockout()
+
+	attempts := []struct {
+		key       string
+		threshold int
+	}{
+		{key: userKey, threshold: cfg.Threshold()},
+		{key: usersLockouts.IpKey(ip), threshold: cfg.IpThreshold()},
+	}
+
+	var retryAfter time.Duration
+	for _, a := range attempts {
+		attempt, err := u.lockouts.Fail(ctx, a.key, cfg.Window(), usersLockouts.Backoff(a.threshold, cfg.Duration(), cfg.MaxDuration()))
+		if err != nil {
+			return err
+		}
+		retryAfter = max(retryAfter, attempt.RetryAfter)
+	}
+
+	if retryAfter > 0 {
+		return &LockedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+func (u *usersUsecase) UnlockUser(req *users.UnlockReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if req.UserID != "" {
+		profile, err := u.usersRepository.GetProfile(req.UserID)
+		if err != nil {
+			return err
+		}
+
+		if err := u.lockouts.Reset(ctx, usersLockouts.UserKey(strings.ToLower(profile.Email))); err != nil {
+			return err
+		}
+	}
+
+	if req.IP != "" {
+		if err := u.lockouts.Reset(ctx, usersLockouts.IpKey(req.IP)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package usersUsecases
+
+import (
+	"errors"
+	"github.com/korvised/go-ecommerce/config"
+	"github.com/korvised/go-ecommerce/modules/users"
+	"github.com/korvised/go-ecommerce/modules/users/userRepositories"
+	"github.com/korvised/go-ecommerce/pkg/auth"
+	"testing"
+	"time"
+)
+
+type testJwtConfig struct {
+	config.IJwtConfig
+}
+
+func (c *testJwtConfig) SecretKey() []byte     { return []byte("secret-1") }
+func (c *testJwtConfig) AccessExpiresAt() int  { return 60 }
+func (c *testJwtConfig) RefreshExpiresAt() int { return 3600 }
+func (c *testJwtConfig) Algorithm() string     { return config.JwtHS256 }
+
+type testConfig struct {
+	config.IConfig
+}
+
+func (c *testConfig) Jwt() config.IJwtConfig { return &testJwtConfig{} }
+
+// testOauthRepository keeps one refresh token hash per session, UpdateOauth
+// only rotates the hash that is still current like the sql does
+type testOauthRepository struct {
+	usersRepositories.IUsersRepository
+	sessions     map[string]string // oauth id -> refresh token hash
+	revoked      []string
+	beforeUpdate func()
+}
+
+func (r *testOauthRepository) FindOneOauth(refreshHash string) (*users.Oauth, error) {
+	for id, hash := range r.sessions {
+		if hash == refreshHash {
+			return &users.Oauth{ID: id, UserID: "user-1"}, nil
+		}
+	}
+	return nil, usersRepositories.ErrOauthNotFound
+}
+
+func (r *testOauthRepository) GetProfile(userID string) (*users.User, error) {
+	return &users.User{ID: userID, RoleID: 1}, nil
+}
+
+func (r *testOauthRepository) UpdateOauth(req *users.UserToken, session *users.Session, usedHash, refreshHash string) error {
+	if r.beforeUpdate != nil {
+		r.beforeUpdate()
+	}
+	if r.sessions[req.ID] != usedHash {
+		return usersRepositories.ErrOauthNotFound
+	}
+	r.sessions[req.ID] = refreshHash
+	return nil
+}
+
+func (r *testOauthRepository) DeleteOauth(oauthID string) error {
+	delete(r.sessions, oauthID)
+	r.revoked = append(r.revoked, oauthID)
+	return nil
+}
+
+// newTestSession signs the first refresh token of oauth-1
+func newTestSession(cfg config.IConfig, repo *testOauthRepository) string {
+	token := auth.RepeatToken(cfg.Jwt(), &users.UserClaims{ID: "user-1", RoleID: 1}, "oauth-1", time.Now().Add(time.Hour).Unix())
+	repo.sessions = map[string]string{"oauth-1": hashToken(token)}
+	return token
+}
+
+func TestRefreshPassport(t *testing.T) {
+	cfg := &testConfig{}
+	repo := &testOauthRepository{}
+	usecase := &usersUsecase{cfg: cfg, usersRepository: repo}
+
+	first := newTestSession(cfg, repo)
+
+	passport, err := usecase.RefreshPassport(&users.UserRefreshCredential{RefreshToken: first})
+	if err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+	if passport.Token.ID != "oauth-1" || passport.Token.RefreshToken == first {
+		t.Errorf("expect: %s, got: %+v", "a new refresh token of oauth-1", passport.Token)
+	}
+	if repo.sessions["oauth-1"] != hashToken(passport.Token.RefreshToken) {
+		t.Errorf("expect: %s, got: %s", "the new token to be current", repo.sessions["oauth-1"])
+	}
+
+	// The rotated token keeps working
+	second, err := usecase.RefreshPassport(&users.UserRefreshCredential{RefreshToken: passport.Token.RefreshToken})
+	if err != nil {
+		t.Fatalf("expect: %v, got: %v", nil, err)
+	}
+
+	// Replaying the first token revokes the whole session
+	if _, err := usecase.RefreshPassport(&users.UserRefreshCredential{RefreshToken: first}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("expect: %v, got: %v", ErrRefreshTokenReused, err)
+	}
+	if len(repo.revoked) != 1 || repo.revoked[0] != "oauth-1" {
+		t.Errorf("expect: %v, got: %v", []string{"oauth-1"}, repo.revoked)
+	}
+
+	// So the latest token of the session is void too
+	if _, err := usecase.RefreshPassport(&users.UserRefreshCredential{RefreshToken: second.Token.RefreshToken}); err == nil {
+		t.Errorf("expect: %s, got: %v", "error", nil)
+	}
+}
+
+func TestRefreshPassportRace(t *testing.T) {
+	cfg := &testConfig{}
+	repo := &testOauthRepository{}
+	usecase := &usersUsecase{cfg: cfg, usersRepository: repo}
+
+	token := newTestSession(cfg, repo)
+
+	// Another refresh with the same token rotates it after it was found
+	repo.beforeUpdate = func() {
+		repo.sessions["oauth-1"] = hashToken("rotated")
+	}
+
+	if _, err := usecase.RefreshPassport(&users.UserRefreshCredential{RefreshToken: token}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("expect: %v, got: %v", ErrRefreshTokenReused, err)
+	}
+	if _, ok := repo.sessions["oauth-1"]; ok {
+		t.Errorf("expect: %s, got: %v", "oauth-1 revoked", repo.sessions)
+	}
+}
+
+func TestRefreshPassportInvalidToken(t *testing.T) {
+	cfg := &testConfig{}
+	repo := &testOauthRepository{}
+	usecase := &usersUsecase{cfg: cfg, usersRepository: repo}
+
+	newTestSession(cfg, repo)
+
+	// An access token is not a refresh token
+	access, _ := auth.NewAuth(auth.Access, cfg.Jwt(), &users.UserClaims{ID: "user-1", RoleID: 1})
+	if _, err := usecase.RefreshPassport(&users.UserRefreshCredential{RefreshToken: access.SignToken()}); err == nil {
+		t.Errorf("expect: %s, got: %v", "error", nil)
+	}
+	if len(repo.revoked) != 0 {
+		t.Errorf("expect: %v, got: %v", []string{}, repo.revoked)
+	}
+}
@@ -1,8 +1,6 @@
 package auth
 
 import (
-	"errors"
-	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/korvised/go-ecommerce/config"
 	"time"
@@ -17,34 +15,11 @@ type adminAuth struct {
 }
 
 func (a adminAuth) SignToken() string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, a.mapClaims)
-	ss, _ := token.SignedString(a.cfg.AdminKey())
-	return ss
+	return signClaims(a.cfg, a.cfg.AdminKey(), a.mapClaims)
 }
 
 func ParseAdminToken(cfg config.IJwtConfig, tokenString string) (*MapClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &MapClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("signing method is invalid")
-		}
-
-		return cfg.AdminKey(), nil
-	})
-	if err != nil {
-		if errors.Is(err, jwt.ErrTokenMalformed) {
-			return nil, fmt.Errorf("token format is invalid")
-		} else if errors.Is(err, jwt.ErrTokenExpired) {
-			return nil, fmt.Errorf("token had expired")
-		} else {
-			return nil, fmt.Errorf("parse token failed: %v", err)
-		}
-	}
-
-	if claims, ok := token.Claims.(*MapClaims); ok {
-		return claims, nil
-	} else {
-		return nil, fmt.Errorf("claims type is invalid")
-	}
+	return parseClaims(cfg, cfg.AdminKey(), tokenString, "admin-token")
 }
 
 func newAdminToken(cfg config.IJwtConfig) IAuth {
@@ -1,8 +1,6 @@
 package auth
 
 import (
-	"errors"
-	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/korvised/go-ecommerce/config"
 	"time"
@@ -13,9 +11,7 @@ type apikey struct {
 }
 
 func (a apikey) SignToken() string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, a.mapClaims)
-	ss, _ := token.SignedString(a.cfg.ApiKey())
-	return ss
+	return signClaims(a.cfg, a.cfg.ApiKey(), a.mapClaims)
 }
 
 func newApiKey(cfg config.IJwtConfig) IAuth {
@@ -38,26 +34,5 @@ func newApiKey(cfg config.IJwtConfig) IAuth {
 }
 
 func ParseApiKey(cfg config.IJwtConfig, tokenString string) (*MapClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &MapClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("signing method is invalid")
-		}
-
-		return cfg.ApiKey(), nil
-	})
-	if err != nil {
-		if errors.Is(err, jwt.ErrTokenMalformed) {
-			return nil, fmt.Errorf("token format is invalid")
-		} else if errors.Is(err, jwt.ErrTokenExpired) {
-			return nil, fmt.Errorf("token had expired")
-		} else {
-			return nil, fmt.Errorf("parse token failed: %v", err)
-		}
-	}
-
-	if claims, ok := token.Claims.(*MapClaims); ok {
-		return claims, nil
-	} else {
-		return nil, fmt.Errorf("claims type is invalid")
-	}
+	return parseClaims(cfg, cfg.ApiKey(), tokenString, "api-key")
 }
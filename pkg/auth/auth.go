@@ -1,9 +1,9 @@
 package auth
 
 import (
-	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/korvised/go-ecommerce/config"
 	"github.com/korvised/go-ecommerce/modules/users"
 	"math"
@@ -13,10 +13,9 @@ import (
 type TokenType string
 
 const (
-	Access  TokenType = "access"
-	Refresh TokenType = "refresh"
-	Admin   TokenType = "admin"
-	ApiKey  TokenType = "apiKey"
+	Access TokenType = "access"
+	Admin  TokenType = "admin"
+	ApiKey TokenType = "apiKey"
 )
 
 type IAuth interface {
@@ -29,7 +28,8 @@ type auth struct {
 }
 
 type MapClaims struct {
-	Claims *users.UserClaims `json:"claims"` // mean payload
+	Claims *users.UserClaims `json:"claims"`           // mean payload
+	Family string            `json:"family,omitempty"` // refresh tokens only, the oauth session they rotate in
 	jwt.RegisteredClaims
 }
 
@@ -45,8 +45,6 @@ func NewAuth(tokenType TokenType, cfg config.IJwtConfig, claims *users.UserClaim
 	switch tokenType {
 	case Access:
 		return newAccessToken(cfg, claims), nil
-	case Refresh:
-		return newRefreshToken(cfg, claims), nil
 	case Admin:
 		return newAdminToken(cfg), nil
 	case ApiKey:
@@ -73,60 +71,29 @@ func newAccessToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
 	}
 }
 
-func newRefreshToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
-	return &auth{
-		cfg: cfg,
-		mapClaims: &MapClaims{
-			Claims: claims,
-			RegisteredClaims: jwt.RegisteredClaims{
-				Issuer:    "ecommerce-api",
-				Subject:   "refresh-token",
-				Audience:  []string{"customer", "admin"},
-				ExpiresAt: jwtTimeDurationCal(cfg.RefreshExpiresAt()),
-				NotBefore: jwt.NewNumericDate(time.Now()),
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-			},
-		},
-	}
-}
-
 func (a auth) SignToken() string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, a.mapClaims)
-	ss, _ := token.SignedString(a.cfg.SecretKey())
-	return ss
+	return signClaims(a.cfg, a.cfg.SecretKey(), a.mapClaims)
 }
 
+// ParseToken verifies access tokens only, refresh tokens never pass it
 func ParseToken(cfg config.IJwtConfig, tokenString string) (*MapClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &MapClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("signing method is invalid")
-		}
-
-		return cfg.SecretKey(), nil
-	})
-	if err != nil {
-		if errors.Is(err, jwt.ErrTokenMalformed) {
-			return nil, fmt.Errorf("token format is invalid")
-		} else if errors.Is(err, jwt.ErrTokenExpired) {
-			return nil, fmt.Errorf("token had expired")
-		} else {
-			return nil, fmt.Errorf("parse token failed: %v", err)
-		}
-	}
+	return parseClaims(cfg, cfg.SecretKey(), tokenString, "access-token")
+}
 
-	if claims, ok := token.Claims.(*MapClaims); ok {
-		return claims, nil
-	} else {
-		return nil, fmt.Errorf("claims type is invalid")
-	}
+func ParseRefreshToken(cfg config.IJwtConfig, tokenString string) (*MapClaims, error) {
+	return parseClaims(cfg, cfg.SecretKey(), tokenString, "refresh-token")
 }
 
-func RepeatToken(cfg config.IJwtConfig, claims *users.UserClaims, exp int64) string {
+// RepeatToken signs a refresh token of the family expiring at exp, the jti
+// makes every token of a family unique so a used one can be told apart
+func RepeatToken(cfg config.IJwtConfig, claims *users.UserClaims, family string, exp int64) string {
 	obj := &auth{
 		cfg: cfg,
 		mapClaims: &MapClaims{
 			Claims: claims,
+			Family: family,
 			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        uuid.NewString(),
 				Issuer:    "ecommerce-api",
 				Subject:   "refresh-token",
 				Audience:  []string{"customer", "admin"},
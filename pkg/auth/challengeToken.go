@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korvised/go-ecommerce/config"
+	"time"
+)
+
+// ChallengeClaims prove the password step of a two-factor sign-in passed,
+// Enrol is only set by an enrolment link and lets the code confirm a pending
+// secret
+type ChallengeClaims struct {
+	UserID string `json:"user_id"`
+	Enrol  bool   `json:"enrol,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func SignChallengeToken(cfg config.IJwtConfig, userID string, enrol bool, expires time.Duration) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &ChallengeClaims{
+		UserID: userID,
+		Enrol:  enrol,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "ecommerce-api",
+			Subject:   "two-factor-challenge",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expires)),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	ss, _ := token.SignedString(derivedKey(cfg, "two-factor-challenge"))
+	return ss
+}
+
+func ParseChallengeToken(cfg config.IJwtConfig, tokenString string) (*ChallengeClaims, error) {
+	claims := &ChallengeClaims{}
+	if err := parseDerived(cfg, "two-factor-challenge", tokenString, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korvised/go-ecommerce/config"
+	"time"
+)
+
+// EmailClaims proves the user owns the email, the token is void once the email changes
+type EmailClaims struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// derivedKey is derived from the token key per purpose, so email and
+// challenge tokens never pass as access tokens or as each other
+func derivedKey(cfg config.IJwtConfig, purpose string) []byte {
+	mac := hmac.New(sha256.New, cfg.TokenKey())
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// parseDerived verifies a token signed with the derived key of purpose into
+// claims, its subject has to be the purpose as well
+func parseDerived(cfg config.IJwtConfig, purpose, tokenString string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return derivedKey(cfg, purpose), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenMalformed) {
+			return fmt.Errorf("token format is invalid")
+		} else if errors.Is(err, jwt.ErrTokenExpired) {
+			return fmt.Errorf("token had expired")
+		} else {
+			return fmt.Errorf("parse token failed: %v", err)
+		}
+	}
+
+	if subject, _ := claims.GetSubject(); subject != purpose {
+		return fmt.Errorf("token type is invalid")
+	}
+
+	return nil
+}
+
+func SignEmailToken(cfg config.IJwtConfig, userID, email string, expires time.Duration) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &EmailClaims{
+		UserID: userID,
+		Email:  email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "ecommerce-api",
+			Subject:   "email-verification",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expires)),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	ss, _ := token.SignedString(derivedKey(cfg, "email-verification"))
+	return ss
+}
+
+func ParseEmailToken(cfg config.IJwtConfig, tokenString string) (*EmailClaims, error) {
+	claims := &EmailClaims{}
+	if err := parseDerived(cfg, "email-verification", tokenString, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
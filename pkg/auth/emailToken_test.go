@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korvised/go-ecommerce/config"
+	"testing"
+	"time"
+)
+
+type testJwtConfig struct {
+	config.IJwtConfig
+}
+
+func (c *testJwtConfig) TokenKey() []byte { return []byte("token-key-1") }
+
+type testParseDerived struct {
+	name  string
+	token func(cfg config.IJwtConfig) string
+	isErr bool
+}
+
+func TestParseDerived(t *testing.T) {
+	cfg := &testJwtConfig{}
+
+	sign := func(method jwt.SigningMethod, subject string, key []byte, expires time.Duration) func(cfg config.IJwtConfig) string {
+		return func(cfg config.IJwtConfig) string {
+			ss, _ := jwt.NewWithClaims(method, &EmailClaims{
+				UserID: "user-1",
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   subject,
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(expires)),
+				},
+			}).SignedString(key)
+			return ss
+		}
+	}
+
+	tests := []testParseDerived{
+		{
+			name: "email token",
+			token: func(cfg config.IJwtConfig) string {
+				return SignEmailToken(cfg, "user-1", "user@example.com", time.Minute)
+			},
+		},
+		{
+			name: "challenge token",
+			token: func(cfg config.IJwtConfig) string {
+				return SignChallengeToken(cfg, "user-1", false, time.Minute)
+			},
+			isErr: true,
+		},
+		{
+			name:  "other subject with the email key",
+			token: sign(jwt.SigningMethodHS256, "two-factor-challenge", derivedKey(cfg, "email-verification"), time.Minute),
+			isErr: true,
+		},
+		{
+			name:  "signed with the token key",
+			token: sign(jwt.SigningMethodHS256, "email-verification", cfg.TokenKey(), time.Minute),
+			isErr: true,
+		},
+		{
+			name:  "hs512",
+			token: sign(jwt.SigningMethodHS512, "email-verification", derivedKey(cfg, "email-verification"), time.Minute),
+			isErr: true,
+		},
+		{
+			name:  "expired",
+			token: sign(jwt.SigningMethodHS256, "email-verification", derivedKey(cfg, "email-verification"), -time.Minute),
+			isErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		claims, err := ParseEmailToken(cfg, test.token(cfg))
+		if test.isErr {
+			if err == nil {
+				t.Errorf("%s: expect: %s, got: %v", test.name, "error", nil)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: expect: %v, got: %v", test.name, nil, err)
+			continue
+		}
+		if claims.UserID != "user-1" || claims.Email != "user@example.com" {
+			t.Errorf("%s: expect: %s, got: %+v", test.name, "claims of user-1", claims)
+		}
+	}
+}
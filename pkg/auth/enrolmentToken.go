@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korvised/go-ecommerce/config"
+	"time"
+)
+
+// EnrolmentClaims let a user who must use two-factor enrol before signing in,
+// the token is mailed on request of an admin and void once the email changes
+type EnrolmentClaims struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+func SignEnrolmentToken(cfg config.IJwtConfig, userID, email string, expires time.Duration) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &EnrolmentClaims{
+		UserID: userID,
+		Email:  email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "ecommerce-api",
+			Subject:   "two-factor-enrolment",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expires)),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	ss, _ := token.SignedString(derivedKey(cfg, "two-factor-enrolment"))
+	return ss
+}
+
+func ParseEnrolmentToken(cfg config.IJwtConfig, tokenString string) (*EnrolmentClaims, error) {
+	claims := &EnrolmentClaims{}
+	if err := parseDerived(cfg, "two-factor-enrolment", tokenString, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"github.com/korvised/go-ecommerce/config"
+	"math/big"
+	"sort"
+)
+
+// Jwk is a public verification key as described by RFC 7517
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA
+	E   string `json:"e,omitempty"`   // RSA
+	Crv string `json:"crv,omitempty"` // OKP
+	X   string `json:"x,omitempty"`   // OKP
+}
+
+type JwkSet struct {
+	Keys []*Jwk `json:"keys"`
+}
+
+// Jwks lists every key tokens are verified with, retired keys included until
+// their file is removed. It is empty in HS256 mode, secrets are never published.
+func Jwks(cfg config.IJwtConfig) *JwkSet {
+	set := &JwkSet{Keys: make([]*Jwk, 0)}
+
+	for kid, key := range cfg.PublicKeys() {
+		jwk := &Jwk{
+			Kid: kid,
+			Use: "sig",
+			Alg: cfg.Algorithm(),
+		}
+
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korvised/go-ecommerce/config"
+	"slices"
+)
+
+// signingMethod maps the configured algorithm, HS256 keeps a secret per token type
+func signingMethod(cfg config.IJwtConfig) jwt.SigningMethod {
+	switch cfg.Algorithm() {
+	case config.JwtRS256:
+		return jwt.SigningMethodRS256
+	case config.JwtEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// signClaims signs with the hmac secret in HS256 mode, otherwise with the
+// signing key whose kid goes into the header
+func signClaims(cfg config.IJwtConfig, secret []byte, claims jwt.Claims) string {
+	token := jwt.NewWithClaims(signingMethod(cfg), claims)
+
+	var key any = secret
+	if cfg.Algorithm() != config.JwtHS256 {
+		kid, signer := cfg.SigningKey()
+		token.Header["kid"] = kid
+		key = signer
+	}
+
+	ss, _ := token.SignedString(key)
+	return ss
+}
+
+// parseClaims verifies a token of one of the subjects, the asymmetric keys
+// are shared by every token type so the subject keeps them apart
+func parseClaims(cfg config.IJwtConfig, secret []byte, tokenString string, subjects ...string) (*MapClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &MapClaims{}, func(t *jwt.Token) (interface{}, error) {
+		if cfg.Algorithm() == config.JwtHS256 {
+			return secret, nil
+		}
+
+		kid, _ := t.Header["kid"].(string)
+		key, ok := cfg.PublicKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("signing key is unknown")
+		}
+		return key, nil
+	}, jwt.WithValidMethods([]string{signingMethod(cfg).Alg()}))
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenMalformed) {
+			return nil, fmt.Errorf("token format is invalid")
+		} else if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, fmt.Errorf("token had expired")
+		} else {
+			return nil, fmt.Errorf("parse token failed: %v", err)
+		}
+	}
+
+	claims, ok := token.Claims.(*MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("claims type is invalid")
+	}
+
+	if !slices.Contains(subjects, claims.Subject) {
+		return nil, fmt.Errorf("token type is invalid")
+	}
+
+	return claims, nil
+}
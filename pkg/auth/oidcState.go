@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korvised/go-ecommerce/config"
+	"time"
+)
+
+// OidcStateClaims carry a social sign-in from the authorize redirect to the
+// callback. The token stays with the frontend and never goes through the
+// provider, only State does, so the pkce verifier is not leaked in urls.
+type OidcStateClaims struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+func SignOidcStateToken(cfg config.IJwtConfig, claims *OidcStateClaims, expires time.Duration) string {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    "ecommerce-api",
+		Subject:   "oidc-state",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expires)),
+		NotBefore: jwt.NewNumericDate(time.Now()),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	ss, _ := token.SignedString(derivedKey(cfg, "oidc-state"))
+	return ss
+}
+
+func ParseOidcStateToken(cfg config.IJwtConfig, tokenString string) (*OidcStateClaims, error) {
+	claims := &OidcStateClaims{}
+	if err := parseDerived(cfg, "oidc-state", tokenString, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
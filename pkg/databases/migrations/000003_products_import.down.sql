@@ -0,0 +1,12 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_products_imports_table ON "products_imports";
+
+DROP TABLE IF EXISTS "products_imports" CASCADE;
+
+ALTER TABLE "products"
+    DROP COLUMN IF EXISTS "stock";
+
+DROP TYPE IF EXISTS "import_status";
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,37 @@
+BEGIN;
+
+--Create enum
+CREATE TYPE "import_status" AS ENUM (
+    'pending',
+    'processing',
+    'completed',
+    'failed'
+);
+
+ALTER TABLE "products"
+    ADD COLUMN "stock" INT NOT NULL DEFAULT 0;
+
+CREATE TABLE "products_imports"
+(
+    "id"           uuid          NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+    "user_id"      VARCHAR       NOT NULL,
+    "filename"     VARCHAR       NOT NULL,
+    "format"       VARCHAR       NOT NULL,
+    "status"       import_status NOT NULL                    DEFAULT 'pending',
+    "total_rows"   INT           NOT NULL                    DEFAULT 0,
+    "success_rows" INT           NOT NULL                    DEFAULT 0,
+    "failed_rows"  INT           NOT NULL                    DEFAULT 0,
+    "report"       jsonb         NOT NULL                    DEFAULT '[]'::jsonb,
+    "created_at"   TIMESTAMP     NOT NULL                    DEFAULT now(),
+    "updated_at"   TIMESTAMP     NOT NULL                    DEFAULT now()
+);
+
+ALTER TABLE "products_imports"
+    ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+CREATE TRIGGER set_updated_at_timestamp_products_imports_table
+    BEFORE UPDATE
+    ON "products_imports"
+    FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
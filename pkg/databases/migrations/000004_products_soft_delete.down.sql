@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "products_deleted_at_idx";
+
+ALTER TABLE "products"
+    DROP COLUMN IF EXISTS "deleted_at";
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE "products"
+    ADD COLUMN "deleted_at" TIMESTAMP;
+
+CREATE INDEX "products_deleted_at_idx" ON "products" ("deleted_at");
+
+COMMIT;
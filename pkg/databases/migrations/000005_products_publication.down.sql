@@ -0,0 +1,12 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "products_status_idx";
+
+ALTER TABLE "products"
+    DROP COLUMN IF EXISTS "status",
+    DROP COLUMN IF EXISTS "publish_at",
+    DROP COLUMN IF EXISTS "unpublish_at";
+
+DROP TYPE IF EXISTS "product_status";
+
+COMMIT;
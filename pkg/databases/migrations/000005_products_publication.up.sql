@@ -0,0 +1,21 @@
+BEGIN;
+
+--Create enum
+CREATE TYPE "product_status" AS ENUM (
+    'draft',
+    'published',
+    'archived'
+);
+
+--Existing products stay visible, new products start as draft
+ALTER TABLE "products"
+    ADD COLUMN "status"       product_status NOT NULL DEFAULT 'published',
+    ADD COLUMN "publish_at"   TIMESTAMP,
+    ADD COLUMN "unpublish_at" TIMESTAMP;
+
+ALTER TABLE "products"
+    ALTER COLUMN "status" SET DEFAULT 'draft';
+
+CREATE INDEX "products_status_idx" ON "products" ("status");
+
+COMMIT;
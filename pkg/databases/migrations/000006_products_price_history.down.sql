@@ -0,0 +1,10 @@
+BEGIN;
+
+DROP TABLE IF EXISTS "products_price_history" CASCADE;
+
+ALTER TABLE "products"
+    DROP COLUMN IF EXISTS "sale_price",
+    DROP COLUMN IF EXISTS "sale_starts_at",
+    DROP COLUMN IF EXISTS "sale_ends_at";
+
+COMMIT;
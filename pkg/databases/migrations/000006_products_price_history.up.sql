@@ -0,0 +1,29 @@
+BEGIN;
+
+ALTER TABLE "products"
+    ADD COLUMN "sale_price"     FLOAT,
+    ADD COLUMN "sale_starts_at" TIMESTAMP,
+    ADD COLUMN "sale_ends_at"   TIMESTAMP;
+
+CREATE TABLE "products_price_history"
+(
+    "id"             uuid      NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+    "product_id"     VARCHAR   NOT NULL,
+    "price"          FLOAT     NOT NULL,
+    "sale_price"     FLOAT,
+    "sale_starts_at" TIMESTAMP,
+    "sale_ends_at"   TIMESTAMP,
+    "created_at"     TIMESTAMP NOT NULL                    DEFAULT now()
+);
+
+ALTER TABLE "products_price_history"
+    ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+
+CREATE INDEX "products_price_history_product_id_idx" ON "products_price_history" ("product_id", "created_at");
+
+--Initial price of existing products
+INSERT INTO "products_price_history" ("product_id", "price")
+SELECT "id", "price"
+FROM "products";
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "images_product_id_primary_idx";
+DROP INDEX IF EXISTS "images_product_id_position_idx";
+
+ALTER TABLE "images"
+    DROP COLUMN IF EXISTS "position",
+    DROP COLUMN IF EXISTS "is_primary",
+    DROP COLUMN IF EXISTS "alt_text";
+
+COMMIT;
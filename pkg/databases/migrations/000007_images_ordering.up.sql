@@ -0,0 +1,20 @@
+BEGIN;
+
+ALTER TABLE "images"
+    ADD COLUMN "position"   INT     NOT NULL DEFAULT 0,
+    ADD COLUMN "is_primary" BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN "alt_text"   VARCHAR NOT NULL DEFAULT '';
+
+--Keep the current order of existing images, the first one becomes the cover
+UPDATE "images" "i"
+SET "position"   = "o"."position",
+    "is_primary" = "o"."position" = 0
+FROM (SELECT "id",
+             ROW_NUMBER() OVER (PARTITION BY "product_id" ORDER BY "created_at", "filename") - 1 AS "position"
+      FROM "images") AS "o"
+WHERE "o"."id" = "i"."id";
+
+CREATE INDEX "images_product_id_position_idx" ON "images" ("product_id", "position");
+CREATE UNIQUE INDEX "images_product_id_primary_idx" ON "images" ("product_id") WHERE "is_primary";
+
+COMMIT;
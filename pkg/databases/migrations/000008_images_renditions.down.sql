@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE "images"
+    DROP COLUMN IF EXISTS "renditions";
+
+COMMIT;
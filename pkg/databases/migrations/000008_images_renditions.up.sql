@@ -0,0 +1,7 @@
+BEGIN;
+
+--Rendition urls generated on upload, e.g. [{"name": "thumbnail", "format": "webp", "url": "..."}]
+ALTER TABLE "images"
+    ADD COLUMN "renditions" JSONB NOT NULL DEFAULT '[]'::JSONB;
+
+COMMIT;
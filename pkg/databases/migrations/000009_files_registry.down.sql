@@ -0,0 +1,10 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS files_ref_count_orders_table ON "orders";
+DROP TRIGGER IF EXISTS files_ref_count_images_table ON "images";
+DROP FUNCTION IF EXISTS orders_files_ref_count();
+DROP FUNCTION IF EXISTS images_files_ref_count();
+
+DROP TABLE IF EXISTS "files";
+
+COMMIT;
@@ -0,0 +1,75 @@
+BEGIN;
+
+CREATE TABLE "files"
+(
+    "id"           uuid      NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+    "owner_id"     VARCHAR,
+    "storage_key"  VARCHAR   NOT NULL UNIQUE,
+    "filename"     VARCHAR   NOT NULL,
+    "url"          VARCHAR   NOT NULL,
+    "content_type" VARCHAR   NOT NULL DEFAULT '',
+    "size"         BIGINT    NOT NULL DEFAULT 0,
+    "checksum"     VARCHAR   NOT NULL DEFAULT '',
+    "private"      BOOLEAN   NOT NULL DEFAULT FALSE,
+    "ref_count"    INT       NOT NULL DEFAULT 0,
+    "created_at"   TIMESTAMP NOT NULL DEFAULT now(),
+    "updated_at"   TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "files"
+    ADD FOREIGN KEY ("owner_id") REFERENCES "users" ("id") ON DELETE SET NULL;
+
+CREATE INDEX "files_url_idx" ON "files" ("url");
+CREATE INDEX "files_orphan_idx" ON "files" ("created_at") WHERE "ref_count" = 0;
+
+CREATE TRIGGER set_updated_at_timestamp_files_table
+    BEFORE UPDATE
+    ON "files"
+    FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+--Reference counting, product images are matched by url and transfer slips by storage key or url
+CREATE
+OR REPLACE FUNCTION images_files_ref_count()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE "files" SET "ref_count" = GREATEST("ref_count" - 1, 0) WHERE "url" = OLD."url";
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE "files" SET "ref_count" = "ref_count" + 1 WHERE "url" = NEW."url";
+    END IF;
+RETURN NULL;
+END;
+$$
+language 'plpgsql';
+
+CREATE TRIGGER files_ref_count_images_table
+    AFTER INSERT OR DELETE OR UPDATE OF "url"
+    ON "images"
+    FOR EACH ROW EXECUTE PROCEDURE images_files_ref_count();
+
+CREATE
+OR REPLACE FUNCTION orders_files_ref_count()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND OLD."transfer_slip" IS NOT NULL THEN
+        UPDATE "files" SET "ref_count" = GREATEST("ref_count" - 1, 0)
+        WHERE "storage_key" = OLD."transfer_slip"->>'destination'
+           OR "url" = OLD."transfer_slip"->>'url';
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW."transfer_slip" IS NOT NULL THEN
+        UPDATE "files" SET "ref_count" = "ref_count" + 1
+        WHERE "storage_key" = NEW."transfer_slip"->>'destination'
+           OR "url" = NEW."transfer_slip"->>'url';
+    END IF;
+RETURN NULL;
+END;
+$$
+language 'plpgsql';
+
+CREATE TRIGGER files_ref_count_orders_table
+    AFTER INSERT OR DELETE OR UPDATE OF "transfer_slip"
+    ON "orders"
+    FOR EACH ROW EXECUTE PROCEDURE orders_files_ref_count();
+
+COMMIT;
@@ -0,0 +1,32 @@
+BEGIN;
+
+--Resumable (tus) uploads, chunks are stored as parts until the upload completes
+CREATE TABLE "files_uploads"
+(
+    "id"            uuid      NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+    "owner_id"      VARCHAR   NOT NULL,
+    "filename"      VARCHAR   NOT NULL,
+    "destination"   VARCHAR   NOT NULL,
+    "content_type"  VARCHAR   NOT NULL DEFAULT '',
+    "length"        BIGINT    NOT NULL,
+    "upload_offset" BIGINT    NOT NULL DEFAULT 0,
+    "parts"         INT       NOT NULL DEFAULT 0,
+    "file_id"       uuid,
+    "expires_at"    TIMESTAMP NOT NULL,
+    "created_at"    TIMESTAMP NOT NULL DEFAULT now(),
+    "updated_at"    TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "files_uploads"
+    ADD FOREIGN KEY ("owner_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+ALTER TABLE "files_uploads"
+    ADD FOREIGN KEY ("file_id") REFERENCES "files" ("id") ON DELETE SET NULL;
+
+CREATE INDEX "files_uploads_expires_at_idx" ON "files_uploads" ("expires_at");
+
+CREATE TRIGGER set_updated_at_timestamp_files_uploads_table
+    BEFORE UPDATE
+    ON "files_uploads"
+    FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "files_checksum_idx";
+
+DROP INDEX IF EXISTS "files_orphan_idx";
+CREATE INDEX "files_orphan_idx" ON "files" ("created_at") WHERE "ref_count" = 0;
+
+ALTER TABLE "files"
+    DROP COLUMN IF EXISTS "renditions";
+
+COMMIT;
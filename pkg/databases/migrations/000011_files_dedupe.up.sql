@@ -0,0 +1,13 @@
+BEGIN;
+
+--Files are stored under the sha256 of their content, renditions are kept to answer duplicate uploads
+ALTER TABLE "files"
+    ADD COLUMN "renditions" JSONB NOT NULL DEFAULT '[]'::JSONB;
+
+--A duplicate upload touches the file, orphans are swept once untouched for the grace period
+DROP INDEX IF EXISTS "files_orphan_idx";
+CREATE INDEX "files_orphan_idx" ON "files" ("updated_at") WHERE "ref_count" = 0;
+
+CREATE INDEX "files_checksum_idx" ON "files" ("checksum");
+
+COMMIT;
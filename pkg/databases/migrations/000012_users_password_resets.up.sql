@@ -0,0 +1,19 @@
+BEGIN;
+
+--Only the sha256 of a reset token is stored, the token itself is mailed
+CREATE TABLE "password_resets"
+(
+    "id"         uuid      NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+    "user_id"    VARCHAR   NOT NULL,
+    "token_hash" VARCHAR   NOT NULL UNIQUE,
+    "expires_at" TIMESTAMP NOT NULL,
+    "used_at"    TIMESTAMP,
+    "created_at" TIMESTAMP NOT NULL                    DEFAULT now()
+);
+
+ALTER TABLE "password_resets"
+    ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+CREATE INDEX "password_resets_user_id_idx" ON "password_resets" ("user_id");
+
+COMMIT;
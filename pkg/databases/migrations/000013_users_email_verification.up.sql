@@ -0,0 +1,13 @@
+BEGIN;
+
+ALTER TABLE "users"
+    ADD COLUMN "verified"             BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN "verified_at"          TIMESTAMP,
+    ADD COLUMN "verification_sent_at" TIMESTAMP;
+
+--Accounts created before verification existed are trusted
+UPDATE "users"
+SET "verified"    = TRUE,
+    "verified_at" = now();
+
+COMMIT;
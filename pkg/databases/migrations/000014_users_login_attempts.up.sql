@@ -0,0 +1,12 @@
+BEGIN;
+
+--Failed sign-ins per account (user:<email>) and per ip (ip:<address>)
+CREATE TABLE "login_attempts"
+(
+    "key"            VARCHAR PRIMARY KEY,
+    "failures"       INT       NOT NULL DEFAULT 0,
+    "last_failed_at" TIMESTAMP NOT NULL DEFAULT now(),
+    "locked_until"   TIMESTAMP
+);
+
+COMMIT;
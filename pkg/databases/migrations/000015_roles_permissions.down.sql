@@ -0,0 +1,20 @@
+BEGIN;
+
+DROP TABLE IF EXISTS "roles_permissions";
+DROP TABLE IF EXISTS "permissions";
+
+--Users of added roles fall back to customer
+UPDATE "users"
+SET "role_id" = 1
+WHERE "role_id" > 2;
+
+DELETE FROM "roles"
+WHERE "id" > 2;
+
+SELECT SETVAL ((SELECT PG_GET_SERIAL_SEQUENCE('"roles"', 'id')), 2);
+
+ALTER TABLE "users"
+    DROP CONSTRAINT "users_role_id_fkey",
+    ADD FOREIGN KEY ("role_id") REFERENCES "roles" ("id") ON DELETE CASCADE;
+
+COMMIT;
@@ -0,0 +1,74 @@
+BEGIN;
+
+CREATE TABLE "permissions"
+(
+    "id"          SERIAL PRIMARY KEY,
+    "name"        VARCHAR NOT NULL UNIQUE,
+    "description" VARCHAR NOT NULL DEFAULT ''
+);
+
+CREATE TABLE "roles_permissions"
+(
+    "role_id"       INT NOT NULL,
+    "permission_id" INT NOT NULL,
+    PRIMARY KEY ("role_id", "permission_id")
+);
+
+ALTER TABLE "roles_permissions"
+    ADD FOREIGN KEY ("role_id") REFERENCES "roles" ("id") ON DELETE CASCADE;
+ALTER TABLE "roles_permissions"
+    ADD FOREIGN KEY ("permission_id") REFERENCES "permissions" ("id") ON DELETE CASCADE;
+
+--A role in use must not take its users with it
+ALTER TABLE "users"
+    DROP CONSTRAINT "users_role_id_fkey",
+    ADD FOREIGN KEY ("role_id") REFERENCES "roles" ("id") ON DELETE RESTRICT;
+
+INSERT INTO "roles" (
+    "id",
+    "title"
+)
+VALUES
+    (3, 'staff');
+
+SELECT SETVAL ((SELECT PG_GET_SERIAL_SEQUENCE('"roles"', 'id')), (SELECT MAX("id") FROM "roles"));
+
+INSERT INTO "permissions" (
+    "name",
+    "description"
+)
+VALUES
+    ('products:read:any', 'See drafts, deleted products, imports and price history'),
+    ('products:write', 'Add, update, delete and import products'),
+    ('categories:write', 'Add and delete categories'),
+    ('orders:read:any', 'List the orders of every user'),
+    ('orders:write:any', 'Move orders to any status'),
+    ('files:write', 'Upload and delete files'),
+    ('apikeys:write', 'Generate api keys'),
+    ('users:admin', 'Generate admin tokens and unlock sign-ins'),
+    ('roles:write', 'Manage roles and assign them to users');
+
+--Staff run the shop, admin can do everything
+INSERT INTO "roles_permissions" (
+    "role_id",
+    "permission_id"
+)
+SELECT 3, "id"
+FROM "permissions"
+WHERE "name" IN (
+    'products:read:any',
+    'products:write',
+    'categories:write',
+    'orders:read:any',
+    'orders:write:any',
+    'files:write'
+);
+
+INSERT INTO "roles_permissions" (
+    "role_id",
+    "permission_id"
+)
+SELECT 2, "id"
+FROM "permissions";
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "oauth_refresh_token_idx";
+
+--Hashed refresh tokens can not be restored, every session signs in again
+DELETE FROM "oauth";
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+--Refresh tokens are kept as sha256 hex, only the latest of a session is valid
+UPDATE "oauth"
+SET "refresh_token" = encode(sha256("refresh_token"::bytea), 'hex');
+
+CREATE UNIQUE INDEX "oauth_refresh_token_idx" ON "oauth" ("refresh_token");
+
+COMMIT;
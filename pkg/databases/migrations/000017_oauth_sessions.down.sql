@@ -0,0 +1,11 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "oauth_user_id_idx";
+
+ALTER TABLE "oauth"
+    DROP COLUMN IF EXISTS "last_used_at",
+    DROP COLUMN IF EXISTS "user_agent",
+    DROP COLUMN IF EXISTS "ip",
+    DROP COLUMN IF EXISTS "device";
+
+COMMIT;
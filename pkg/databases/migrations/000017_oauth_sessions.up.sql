@@ -0,0 +1,15 @@
+BEGIN;
+
+--Where a session was signed in from, shown to its user
+ALTER TABLE "oauth"
+    ADD COLUMN "device"       VARCHAR   NOT NULL DEFAULT '',
+    ADD COLUMN "ip"           VARCHAR   NOT NULL DEFAULT '',
+    ADD COLUMN "user_agent"   VARCHAR   NOT NULL DEFAULT '',
+    ADD COLUMN "last_used_at" TIMESTAMP NOT NULL DEFAULT now();
+
+UPDATE "oauth"
+SET "last_used_at" = "updated_at";
+
+CREATE INDEX "oauth_user_id_idx" ON "oauth" ("user_id");
+
+COMMIT;
@@ -0,0 +1,21 @@
+BEGIN;
+
+--An external account of an identity provider linked to a user, the subject
+--is the stable id the provider gives the account
+CREATE TABLE "user_identities"
+(
+    "id"         uuid      NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+    "user_id"    VARCHAR   NOT NULL,
+    "provider"   VARCHAR   NOT NULL,
+    "subject"    VARCHAR   NOT NULL,
+    "email"      VARCHAR,
+    "created_at" TIMESTAMP NOT NULL                    DEFAULT now(),
+    UNIQUE ("provider", "subject")
+);
+
+ALTER TABLE "user_identities"
+    ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+CREATE INDEX "user_identities_user_id_idx" ON "user_identities" ("user_id");
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS files_ref_count_users_table ON "users";
+DROP FUNCTION IF EXISTS users_files_ref_count;
+
+ALTER TABLE "users"
+    DROP COLUMN IF EXISTS "avatar_url",
+    DROP COLUMN IF EXISTS "phone",
+    DROP COLUMN IF EXISTS "display_name";
+
+COMMIT;
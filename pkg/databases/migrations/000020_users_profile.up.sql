@@ -0,0 +1,30 @@
+BEGIN;
+
+ALTER TABLE "users"
+    ADD COLUMN "display_name" VARCHAR,
+    ADD COLUMN "phone"        VARCHAR,
+    ADD COLUMN "avatar_url"   VARCHAR;
+
+--Avatars are referenced by url like product images, a replaced avatar is
+--left to the orphan sweep
+CREATE
+OR REPLACE FUNCTION users_files_ref_count()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND OLD."avatar_url" IS NOT NULL THEN
+        UPDATE "files" SET "ref_count" = GREATEST("ref_count" - 1, 0) WHERE "url" = OLD."avatar_url";
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW."avatar_url" IS NOT NULL THEN
+        UPDATE "files" SET "ref_count" = "ref_count" + 1 WHERE "url" = NEW."avatar_url";
+    END IF;
+RETURN NULL;
+END;
+$$
+language 'plpgsql';
+
+CREATE TRIGGER files_ref_count_users_table
+    AFTER INSERT OR DELETE OR UPDATE OF "avatar_url"
+    ON "users"
+    FOR EACH ROW EXECUTE PROCEDURE users_files_ref_count();
+
+COMMIT;
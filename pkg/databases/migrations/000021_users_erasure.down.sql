@@ -0,0 +1,10 @@
+BEGIN;
+
+ALTER TABLE "orders"
+    DROP CONSTRAINT "orders_user_id_fkey",
+    ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "users"
+    DROP COLUMN IF EXISTS "erased_at";
+
+COMMIT;
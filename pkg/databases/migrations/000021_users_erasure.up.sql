@@ -0,0 +1,12 @@
+BEGIN;
+
+--An erased user is kept as an anonymous row so its orders stay for accounting
+ALTER TABLE "users"
+    ADD COLUMN "erased_at" TIMESTAMP;
+
+--Orders must survive their user, erasure redacts them instead
+ALTER TABLE "orders"
+    DROP CONSTRAINT "orders_user_id_fkey",
+    ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE RESTRICT;
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "users_created_at_idx";
+
+ALTER TABLE "users"
+    DROP COLUMN IF EXISTS "disabled_at";
+
+COMMIT;
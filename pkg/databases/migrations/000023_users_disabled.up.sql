@@ -0,0 +1,9 @@
+BEGIN;
+
+--A disabled user can not sign in and its tokens are refused
+ALTER TABLE "users"
+    ADD COLUMN "disabled_at" TIMESTAMP;
+
+CREATE INDEX "users_created_at_idx" ON "users" ("created_at");
+
+COMMIT;
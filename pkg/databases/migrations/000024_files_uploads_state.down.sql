@@ -0,0 +1,10 @@
+BEGIN;
+
+ALTER TABLE "files_uploads"
+    DROP COLUMN IF EXISTS "write_token",
+    DROP COLUMN IF EXISTS "writing_at",
+    DROP COLUMN IF EXISTS "completing_at",
+    DROP COLUMN IF EXISTS "error",
+    DROP COLUMN IF EXISTS "rejected";
+
+COMMIT;
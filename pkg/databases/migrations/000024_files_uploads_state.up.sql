@@ -0,0 +1,12 @@
+BEGIN;
+
+--A chunk reserves the upload with a write token while it is stored, the
+--last chunk marks the upload completing while the parts are joined
+ALTER TABLE "files_uploads"
+    ADD COLUMN "write_token"   uuid,
+    ADD COLUMN "writing_at"    TIMESTAMP,
+    ADD COLUMN "completing_at" TIMESTAMP,
+    ADD COLUMN "error"         VARCHAR NOT NULL DEFAULT '',
+    ADD COLUMN "rejected"      BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
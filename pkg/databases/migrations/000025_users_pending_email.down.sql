@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE "users"
+    DROP COLUMN IF EXISTS "pending_email";
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+--A changed email is kept pending until the new address is confirmed
+ALTER TABLE "users"
+    ADD COLUMN "pending_email" VARCHAR;
+
+COMMIT;
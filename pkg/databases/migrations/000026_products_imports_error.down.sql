@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE "products_imports"
+    DROP COLUMN IF EXISTS "error";
+
+COMMIT;
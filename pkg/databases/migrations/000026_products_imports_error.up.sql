@@ -0,0 +1,7 @@
+BEGIN;
+
+--Why an import stopped before every row was processed
+ALTER TABLE "products_imports"
+    ADD COLUMN "error" VARCHAR NOT NULL DEFAULT '';
+
+COMMIT;
@@ -0,0 +1,265 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/korvised/go-ecommerce/config"
+	"golang.org/x/image/draw"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+)
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+
+	Original = "original"
+)
+
+// ErrInvalidImage is returned when the upload is not a usable image
+var ErrInvalidImage = errors.New("invalid image")
+
+type Variant struct {
+	Name   string
+	Format string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+func (v *Variant) Ext() string {
+	if v.Format == FormatJPEG {
+		return "jpg"
+	}
+	return v.Format
+}
+
+func (v *Variant) ContentType() string {
+	return "image/" + v.Format
+}
+
+// IsImage reports whether files with the extension go through Process
+func IsImage(ext string) bool {
+	switch strings.ToLower(ext) {
+	case "jpg", "jpeg", "png":
+		return true
+	default:
+		return false
+	}
+}
+
+// Process re-encodes the image without metadata (EXIF, text chunks) and
+// generates every configured rendition, plus a WebP copy of each when enabled.
+// The first variant is always the stripped original.
+func Process(data []byte, cfg config.IImageConfig) ([]*Variant, error) {
+	// Check dimensions before decoding the pixels
+	imgCfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if format != FormatJPEG && format != FormatPNG {
+		return nil, fmt.Errorf("%w: format %s is not supported", ErrInvalidImage, format)
+	}
+	if imgCfg.Width < cfg.MinWidth() || imgCfg.Height < cfg.MinHeight() {
+		return nil, fmt.Errorf("%w: image must be at least %dx%d px", ErrInvalidImage, cfg.MinWidth(), cfg.MinHeight())
+	}
+	if imgCfg.Width > cfg.MaxWidth() || imgCfg.Height > cfg.MaxHeight() {
+		return nil, fmt.Errorf("%w: image must not exceed %dx%d px", ErrInvalidImage, cfg.MaxWidth(), cfg.MaxHeight())
+	}
+	// Decoding allocates every pixel, a small file may still expand to gigabytes
+	if int64(imgCfg.Width)*int64(imgCfg.Height) > int64(cfg.MaxPixels()) {
+		return nil, fmt.Errorf("%w: image must not exceed %d px in total", ErrInvalidImage, cfg.MaxPixels())
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	// EXIF is dropped on encode, so apply its orientation to the pixels first
+	if format == FormatJPEG {
+		img = orient(img, exifOrientation(data))
+	}
+
+	variants := make([]*Variant, 0)
+	add := func(name string, img image.Image) error {
+		b := img.Bounds()
+
+		v := &Variant{Name: name, Format: format, Width: b.Dx(), Height: b.Dy()}
+		if v.Data, err = encode(img, format, cfg.Quality()); err != nil {
+			return err
+		}
+		variants = append(variants, v)
+
+		if cfg.Webp() {
+			w := &Variant{Name: name, Format: FormatWebP, Width: b.Dx(), Height: b.Dy()}
+			if w.Data, err = encode(img, FormatWebP, cfg.Quality()); err != nil {
+				return err
+			}
+			variants = append(variants, w)
+		}
+
+		return nil
+	}
+
+	if err := add(Original, img); err != nil {
+		return nil, err
+	}
+
+	for _, r := range cfg.Renditions() {
+		if err := add(r.Name, resize(img, r.Size)); err != nil {
+			return nil, err
+		}
+	}
+
+	return variants, nil
+}
+
+func encode(img image.Image, format string, quality int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	var err error
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		err = png.Encode(buf, img)
+	case FormatWebP:
+		err = nativewebp.Encode(buf, img, nil)
+	default:
+		err = fmt.Errorf("format %s is not supported", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode %s failed: %v", format, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// resize scales the image down so its longest edge fits size, smaller
+// images are kept as is.
+func resize(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// orient turns the pixels upright according to the EXIF orientation (1-8)
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 cw
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 ccw
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	return dst
+}
+
+// exifOrientation reads the orientation tag from the APP1 segment of a jpeg,
+// 1 (upright) is returned when there is none.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			// Start of scan, no more metadata
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 14 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+
+		i += 2 + length
+	}
+
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[offset : offset+2]))
+	for n := 0; n < entries; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+
+	return 1
+}
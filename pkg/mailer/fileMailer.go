@@ -0,0 +1,56 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/korvised/go-ecommerce/config"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type fileMailer struct {
+	cfg config.IMailConfig
+}
+
+// newFileMailer writes every mail as an .eml file, for local development
+func newFileMailer(cfg config.IMailConfig) (IMailer, error) {
+	if err := os.MkdirAll(cfg.FileDir(), 0o755); err != nil {
+		return nil, fmt.Errorf("create mail dir failed: %v", err)
+	}
+
+	return &fileMailer{cfg: cfg}, nil
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg *Message) error {
+	filename := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102150405"), uuid.NewString()[:8])
+
+	if err := os.WriteFile(filepath.Join(m.cfg.FileDir(), filename), build(m.cfg.From(), msg), 0o644); err != nil {
+		return fmt.Errorf("write mail failed: %v", err)
+	}
+
+	return nil
+}
+
+type stdoutMailer struct {
+	cfg config.IMailConfig
+	mu  sync.Mutex
+}
+
+// newStdoutMailer prints every mail, the default so nothing leaves the machine
+func newStdoutMailer(cfg config.IMailConfig) IMailer {
+	return &stdoutMailer{cfg: cfg}
+}
+
+func (m *stdoutMailer) Send(ctx context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := fmt.Fprintf(os.Stdout, "----- mail -----\n%s\n----------------\n", build(m.cfg.From(), msg)); err != nil {
+		return fmt.Errorf("print mail failed: %v", err)
+	}
+
+	return nil
+}
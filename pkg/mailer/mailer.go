@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/korvised/go-ecommerce/config"
+	"mime"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string // plain text
+}
+
+type IMailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// NewMailer creates the mailer selected by MAIL_DRIVER
+func NewMailer(cfg config.IMailConfig) (IMailer, error) {
+	switch cfg.Driver() {
+	case config.MailSMTP:
+		return newSMTPMailer(cfg)
+	case config.MailFile:
+		return newFileMailer(cfg)
+	case config.MailStdout:
+		return newStdoutMailer(cfg), nil
+	default:
+		return nil, fmt.Errorf("mail driver %s is not supported", cfg.Driver())
+	}
+}
+
+// build formats the message as RFC 5322 with CRLF line endings
+func build(from string, msg *Message) []byte {
+	buf := new(bytes.Buffer)
+
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.Write(bytes.ReplaceAll([]byte(msg.Body), []byte("\n"), []byte("\r\n")))
+
+	return buf.Bytes()
+}
@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/korvised/go-ecommerce/config"
+	"net/smtp"
+)
+
+type smtpMailer struct {
+	cfg  config.IMailConfig
+	auth smtp.Auth
+}
+
+// newSMTPMailer sends through an SMTP relay, STARTTLS is used when offered
+func newSMTPMailer(cfg config.IMailConfig) (IMailer, error) {
+	if cfg.SMTPHost() == "" {
+		return nil, fmt.Errorf("mail smtp host is required")
+	}
+
+	m := &smtpMailer{cfg: cfg}
+	if cfg.SMTPUsername() != "" {
+		m.auth = smtp.PlainAuth("", cfg.SMTPUsername(), cfg.SMTPPassword(), cfg.SMTPHost())
+	}
+
+	return m, nil
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg *Message) error {
+	addr := fmt.Sprintf("%s:%d", m.cfg.SMTPHost(), m.cfg.SMTPPort())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(addr, m.auth, m.cfg.From(), []string{msg.To}, build(m.cfg.From(), msg))
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("send mail failed: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("send mail failed: %v", ctx.Err())
+	}
+}